@@ -4,19 +4,33 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/redis/go-redis/v9"
+	"github.com/ruziba3vich/soand/internal/jwtkeys"
+	"github.com/ruziba3vich/soand/internal/loginguard"
 	"github.com/ruziba3vich/soand/internal/middleware"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/oidc"
+	"github.com/ruziba3vich/soand/internal/otp"
+	"github.com/ruziba3vich/soand/internal/presence"
 	limiter "github.com/ruziba3vich/soand/internal/rate_limiter"
 	"github.com/ruziba3vich/soand/internal/registerar"
+	"github.com/ruziba3vich/soand/internal/repos"
 	"github.com/ruziba3vich/soand/internal/service"
+	"github.com/ruziba3vich/soand/internal/sms"
 	"github.com/ruziba3vich/soand/internal/storage"
 	"github.com/ruziba3vich/soand/pkg/config"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -25,6 +39,10 @@ func Run(ctx context.Context, logger *log.Logger) error {
 	cfg := config.LoadConfig()
 
 	router := gin.Default()
+	// without trusted proxies the client IP is the peer address, X-Forwarded-For could be forged
+	if err := router.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Initialize MinIO client
 	minio_client, err := minio.New(cfg.MinIO.Endpoint, &minio.Options{
@@ -42,10 +60,6 @@ func Run(ctx context.Context, logger *log.Logger) error {
 	file_storage := storage.NewFileStorage(cfg, minio_client)
 	file_store_service := service.NewFileStoreService(file_storage, logger)
 
-	// file getter
-
-	registerar.RegisterFileStorageHandler(router, file_store_service, logger)
-
 	// Background
 	background_collection, err := storage.ConnectMongoDB(ctx, cfg, "background_collection")
 	if err != nil {
@@ -70,14 +84,152 @@ func Run(ctx context.Context, logger *log.Logger) error {
 		return err
 	}
 
+	sessions_collection, err := storage.ConnectMongoDB(ctx, cfg, "sessions_collection")
+	if err != nil {
+		return err
+	}
+	session_storage := storage.NewSessionStorage(sessions_collection)
+	if err := session_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
 	rate_limiter := limiter.NewTokenBucketLimiter(redisClient, 15, 0.25, 1*time.Minute)
 
-	user_storage := storage.NewUserStorage(user_collection, cfg, background_storage)
-	user_service := service.NewUserService(user_storage, logger)
+	jwt_keys, err := loadJWTKeys(cfg, logger)
+	if err != nil {
+		return err
+	}
+	registerar.RegisterJWKSHandler(router, jwt_keys)
+
+	user_storage := storage.NewUserStorage(user_collection, cfg, jwt_keys, background_storage, session_storage)
+	if err := user_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
+	// users created before search existed are indexed in the background, they show up in results once done
+	go func() {
+		updated, err := user_storage.BackfillSearchGrams(context.Background())
+		if err != nil {
+			logger.Printf("Failed to backfill user search index: %v\n", err)
+			return
+		}
+		if updated > 0 {
+			logger.Printf("Indexed %d user(s) for search\n", updated)
+		}
+	}()
+
+	follows_collection, err := storage.ConnectMongoDB(ctx, cfg, "follows_collection")
+	if err != nil {
+		return err
+	}
+	follows_storage := storage.NewFollowStorage(follows_collection, user_collection)
+	if err := follows_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
+	blocks_collection, err := storage.ConnectMongoDB(ctx, cfg, "blocks_collection")
+	if err != nil {
+		return err
+	}
+	blocks_storage := storage.NewBlockStorage(blocks_collection, user_collection)
+	if err := blocks_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
 
-	authMiddleware := middleware.NewAuthHandler(user_service, logger, rate_limiter)
+	var sms_sender repos.SMSSender
+	switch cfg.SMS.Sender {
+	case "file":
+		sms_sender = sms.NewFileSender(cfg.SMS.OutboxFile)
+	default:
+		sms_sender = sms.NewLogSender(logger)
+	}
+	otp_service := otp.NewService(redisClient, sms_sender, cfg.OTP.TTL, cfg.OTP.MaxAttempts, cfg.OTP.ResendCooldown)
 
-	registerar.RegisterUserRoutes(router, user_service, file_store_service, logger, authMiddleware.AuthMiddleware())
+	login_audit_collection, err := storage.ConnectMongoDB(ctx, cfg, "login_audit_collection")
+	if err != nil {
+		return err
+	}
+	login_audit_storage := storage.NewLoginAuditStorage(login_audit_collection, cfg.Login.AuditRetention)
+	if err := login_audit_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
+	login_guard := loginguard.NewGuard(redisClient, loginguard.Limits{
+		MaxUserFailures: cfg.Login.MaxUserFailures,
+		MaxIPFailures:   cfg.Login.MaxIPFailures,
+		Window:          cfg.Login.FailureWindow,
+		BaseLockout:     cfg.Login.BaseLockout,
+		MaxLockout:      cfg.Login.MaxLockout,
+	})
+
+	user_service := service.NewUserService(user_storage, follows_storage, blocks_storage, otp_service, login_guard, login_audit_storage, logger)
+
+	api_keys_collection, err := storage.ConnectMongoDB(ctx, cfg, "api_keys_collection")
+	if err != nil {
+		return err
+	}
+	api_key_storage := storage.NewAPIKeyStorage(api_keys_collection)
+	if err := api_key_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+	api_key_service := service.NewAPIKeyService(api_key_storage, user_storage, logger)
+
+	authMiddleware := middleware.NewAuthHandler(user_service, api_key_service, logger, rate_limiter)
+
+	// file getter, uploads record their uploader
+
+	registerar.RegisterFileStorageHandler(router, file_store_service, logger, authMiddleware.RequireAnyScope(models.ScopePostsWrite, models.ScopeCommentsWrite))
+
+	registerar.RegisterUserRoutes(router, user_service, file_store_service, logger, authMiddleware.AuthMiddleware(), authMiddleware.CommentsMiddleware(), authMiddleware.RateLimitMiddleware())
+
+	if err := bootstrapAdmins(ctx, user_storage, cfg.Auth.AdminUserIDs, logger); err != nil {
+		return err
+	}
+
+	// pushes notifications the users did not turn off in their settings
+	notifier := service.NewNotifier(user_storage, redisClient, logger)
+
+	follow_service := service.NewFollowService(follows_storage, blocks_storage, user_storage, notifier, logger)
+	registerar.RegisterFollowHandler(router, follow_service, logger, authMiddleware.AuthMiddleware(), authMiddleware.CommentsMiddleware())
+
+	registerar.RegisterAPIKeyHandler(router, api_key_service, logger, authMiddleware.AuthMiddleware())
+
+	var oidc_providers []*oidc.Provider
+	oidc_client := &http.Client{Timeout: 10 * time.Second}
+	for _, provider := range cfg.OIDC.Providers {
+		oidc_providers = append(oidc_providers, oidc.NewProvider(oidc.Config{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		}, oidc_client))
+	}
+	oidc_states := oidc.NewStateStore(redisClient, cfg.OIDC.StateTTL)
+	oidc_service := service.NewOIDCService(oidc_providers, oidc_states, user_storage, logger)
+	registerar.RegisterOIDCHandler(router, oidc_service, logger, authMiddleware.AuthMiddleware(), authMiddleware.RateLimitMiddleware())
+
+	block_service := service.NewBlockService(blocks_storage, follows_storage, user_storage, redisClient, logger)
+	registerar.RegisterBlockHandler(router, block_service, logger, authMiddleware.AuthMiddleware())
+
+	presence_tracker := presence.NewTracker(redisClient, cfg.Presence.TTL)
+	presence_service := service.NewPresenceService(presence_tracker, user_storage, blocks_storage, redisClient, cfg.Presence.Heartbeat, logger)
+	registerar.RegisterPresenceHandler(router, presence_service, logger, authMiddleware.AuthMiddleware())
+
+	admin_service := service.NewAdminService(user_storage, logger)
+	registerar.RegisterAdminHandler(router, admin_service, logger, authMiddleware.RequireRole(models.RoleAdmin))
+
+	verifications_collection, err := storage.ConnectMongoDB(ctx, cfg, "verification_requests_collection")
+	if err != nil {
+		return err
+	}
+	verification_storage := storage.NewVerificationStorage(verifications_collection)
+	if err := verification_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+	verification_service := service.NewVerificationService(verification_storage, user_storage, logger)
+	registerar.RegisterVerificationHandler(router, verification_service, logger, authMiddleware.AuthMiddleware(), authMiddleware.RequireRole(models.RoleModerator))
 
 	// likes
 	likes_collection, err := storage.ConnectMongoDB(ctx, cfg, "likes_collection")
@@ -121,75 +273,193 @@ func Run(ctx context.Context, logger *log.Logger) error {
 	}
 
 	posts_storage := storage.NewStorage(posts_collection, user_storage)
-	posts_service := service.NewPostService(posts_storage, likes_storage, file_store_service, logger)
 
+	post_revisions_collection, err := storage.ConnectMongoDB(ctx, cfg, "post_revisions_collection")
+	if err != nil {
+		return err
+	}
+	post_revision_storage := storage.NewPostRevisionStorage(post_revisions_collection)
+	if err := post_revision_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
+	// everything attached to a post, deleting one cascades to it
+
+	comments_collection, err := storage.ConnectMongoDB(ctx, cfg, "comments_collection")
+	if err != nil {
+		logger.Println("Error connecting to comments collection:", err)
+		return err
+	}
+	comments_storage := storage.NewCommentStorage(comments_collection)
+
+	pinnedChatsCollection, err := storage.ConnectMongoDB(ctx, cfg, "posts_collection")
+	if err != nil {
+		return err
+	}
+	pinnedChatStorage := storage.NewPinnedChat(pinnedChatsCollection)
+
+	chat_collection, err := storage.ConnectMongoDB(ctx, cfg, "chat_collection")
+	if err != nil {
+		return err
+	}
+	chat_storage := storage.NewChatStorage(chat_collection)
+
+	drafts_collection, err := storage.ConnectMongoDB(ctx, cfg, "drafts_collection")
+	if err != nil {
+		return err
+	}
+	drafts_storage := storage.NewDraftStorage(drafts_collection)
+	if err := drafts_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
+	// where expired posts are kept, the account deletion and export cover them too
+	var post_archiver repos.PostArchiver
+	switch cfg.Post.Archive {
+	case "collection":
+		archived_posts_collection, err := storage.ConnectMongoDB(ctx, cfg, "archived_posts_collection")
+		if err != nil {
+			return err
+		}
+		archived_comments_collection, err := storage.ConnectMongoDB(ctx, cfg, "archived_comments_collection")
+		if err != nil {
+			return err
+		}
+		archive_storage := storage.NewPostArchiveStorage(archived_posts_collection, archived_comments_collection)
+		if err := archive_storage.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+		post_archiver = archive_storage
+	case "file":
+		post_archiver = storage.NewFilePostArchive(file_storage)
+	case "":
+	default:
+		return fmt.Errorf("unknown POST_ARCHIVE %q, use collection or file", cfg.Post.Archive)
+	}
+
+	cleanup := service.NewCleanup(posts_storage, comments_storage, likes_storage, pinnedChatStorage, chat_storage, follows_storage, blocks_storage, api_key_storage, verification_storage, drafts_storage, post_revision_storage, post_archiver, file_storage, logger)
+
+	posts_service := service.NewPostService(posts_storage, likes_storage, user_storage, post_revision_storage, cleanup, file_store_service, redisClient, cfg.Post, logger)
+
+	// every authenticated post route writes, so bots can use them with a posts:write key
 	registerar.RegisterPostRoutes(
 		router,
 		posts_service,
 		logger,
-		authMiddleware.AuthMiddleware(),
+		authMiddleware.RequireScope(models.ScopePostsWrite),
 	)
 
-	if err := posts_storage.EnsureTTLIndex(ctx); err != nil {
+	if err := posts_storage.EnsureExpiryIndex(ctx); err != nil {
 		return err
 	}
 
-	// pinned chats
+	// drafts and scheduled posts
 
-	pinnedChatsCollection, err := storage.ConnectMongoDB(ctx, cfg, "posts_collection")
-	if err != nil {
-		return err
-	}
+	draft_service := service.NewDraftService(drafts_storage, posts_service, file_store_service, logger)
+	registerar.RegisterDraftHandler(router, draft_service, logger, authMiddleware.RequireScope(models.ScopePostsWrite))
+	draft_scheduler := service.NewDraftScheduler(drafts_storage, posts_service, notifier, logger)
+
+	// pinned chats
 
-	pinnedChatStorage := storage.NewPinnedChat(pinnedChatsCollection)
 	pinnedChatService := service.NewPinnedChatService(pinnedChatStorage, posts_service, logger)
 
 	registerar.RegisterPinnedChatsHandler(router, pinnedChatService, authMiddleware.AuthMiddleware(), logger)
 
 	// Comments
-	comments_collection, err := storage.ConnectMongoDB(ctx, cfg, "comments_collection")
-	if err != nil {
-		logger.Println("Error connecting to comments collection:", err)
-		return err
-	}
-
-	comments_storage := storage.NewCommentStorage(comments_collection)
-	comments_service := service.NewCommentService(comments_storage, user_storage, file_store_service, redisClient, logger)
+	comments_service := service.NewCommentService(comments_storage, posts_storage, user_storage, blocks_storage, file_store_service, notifier, redisClient, logger)
 
 	registerar.RegisterCommentRoutes(
 		router,
 		comments_service,
+		presence_service,
 		file_store_service,
 		logger,
 		redisClient,
-		authMiddleware.AuthMiddleware(),
-		authMiddleware.WebSocketAuthMiddleware(),
+		authMiddleware.RequireScope(models.ScopeCommentsWrite),
+		authMiddleware.WebSocketRequireScope(models.ScopeCommentsWrite),
 		authMiddleware.CommentsMiddleware(),
 	)
 
-	registerar.RegisterBackgroundHandler(router, background_service, logger)
+	registerar.RegisterBackgroundHandler(router, background_service, logger, authMiddleware.RequireRole(models.RoleAdmin))
 
 	// direct messages
 
-	chat_collection, err := storage.ConnectMongoDB(ctx, cfg, "chat_collection")
-	if err != nil {
-		return err
-	}
-	chat_storage := storage.NewChatStorage(chat_collection)
-	chat_service := service.NewChatService(chat_storage, logger)
+	chat_service := service.NewChatService(chat_storage, blocks_storage, follows_storage, user_storage, notifier, logger)
 	registerar.RegisterChatHandler(
 		router,
 		chat_service,
+		presence_service,
 		file_store_service,
 		logger,
 		redisClient,
-		authMiddleware.AuthMiddleware(),
-		authMiddleware.WebSocketAuthMiddleware(),
+		authMiddleware.RequireScope(models.ScopeChatRead),
+		authMiddleware.WebSocketRequireScope(models.ScopeChatRead),
+		authMiddleware.WebSocketRequireScope(models.ScopeChatWrite),
 	)
 
+	// background jobs
+
+	jobs_collection, err := storage.ConnectMongoDB(ctx, cfg, "jobs_collection")
+	if err != nil {
+		return err
+	}
+	jobs_storage := storage.NewJobStorage(jobs_collection)
+	if err := jobs_storage.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+	job_runner := service.NewJobRunner(jobs_storage, logger)
+
+	exporter := service.NewExporter(user_storage, session_storage, posts_storage, comments_storage, likes_storage, pinnedChatStorage, chat_storage, follows_storage, blocks_storage, api_key_storage, verification_storage, drafts_storage, post_revision_storage, post_archiver, file_storage, logger)
+
+	// the bucket drops old archives by itself, a failure here only means they are kept longer
+	if err := file_storage.ExpireFilesWithPrefix(ctx, service.ExportsPrefix, cfg.Export.RetentionDays); err != nil {
+		logger.Printf("Warning: %v\n", err)
+	}
+
+	account_service := service.NewAccountService(user_storage, session_storage, jobs_storage, cleanup, exporter, file_storage, cfg.Export.LinkTTL, job_runner, logger)
+	registerar.RegisterAccountHandler(router, account_service, logger, authMiddleware.AuthMiddleware())
+
+	purge_sweeper := service.NewPurgeSweeper(user_storage, jobs_storage, logger)
+
+	expiry_sweeper := service.NewExpirySweeper(posts_storage, comments_storage, cleanup, post_archiver, notifier, redisClient, logger)
+
+	// ctx only bounds startup, the runner, sweepers and scheduler live as long as the process
+	go job_runner.Run(context.Background())
+	go purge_sweeper.Run(context.Background(), cfg.Account.PurgeAfter, time.Hour)
+	go expiry_sweeper.Run(context.Background(), cfg.Post.ExpiryWarning, cfg.Post.SweepInterval)
+	go draft_scheduler.Run(context.Background())
+
 	return router.Run(":7777")
 }
 
+// loadJWTKeys loads the signing keys from cfg.Auth.KeysDir and reloads them on SIGHUP.
+// Without any keys an ephemeral one is generated so local setups keep working.
+func loadJWTKeys(cfg *config.Config, logger *log.Logger) (*jwtkeys.KeySet, error) {
+	keys, err := jwtkeys.Load(cfg.Auth.KeysDir, cfg.Auth.ActiveKID)
+	if err != nil {
+		if cfg.Auth.ActiveKID != "" {
+			return nil, fmt.Errorf("failed to load JWT keys: %s", err.Error())
+		}
+		logger.Printf("WARNING: %s; signing with an ephemeral key, tokens will not survive a restart. Run cmd/keygen to create one", err)
+		return jwtkeys.NewEphemeral()
+	}
+	logger.Printf("JWT keys loaded, active kid %s", keys.ActiveKID())
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := keys.Reload(); err != nil {
+				logger.Println("failed to reload JWT keys, keeping the old ones:", err)
+				continue
+			}
+			logger.Printf("JWT keys reloaded, active kid %s", keys.ActiveKID())
+		}
+	}()
+
+	return keys, nil
+}
+
 // Ensure bucket exists
 func createBucket(client *minio.Client, bucket string) error {
 	exists, err := client.BucketExists(context.Background(), bucket)
@@ -202,3 +472,37 @@ func createBucket(client *minio.Client, bucket string) error {
 	}
 	return nil
 }
+
+// bootstrapAdmins promotes the users listed in ADMIN_USER_IDS while there is no admin yet. Once
+// there is one, admins are managed through the admin endpoints and the list is ignored, so a
+// demoted admin stays demoted.
+func bootstrapAdmins(ctx context.Context, users *storage.UserStorage, hexIDs []string, logger *log.Logger) error {
+	if len(hexIDs) == 0 {
+		return nil
+	}
+
+	admins, err := users.CountByRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins > 0 {
+		logger.Println("WARNING: ADMIN_USER_IDS is ignored because an admin exists, unset it")
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(hexIDs))
+	for _, hexID := range hexIDs {
+		id, err := primitive.ObjectIDFromHex(hexID)
+		if err != nil {
+			return fmt.Errorf("invalid user ID %q in ADMIN_USER_IDS", hexID)
+		}
+		ids = append(ids, id)
+	}
+
+	promoted, err := users.GrantRole(ctx, ids, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	logger.Printf("WARNING: no admin existed, promoted %d of %d user(s) from ADMIN_USER_IDS to admin: %s\n", promoted, len(ids), strings.Join(hexIDs, ", "))
+	return nil
+}
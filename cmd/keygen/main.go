@@ -0,0 +1,67 @@
+// Command keygen writes a new JWT signing key into the keys directory.
+//
+//	go run ./cmd/keygen -dir keys -alg EdDSA
+//
+// The printed kid is what JWT_ACTIVE_KID should be set to once the key has
+// been published; see package jwtkeys for the full rotation procedure.
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "keys", "directory the key is written to")
+	alg := flag.String("alg", "EdDSA", "key algorithm: EdDSA or RS256")
+	bits := flag.Int("bits", 3072, "RSA key size, only used with -alg RS256")
+	flag.Parse()
+
+	var private crypto.Signer
+	var err error
+	switch *alg {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, *bits)
+	default:
+		log.Fatalf("unsupported algorithm %q, use EdDSA or RS256", *alg)
+	}
+	if err != nil {
+		log.Fatalf("failed to generate key: %s", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		log.Fatalf("failed to encode key: %s", err)
+	}
+
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Fatalf("failed to generate key id: %s", err)
+	}
+	kid := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		log.Fatalf("failed to create keys directory: %s", err)
+	}
+
+	path := filepath.Join(*dir, kid+".pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		log.Fatalf("failed to write key: %s", err)
+	}
+
+	fmt.Printf("wrote %s\nkid: %s\n", path, kid)
+}
@@ -0,0 +1,252 @@
+// Command mockoidc is an OpenID Connect provider for trying out and testing the OIDC login locally.
+//
+//	go run ./cmd/mockoidc -addr :9999 -client-id soand -client-secret secret
+//
+// It approves every authorization request without asking anything. The user logged in as is
+// taken from the login_hint parameter (alice by default), so different users can be simulated
+// by appending &login_hint=bob to the authorization URL. Configure soand with OIDC_PROVIDERS=mock
+// and the OIDC_MOCK_* settings from example.env.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/ruziba3vich/soand/internal/jwtkeys"
+)
+
+const (
+	keyID   = "mock"
+	codeTTL = time.Minute
+)
+
+// grant is an issued authorization code waiting to be redeemed
+type grant struct {
+	user        string
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	expires     time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*grant
+}
+
+func main() {
+	addr := flag.String("addr", ":9999", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9999", "issuer URL, as soand reaches this server")
+	clientID := flag.String("client-id", "soand", "client ID soand is registered with")
+	clientSecret := flag.String("client-secret", "secret", "client secret, empty for a public client")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("failed to generate key: %s", err)
+	}
+
+	p := &provider{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        map[string]*grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+
+	log.Printf("mock OIDC provider %s listening on %s", p.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize logs the user in right away and sends them back with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	target, err := url.Parse(redirectURI)
+	if err != nil || redirectURI == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	back := target.Query()
+	back.Set("state", query.Get("state"))
+	fail := func(reason string) {
+		back.Set("error", reason)
+		target.RawQuery = back.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+
+	if query.Get("client_id") != p.clientID {
+		fail("unauthorized_client")
+		return
+	}
+	if query.Get("response_type") != "code" {
+		fail("unsupported_response_type")
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		fail("invalid_request")
+		return
+	}
+
+	user := query.Get("login_hint")
+	if user == "" {
+		user = "alice"
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = &grant{
+		user:        user,
+		clientID:    p.clientID,
+		redirectURI: redirectURI,
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		expires:     time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	log.Printf("authorized %s", user)
+	back.Set("code", code)
+	target.RawQuery = back.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token redeems a code for an ID token, once
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || clientSecret != p.clientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	g, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !found || time.Now().After(g.expires) || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.issuer,
+		"aud":                g.clientID,
+		"sub":                "mock-" + g.user,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              g.nonce,
+		"email":              g.user + "@example.com",
+		"email_verified":     true,
+		"name":               strings.ToUpper(g.user[:1]) + g.user[1:],
+		"preferred_username": g.user,
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		log.Printf("failed to sign ID token: %s", err)
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	public := p.key.PublicKey
+	writeJSON(w, http.StatusOK, jwtkeys.JWKS{Keys: []jwtkeys.JWK{{
+		Kty: "RSA",
+		Kid: keyID,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}}})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("failed to generate random string: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -1,5 +1,10 @@
 package dto
 
+import "errors"
+
+// ErrFileNotOwned is returned when a post, draft or comment refers to a file the user did not upload
+var ErrFileNotOwned = errors.New("you can only attach files you uploaded")
+
 type FileObject struct {
 	FIlename string `json:"file_name"`
 	FileUrl  string `json:"file_url"`
@@ -0,0 +1,5 @@
+package dto
+
+import "errors"
+
+var ErrJobNotFound = errors.New("job not found")
@@ -2,6 +2,9 @@ package dto
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ruziba3vich/soand/internal/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,7 +14,7 @@ import (
 type PostRequest struct {
 	Description string   `json:"description" binding:"required"`
 	CreatorId   string   `json:"creator_id"`
-	DeleteAfter int      `json:"delete_after" binding:"required"`
+	DeleteAfter int      `json:"delete_after"` // hours, the creator's default post lifetime when left out
 	Title       string   `json:"title"`
 	Tags        []string `json:"tags"`
 	Pics        []string `json:"pics"`
@@ -29,8 +32,137 @@ func (p *PostRequest) ToPost() *models.Post {
 	}
 }
 
+// Validate checks delete_after against the longest lifetime posts may have, in hours
+func (p *PostRequest) Validate(maxHours int) error {
+	return validateLifetime(p.DeleteAfter, 0, maxHours)
+}
+
+// PostUpdateRequest edits the content of a post, fields left out keep their value. Version is the
+// version of the post the edit was made on, the edit is refused if the post was edited since.
+type PostUpdateRequest struct {
+	Version     *int      `json:"version" binding:"required"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Pics        *[]string `json:"pics"`
+}
+
+// Validate checks that the edit changes something and keeps the description
+func (r *PostUpdateRequest) Validate() error {
+	if r.Title == nil && r.Description == nil && r.Tags == nil && r.Pics == nil {
+		return ErrEmptyPostUpdate
+	}
+	if r.Description != nil && strings.TrimSpace(*r.Description) == "" {
+		return ErrDescriptionRequired
+	}
+	return nil
+}
+
+// Actions of a PostLifetimeRequest
+const (
+	LifetimeExtend    = "extend"    // push the expiry back by Hours
+	LifetimeRenew     = "renew"     // expire Hours from now, this also makes a permanent post expire again
+	LifetimePermanent = "permanent" // never expire
+)
+
+// PostLifetimeRequest changes when a post expires
+type PostLifetimeRequest struct {
+	Action string `json:"action" binding:"required"` // extend, renew or permanent
+	Hours  int    `json:"hours"`                     // required to extend or renew
+}
+
+// Validate checks the action and, for extend and renew, the hours against the longest lifetime posts may have
+func (r *PostLifetimeRequest) Validate(maxHours int) error {
+	switch r.Action {
+	case LifetimeExtend, LifetimeRenew:
+		return validateLifetime(r.Hours, 1, maxHours)
+	case LifetimePermanent:
+		return nil
+	default:
+		return ErrInvalidLifetimeAction
+	}
+}
+
+func validateLifetime(hours, minHours, maxHours int) error {
+	if hours < minHours || hours > maxHours {
+		return fmt.Errorf("%w, it must be between 1 and %d hours", ErrInvalidPostLifetime, maxHours)
+	}
+	return nil
+}
+
+// DraftRequest is the payload for creating or editing a draft. Nothing is required until the
+// draft is published or scheduled, then it needs a description like any post.
+type DraftRequest struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Tags        []string   `json:"tags"`
+	Pics        []string   `json:"pics"`
+	DeleteAfter int        `json:"delete_after"` // hours the post lives once published, the creator's default post lifetime when left out
+	PublishAt   *time.Time `json:"publish_at"`   // when to publish the post, it stays a draft without one
+}
+
+// Validate checks delete_after against the longest lifetime posts may have, in hours, and that a
+// scheduled draft is complete and scheduled for the future
+func (r *DraftRequest) Validate(maxHours int) error {
+	if err := validateLifetime(r.DeleteAfter, 0, maxHours); err != nil {
+		return err
+	}
+	if r.PublishAt != nil {
+		if r.Description == "" {
+			return ErrDescriptionRequired
+		}
+		if !r.PublishAt.After(time.Now()) {
+			return ErrInvalidPublishTime
+		}
+	}
+	return nil
+}
+
+// ToDraft converts DraftRequest to models.Draft, scheduled if it has a publish time
+func (r *DraftRequest) ToDraft() *models.Draft {
+	draft := &models.Draft{
+		Title:       r.Title,
+		Description: r.Description,
+		Tags:        r.Tags,
+		Pictures:    r.Pics,
+		DeleteAfter: r.DeleteAfter,
+		Status:      models.DraftUnscheduled,
+		PublishAt:   r.PublishAt,
+	}
+	if draft.Tags == nil {
+		draft.Tags = []string{}
+	}
+	if draft.Pictures == nil {
+		draft.Pictures = []string{}
+	}
+	if r.PublishAt != nil {
+		draft.Status = models.DraftScheduled
+	}
+	return draft
+}
+
 var ErrNotReacted = errors.New("user has not reacted")
 
+var (
+	ErrInvalidPostLifetime   = errors.New("invalid post lifetime")
+	ErrInvalidLifetimeAction = errors.New("action must be one of extend, renew or permanent")
+	ErrPermanentPostsOff     = errors.New("posts cannot be made permanent on this server")
+	ErrPostPermanent         = errors.New("this post is permanent, renew it to give it an expiry again")
+	ErrPostExpired           = errors.New("this post has expired")
+	ErrNotPostCreator        = errors.New("only the creator can change this post")
+	ErrPostDeleteForbidden   = errors.New("only the creator or a moderator can delete this post")
+	ErrPostChanged           = errors.New("the post was changed at the same time, try again")
+	ErrEmptyPostUpdate       = errors.New("nothing to update, set title, description, tags or pics")
+	ErrPostVersionConflict   = errors.New("the post was edited since this version, reload it and try again")
+
+	ErrDraftNotFound       = errors.New("draft not found")
+	ErrDescriptionRequired = errors.New("a post needs a description")
+	ErrInvalidPublishTime  = errors.New("publish_at must be in the future")
+	ErrDraftPublishing     = errors.New("the draft is being published, try again shortly")
+	ErrDraftNotScheduled   = errors.New("the draft is not scheduled")
+	ErrTooManyDrafts       = errors.New("too many drafts, publish or delete some first")
+)
+
 /*
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`                // MongoDB ObjectID
     CreatorId   primitive.ObjectID `bson:"creator_id,omitempty" json:"creator_id"` // Creator id
@@ -0,0 +1,105 @@
+package dto
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+)
+
+// SignupRequest is what a client may set when signing up, everything else on a user is set by the server
+type SignupRequest struct {
+	Fullname string  `json:"full_name" binding:"required"`
+	Username *string `json:"username"`
+	Password string  `json:"password" binding:"required"`
+	Phone    string  `json:"phone"`
+}
+
+// ToUser converts SignupRequest to models.User
+func (r *SignupRequest) ToUser() *models.User {
+	return &models.User{
+		Fullname: r.Fullname,
+		Username: r.Username,
+		Password: r.Password,
+		Phone:    r.Phone,
+	}
+}
+
+var (
+	ErrTwoFactorLocked      = errors.New("too many invalid codes, try again later")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+	ErrCodeExpired     = errors.New("code is invalid or has expired")
+	ErrInvalidCode     = errors.New("invalid code")
+	ErrTooManyAttempts = errors.New("too many attempts, request a new code")
+	ErrResendTooEarly  = errors.New("a code was sent recently, wait before requesting another one")
+	ErrPhoneTaken      = errors.New("this phone number is already in use")
+
+	ErrUserNotFound  = errors.New("user not found")
+	ErrInvalidRole   = errors.New("role must be one of user, moderator or admin")
+	ErrOwnRoleChange = errors.New("you cannot change your own role")
+
+	ErrAccountDeactivated = errors.New("this account is deactivated, reactivate it to log in")
+	ErrNotDeactivated     = errors.New("this account is not deactivated")
+
+	ErrSelfFollow          = errors.New("you cannot follow yourself")
+	ErrNotFollowing        = errors.New("you are not following this user")
+	ErrFollowRequestAbsent = errors.New("follow request not found")
+	ErrPrivateAccount      = errors.New("this account is private")
+
+	ErrSelfBlock  = errors.New("you cannot block yourself")
+	ErrNotBlocked = errors.New("you have not blocked this user")
+	ErrBlocked    = errors.New("you cannot interact with this user")
+
+	ErrEmptySearchQuery = errors.New("search query cannot be empty")
+
+	ErrInvalidAudience     = errors.New("audience must be one of everyone, followers or nobody")
+	ErrInvalidPostTTL      = errors.New("default post lifetime must be between 1 and 720 hours")
+	ErrInvalidUsername     = errors.New("username cannot be empty")
+	ErrUsernameTaken       = errors.New("this username is taken or was released recently")
+	ErrUsernameChangeLimit = errors.New("you have changed your username too many times recently, try again later")
+
+	ErrPasswordTooShort  = errors.New("password is too short")
+	ErrPasswordTooLong   = errors.New("password is too long")
+	ErrPasswordTooCommon = errors.New("password is too common, choose a less guessable one")
+
+	ErrMessagesNotAllowed = errors.New("this user does not accept messages from you")
+
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrLoginLocked        = errors.New("too many failed logins, try again later")
+	ErrRecentLoginNeeded  = errors.New("log in again, or give a two-factor code, to confirm this")
+
+	ErrInvalidKeyName   = errors.New("API key name must be 1 to 64 characters")
+	ErrInvalidScope     = errors.New("unknown API key scope")
+	ErrNoScopes         = errors.New("an API key needs at least one scope")
+	ErrInvalidKeyExpiry = errors.New("expires_in_days must be between 0 and 365")
+	ErrTooManyAPIKeys   = errors.New("you have too many API keys, revoke one first")
+	ErrAPIKeyNotFound   = errors.New("API key not found")
+	ErrInvalidAPIKey    = errors.New("API key is invalid or has expired")
+
+	ErrUnknownProvider       = errors.New("unknown login provider")
+	ErrInvalidOIDCState      = errors.New("login attempt is invalid or has expired, start again")
+	ErrIdentityTaken         = errors.New("this external account is linked to another user")
+	ErrIdentityAlreadyLinked = errors.New("you already linked an account at this provider")
+	ErrIdentityNotLinked     = errors.New("you have not linked an account at this provider")
+	ErrLastLoginMethod       = errors.New("set a password before unlinking your only login method")
+	ErrExternalLoginFailed   = errors.New("login at the provider failed")
+
+	ErrAlreadyVerified         = errors.New("this account is already verified")
+	ErrVerificationPending     = errors.New("a verification request is already pending")
+	ErrVerificationTooSoon     = errors.New("your last verification request was rejected recently, try again later")
+	ErrInvalidVerificationNote = errors.New("note must be 1 to 1000 characters")
+	ErrVerificationNotFound    = errors.New("verification request not found")
+	ErrVerificationReviewed    = errors.New("this verification request was reviewed already")
+	ErrRejectionReasonRequired = errors.New("a reason is required to reject a verification request")
+	ErrOwnVerification         = errors.New("you cannot review your own verification request")
+)
+
+// LoginLockedError is returned while logins are locked out after too many failures
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string { return ErrLoginLocked.Error() }
+
+func (e *LoginLockedError) Unwrap() error { return ErrLoginLocked }
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	_ "github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AccountHandler handles account-wide requests that run as background jobs
+type AccountHandler struct {
+	service repos.IAccountService
+	logger  *log.Logger
+}
+
+// NewAccountHandler creates a new AccountHandler instance
+func NewAccountHandler(service repos.IAccountService, logger *log.Logger) *AccountHandler {
+	return &AccountHandler{service: service, logger: logger}
+}
+
+// DeleteAccount queues the deletion of the authenticated user's account
+// @Summary Delete the authenticated user's account
+// @Description Queues a background job that deletes the account and everything it owns: posts with their comments, likes and pictures, comments, reactions, likes, pinned chats, direct messages, follows, blocks and profile pictures.
+// @Description New logins are blocked and other devices are logged out right away. The current token keeps working until the job finishes, so its progress can be followed at /users/jobs/{id}.
+// @Tags account
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Description Users without a password, who sign in through an identity provider, confirm with a two-factor code instead, or within a few minutes of logging in.
+// @Param request body object{password=string,code=string} true "Current password, or for users without one an optional TOTP or recovery code, to confirm the deletion"
+// @Success 202 {object} swagger.Response{data=models.Job} "Deletion job"
+// @Failure 400 {object} map[string]string "Invalid request body, wrong password or code, or the login is not recent enough"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/me [delete]
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := getSessionIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	job, err := h.service.RequestAccountDeletion(c.Request.Context(), userID, sessionID, request.Password, request.Code)
+	if err != nil {
+		h.logger.Printf("Error requesting account deletion: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": job})
+}
+
+// DeactivateAccount deactivates the authenticated user's account
+// @Summary Deactivate the authenticated user's account
+// @Description Hides the profile, shows the user's posts and comments anonymously and logs out every device, including the current one.
+// @Description Logging in through /users/reactivate undoes it. Accounts that stay deactivated are deleted for good after a grace period.
+// @Tags account
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Description Users without a password, who sign in through an identity provider, confirm with a two-factor code instead, or within a few minutes of logging in.
+// @Param request body object{password=string,code=string} true "Current password, or for users without one an optional TOTP or recovery code, to confirm the deactivation"
+// @Success 200 {object} map[string]string "Account deactivated"
+// @Failure 400 {object} map[string]string "Invalid request body, wrong password or code, the login is not recent enough or already deactivated"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/me/deactivate [post]
+func (h *AccountHandler) DeactivateAccount(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := getSessionIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.service.DeactivateAccount(c.Request.Context(), userID, sessionID, request.Password, request.Code); err != nil {
+		h.logger.Printf("Error deactivating account: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "account deactivated"})
+}
+
+// RequestExport queues an archive of everything stored about the authenticated user
+// @Summary Export the authenticated user's data
+// @Description Queues a background job that collects the profile, settings, profile pictures, sessions, posts, comments, direct messages, likes, pinned chats, follows and blocks into a ZIP archive of JSON files and original media.
+// @Description Follow the job at /users/jobs/{id}; once it is completed the response carries a time-limited download_url. While an export is still running, the same job is returned.
+// @Tags account
+// @Security BearerAuth
+// @Produce json
+// @Success 202 {object} swagger.Response{data=models.Job} "Export job"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to queue the export"
+// @Router /users/me/export [post]
+func (h *AccountHandler) RequestExport(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	job, err := h.service.RequestExport(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Error requesting data export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue the export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": job})
+}
+
+// GetJob reports the status of one of the authenticated user's background jobs
+// @Summary Get a background job
+// @Description Returns the status and progress (step, steps_done of steps_total) of a job of the authenticated user. Completed exports include a time-limited download_url.
+// @Tags account
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} swagger.Response{data=models.Job} "Job status"
+// @Failure 400 {object} map[string]string "Invalid job ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Job not found"
+// @Router /users/jobs/{id} [get]
+func (h *AccountHandler) GetJob(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	jobID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := h.service.GetJob(c.Request.Context(), userID, jobID)
+	if err != nil {
+		if errors.Is(err, dto.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	_ "github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminHandler handles requests that only admins may make
+type AdminHandler struct {
+	service repos.IAdminService
+	logger  *log.Logger
+}
+
+// NewAdminHandler creates a new AdminHandler instance
+func NewAdminHandler(service repos.IAdminService, logger *log.Logger) *AdminHandler {
+	return &AdminHandler{service: service, logger: logger}
+}
+
+// SetUserRole changes the role of a user
+// @Summary Change a user's role
+// @Description Sets the role of a user to user, moderator or admin. The user is logged out of every device so the new role applies immediately. Admins cannot change their own role.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body object{role=string} true "New role: user, moderator or admin"
+// @Success 200 {object} map[string]string "Role updated"
+// @Failure 400 {object} map[string]string "Invalid user ID, role or request body"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "Forbidden - admin role required"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /admin/users/{id}/role [patch]
+func (h *AdminHandler) SetUserRole(c *gin.Context) {
+	adminID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var request struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.service.SetUserRole(c.Request.Context(), adminID, userID, request.Role); err != nil {
+		switch {
+		case errors.Is(err, dto.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, dto.ErrInvalidRole), errors.Is(err, dto.ErrOwnRoleChange):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Role updated"})
+}
+
+// GetUsersByRole lists the users with a given role
+// @Summary List users by role
+// @Description Returns a page of users that have the given role, e.g. all moderators.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param role query string true "Role: user, moderator or admin"
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of users per page" default(10)
+// @Success 200 {object} swagger.Response{data=[]models.User} "Users"
+// @Failure 400 {object} map[string]string "Invalid role"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "Forbidden - admin role required"
+// @Router /admin/users [get]
+func (h *AdminHandler) GetUsersByRole(c *gin.Context) {
+	page := stringToInt64(c.DefaultQuery("page", "1"))
+	pageSize := stringToInt64(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	users, err := h.service.GetUsersByRole(c.Request.Context(), c.Query("role"), page, pageSize)
+	if err != nil {
+		if errors.Is(err, dto.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyHandler handles the personal API keys of users
+type APIKeyHandler struct {
+	service repos.IAPIKeyService
+	logger  *log.Logger
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler instance
+func NewAPIKeyHandler(service repos.IAPIKeyService, logger *log.Logger) *APIKeyHandler {
+	return &APIKeyHandler{service: service, logger: logger}
+}
+
+// CreateKey creates an API key
+// @Summary Create an API key
+// @Description Creates a key bots and integrations can send instead of a JWT, as "Authorization: Bearer sk_..." (or the bare key on WebSocket endpoints).
+// @Description A key acts as you, but only on endpoints covered by its scopes: posts:write, comments:write, chat:read and chat:write. The key is returned once, store it safely.
+// @Tags api-keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.APIKeyRequest true "Name, scopes and lifetime in days (0 for a key that does not expire)"
+// @Success 201 {object} swagger.Response{data=models.NewAPIKey} "The key and its details"
+// @Failure 400 {object} map[string]string "Invalid name, scopes or lifetime"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 409 {object} map[string]string "Too many API keys"
+// @Router /users/me/api-keys [post]
+func (h *APIKeyHandler) CreateKey(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request models.APIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	key, err := h.service.CreateKey(c.Request.Context(), userID, &request)
+	if err != nil {
+		h.respondError(c, err, "Failed to create API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": key})
+}
+
+// GetKeys lists the API keys of the authenticated user
+// @Summary List API keys
+// @Description Returns your API keys, newest first. Keys themselves are never shown again, only their hint.
+// @Tags api-keys
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} swagger.Response{data=[]models.APIKey} "API keys"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/me/api-keys [get]
+func (h *APIKeyHandler) GetKeys(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	keys, err := h.service.GetKeys(c.Request.Context(), userID)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch API keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// RevokeKey revokes an API key
+// @Summary Revoke an API key
+// @Description Deletes the API key, requests made with it are refused from then on.
+// @Tags api-keys
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the API key"
+// @Success 200 {object} map[string]string "Revoked"
+// @Failure 400 {object} map[string]string "Invalid API key ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "API key not found"
+// @Router /users/me/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeKey(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := h.service.RevokeKey(c.Request.Context(), userID, keyID); err != nil {
+		h.respondError(c, err, "Failed to revoke API key")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "revoked"})
+}
+
+func (h *APIKeyHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrInvalidKeyName), errors.Is(err, dto.ErrInvalidScope),
+		errors.Is(err, dto.ErrNoScopes), errors.Is(err, dto.ErrInvalidKeyExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrTooManyAPIKeys):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
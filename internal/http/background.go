@@ -23,13 +23,16 @@ func NewBackgroundHandler(service repos.IBackgroundService, logger *log.Logger)
 
 // CreateBackground godoc
 // @Summary Upload a new background image
-// @Description Uploads a new background image and stores it
+// @Description Uploads a new background image and stores it. Admins only.
 // @Tags backgrounds
+// @Security BearerAuth
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "Background image file"
 // @Success 201 {object} map[string]interface{} "File uploaded successfully"
 // @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]interface{} "Forbidden - admin role required"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /backgrounds/ [post]
 func (h *BackgroundHandler) CreateBackground(c *gin.Context) {
@@ -52,12 +55,15 @@ func (h *BackgroundHandler) CreateBackground(c *gin.Context) {
 
 // DeleteBackground godoc
 // @Summary Delete a background image
-// @Description Deletes a background image by ID
+// @Description Deletes a background image by ID. Admins only.
 // @Tags backgrounds
+// @Security BearerAuth
 // @Produce json
 // @Param id path string true "Background ID"
 // @Success 200 {object} map[string]interface{} "Background deleted successfully"
 // @Failure 400 {object} map[string]interface{} "Invalid ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]interface{} "Forbidden - admin role required"
 // @Failure 404 {object} map[string]interface{} "Background not found"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /backgrounds/{id} [delete]
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	_ "github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BlockHandler handles blocking endpoints
+type BlockHandler struct {
+	service repos.IBlockService
+	logger  *log.Logger
+}
+
+// NewBlockHandler creates a new BlockHandler instance
+func NewBlockHandler(service repos.IBlockService, logger *log.Logger) *BlockHandler {
+	return &BlockHandler{service: service, logger: logger}
+}
+
+// Block blocks a user
+// @Summary Block a user
+// @Description Blocks the user and removes the follows between the two of you. Blocked users cannot message you, follow you or see your profile, and comments between you are hidden from each other.
+// @Tags blocks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the user to block"
+// @Success 200 {object} map[string]string "Blocked"
+// @Failure 400 {object} map[string]string "Invalid user ID or blocking yourself"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /users/{id}/block [post]
+func (h *BlockHandler) Block(c *gin.Context) {
+	blockerID, blockedID, ok := h.pairParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Block(c.Request.Context(), blockerID, blockedID); err != nil {
+		h.respondError(c, err, "Failed to block user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "blocked"})
+}
+
+// Unblock lifts a block
+// @Summary Unblock a user
+// @Description Lifts the block. Follows removed when blocking are not restored.
+// @Tags blocks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the user to unblock"
+// @Success 200 {object} map[string]string "Unblocked"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "User is not blocked"
+// @Router /users/{id}/block [delete]
+func (h *BlockHandler) Unblock(c *gin.Context) {
+	blockerID, blockedID, ok := h.pairParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Unblock(c.Request.Context(), blockerID, blockedID); err != nil {
+		h.respondError(c, err, "Failed to unblock user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "unblocked"})
+}
+
+// GetBlockedUsers lists the users the authenticated user has blocked
+// @Summary List blocked users
+// @Description Returns a page of the users the authenticated user has blocked, most recently blocked first.
+// @Tags blocks
+// @Security BearerAuth
+// @Produce json
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of users per page" default(20)
+// @Success 200 {object} swagger.Response{data=[]models.UserSummary} "Blocked users"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/me/blocks [get]
+func (h *BlockHandler) GetBlockedUsers(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	page, pageSize := pageParams(c)
+	users, err := h.service.GetBlockedUsers(c.Request.Context(), userID, page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch blocked users")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// pairParams reads the authenticated user and the user in the path
+func (h *BlockHandler) pairParams(c *gin.Context) (userID, otherID primitive.ObjectID, ok bool) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return userID, otherID, false
+	}
+
+	otherID, err = primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return userID, otherID, false
+	}
+
+	return userID, otherID, true
+}
+
+func (h *BlockHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrSelfBlock):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrUserNotFound), errors.Is(err, dto.ErrNotBlocked):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
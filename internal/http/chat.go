@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/models"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,14 +21,16 @@ import (
 
 type ChatHandler struct {
 	service     repos.IChatService
+	presence    repos.IPresenceService
 	fileService repos.IFIleStoreService
 	logger      *log.Logger
 	redis       *redis.Client
 }
 
-func NewChatHandler(service repos.IChatService, fileService repos.IFIleStoreService, logger *log.Logger, redis *redis.Client) *ChatHandler {
+func NewChatHandler(service repos.IChatService, presence repos.IPresenceService, fileService repos.IFIleStoreService, logger *log.Logger, redis *redis.Client) *ChatHandler {
 	return &ChatHandler{
 		service:     service,
+		presence:    presence,
 		fileService: fileService,
 		logger:      logger,
 		redis:       redis,
@@ -39,7 +43,7 @@ type pendingMessage struct {
 
 // HandleChatWebSocket handles WebSocket connections for real-time chat
 // @Summary      WebSocket for real-time chat
-// @Description  Establishes a WebSocket connection for real-time messaging between two users.
+// @Description  Establishes a WebSocket connection for real-time messaging between two users. Messages are refused while either user has blocked the other, or when the recipient's settings do not accept messages from the sender. The sender counts as online while connected, and presence changes of the recipient are pushed as {"type": "presence", ...} events. Notifications for the sender are pushed too, e.g. {"action": "expiring", "post_id": ..., "delete_at": ...} shortly before a post of theirs expires, unless they turned that kind off in their notification settings. API keys need the chat:read scope to connect and chat:write to send messages.
 // @Tags         chat
 // @Security     BearerAuth
 // @Param        recipient_id  query  string  true  "Recipient's user ID"
@@ -82,12 +86,28 @@ func (h *ChatHandler) HandleChatWebSocket(c *gin.Context) {
 
 	h.logger.Println("New chat WebSocket client connected:", senderID.Hex(), "to", recipientID.Hex())
 
+	// API keys with only chat:read can follow the chat but not send to it
+	canWrite := hasScope(c, models.ScopeChatWrite)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// The sender is online while connected
+	defer h.presence.Track(ctx, senderID)()
+
 	// Create a unique chat channel for the two users (order-independent)
 	chatChannel := fmt.Sprintf("chat:%s:%s", min(senderID.Hex(), recipientID.Hex()), max(senderID.Hex(), recipientID.Hex()))
-	pubsub := h.redis.Subscribe(ctx, chatChannel)
+	// so are notifications meant for the sender, like posts of theirs about to expire
+	channels := []string{chatChannel, models.NotificationChannel(senderID)}
+
+	// Presence changes of the recipient are pushed along with the messages
+	presenceChannels, err := h.presence.Channels(ctx, senderID, recipientID)
+	if err != nil {
+		h.logger.Println("Failed to resolve presence channels:", err)
+	}
+	channels = append(channels, presenceChannels...)
+
+	pubsub := h.redis.Subscribe(ctx, channels...)
 	defer pubsub.Close()
 
 	// Goroutine to listen for messages from Redis and send to WebSocket client
@@ -131,6 +151,11 @@ func (h *ChatHandler) HandleChatWebSocket(c *gin.Context) {
 			continue
 		}
 
+		if !canWrite {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "API key is missing the chat:write scope"}`))
+			continue
+		}
+
 		// Get or initialize the pending message for this connection
 		current, exists := pending[conn]
 		if !exists {
@@ -166,6 +191,14 @@ func (h *ChatHandler) HandleChatWebSocket(c *gin.Context) {
 		// Save the message to the database
 		if err := h.service.CreateMessage(ctx, &current.Message); err != nil {
 			h.logger.Println("Error creating message:", err)
+			if errors.Is(err, dto.ErrBlocked) {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "you cannot message this user"}`))
+				continue
+			}
+			if errors.Is(err, dto.ErrMessagesNotAllowed) {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "this user does not accept messages from you"}`))
+				continue
+			}
 			conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "could not send message"}`))
 			continue
 		}
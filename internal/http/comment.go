@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -12,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/models"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -30,6 +32,7 @@ var upgrader = websocket.Upgrader{
 
 type CommentHandler struct {
 	service      repos.ICommentService
+	presence     repos.IPresenceService
 	file_service repos.IFIleStoreService
 	logger       *log.Logger
 	redis        *redis.Client
@@ -37,11 +40,13 @@ type CommentHandler struct {
 
 func NewCommentHandler(
 	service repos.ICommentService,
+	presence repos.IPresenceService,
 	file_service repos.IFIleStoreService,
 	logger *log.Logger,
 	redis *redis.Client) *CommentHandler {
 	return &CommentHandler{
 		service:      service,
+		presence:     presence,
 		file_service: file_service,
 		logger:       logger,
 		redis:        redis,
@@ -87,7 +92,9 @@ type (
 
 // HandleWebSocket handles WebSocket connections for real-time comments
 // @Summary      WebSocket connection for real-time comments
-// @Description  Establishes a WebSocket connection for real-time comment updates on a specific post
+// @Description  Establishes a WebSocket connection for real-time comment updates on a specific post. Besides comment changes it pushes "lifetime" events when the creator changes when the post expires, "expiring" shortly before it does and "expired" once it is deleted.
+// @Description  When the post is deleted by its creator or a moderator it pushes "post_deleted". After "expired" or "post_deleted" the server closes the connection.
+// @Description  Comment events of users you blocked, or who blocked you, are not pushed.
 // @Tags         comments
 // @Param        post_id  query  string  true  "Post ID to subscribe to comments for"
 // @Success      101  {string}  string             "Switching Protocols"
@@ -126,8 +133,20 @@ func (h *CommentHandler) HandleWebSocket(c *gin.Context) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// The user is online while connected
+	defer h.presence.Track(ctx, userID)()
+
+	// Comments of users the viewer blocked, or was blocked by, are not pushed. The user's own
+	// channel only tells when that changes and is never forwarded.
+	hidden, err := h.hiddenUsers(ctx, userID)
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "could not load blocked users"}`))
+		return
+	}
+	notifications := models.NotificationChannel(userID)
+
 	// Subscribe to Redis channel for this specific post
-	pubsub := h.redis.Subscribe(ctx, "comments:"+postID)
+	pubsub := h.redis.Subscribe(ctx, "comments:"+postID, notifications)
 	defer pubsub.Close()
 
 	// Goroutine to listen for messages from Redis and send to WebSocket client
@@ -151,6 +170,18 @@ func (h *CommentHandler) HandleWebSocket(c *gin.Context) {
 					continue
 				}
 
+				if msg.Channel == notifications {
+					if messageData["action"] == models.ActionBlocksChanged {
+						if reloaded, err := h.hiddenUsers(ctx, userID); err == nil {
+							hidden = reloaded
+						}
+					}
+					continue
+				}
+				if fromHiddenUser(messageData, hidden) {
+					continue
+				}
+
 				// Add the current user's ID to the response for client-side use
 				messageData["current_user_id"] = userID
 
@@ -174,6 +205,12 @@ func (h *CommentHandler) HandleWebSocket(c *gin.Context) {
 					cancel() // Cancel context to stop subscription
 					return
 				}
+
+				// The post is gone, close the connection once the client had the event
+				if action := messageData["action"]; action == "post_deleted" || action == "expired" {
+					closeGone(conn, "post deleted")
+					return
+				}
 			}
 		}
 	}()
@@ -227,6 +264,10 @@ func (h *CommentHandler) HandleWebSocket(c *gin.Context) {
 		// Save to database
 		if err := h.service.CreateComment(ctx, &current.Comment); err != nil {
 			h.logger.Println("Error saving comment:", err)
+			if errors.Is(err, dto.ErrFileNotOwned) {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "you can only attach files you uploaded"}`))
+				continue
+			}
 			conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "could not save comment"}`))
 			continue
 		}
@@ -246,9 +287,44 @@ func (h *CommentHandler) HandleWebSocket(c *gin.Context) {
 	}
 }
 
+// hiddenUsers returns the hex IDs of the users whose comments are not pushed to the viewer
+func (h *CommentHandler) hiddenUsers(ctx context.Context, viewerID primitive.ObjectID) (map[string]bool, error) {
+	ids, err := h.service.GetHiddenUserIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		hidden[id.Hex()] = true
+	}
+	return hidden, nil
+}
+
+// fromHiddenUser reports whether a comment event was caused by, or is about a comment of, a hidden user
+func fromHiddenUser(event map[string]any, hidden map[string]bool) bool {
+	if userID, ok := event["user_id"].(string); ok && hidden[userID] {
+		return true
+	}
+	if comment, ok := event["comment"].(map[string]any); ok {
+		if userID, ok := comment["user_id"].(string); ok && hidden[userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// closeGone starts the closing handshake and bounds how long the reading loop waits for the
+// client to answer it
+func closeGone(conn *websocket.Conn, reason string) {
+	deadline := time.Now().Add(5 * time.Second)
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, reason), deadline)
+	conn.SetReadDeadline(deadline)
+}
+
 // GetCommentsByPostID retrieves all comments for a post with pagination
 // @Summary      Get comments by post ID
-// @Description  Retrieves a paginated list of comments for a specific post
+// @Description  Retrieves a paginated list of comments for a specific post. Signed-in viewers do not see comments of users they blocked or were blocked by.
 // @Tags         comments
 // @Produce      json
 // @Param        post_id   path      string  true   "Post ID (MongoDB ObjectID)"
@@ -272,7 +348,7 @@ func (h *CommentHandler) GetCommentsByPostID(c *gin.Context) {
 	page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
 	pageSize, _ := strconv.ParseInt(c.DefaultQuery("pageSize", "10"), 10, 64)
 
-	comments, err := h.service.GetCommentsByPostID(c.Request.Context(), postID, page, pageSize)
+	comments, err := h.service.GetCommentsByPostID(c.Request.Context(), userId, postID, page, pageSize)
 	if err != nil {
 		h.logger.Println("Failed to fetch comments:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch comments"})
@@ -428,6 +504,7 @@ func (h *CommentHandler) UpdateComment(c *gin.Context) {
 			"update",
 			map[string]interface{}{
 				"comment_id": commentID.Hex(),
+				"user_id":    userID,
 				"new_text":   req.NewText,
 			},
 		)
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DraftHandler handles drafts and scheduled posts
+type DraftHandler struct {
+	service repos.IDraftService
+	logger  *log.Logger
+}
+
+// NewDraftHandler creates a new DraftHandler instance
+func NewDraftHandler(service repos.IDraftService, logger *log.Logger) *DraftHandler {
+	return &DraftHandler{service: service, logger: logger}
+}
+
+// CreateDraft saves a post without publishing it
+// @Summary Create a draft
+// @Description Saves a post that only you can see. Its lifetime (delete_after) starts counting once it is published.
+// @Description With publish_at the draft is scheduled and published at that time, it then needs a description. You can have up to 100 drafts.
+// @Tags drafts
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.DraftRequest true "Draft content and optional publish time"
+// @Success 201 {object} swagger.Response{data=models.Draft} "The saved draft"
+// @Failure 400 {object} map[string]string "Invalid request body, post lifetime or publish time, or a scheduled draft without description"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "A picture was not uploaded by you"
+// @Failure 409 {object} map[string]string "Too many drafts"
+// @Router /posts/drafts [post]
+func (h *DraftHandler) CreateDraft(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request dto.DraftRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	draft, err := h.service.CreateDraft(c.Request.Context(), userID, &request)
+	if err != nil {
+		h.respondError(c, err, "Failed to create draft")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": draft})
+}
+
+// GetDrafts lists the drafts of the authenticated user
+// @Summary List your drafts
+// @Description Returns your drafts and scheduled posts, newest first. A scheduled post that could not be published has the status "failed" and the reason in error.
+// @Tags drafts
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} swagger.Response{data=[]models.Draft} "Drafts"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /posts/drafts [get]
+func (h *DraftHandler) GetDrafts(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	drafts, err := h.service.GetDrafts(c.Request.Context(), userID)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch drafts")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": drafts})
+}
+
+// GetDraft returns a draft of the authenticated user
+// @Summary Get a draft
+// @Tags drafts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Draft ID"
+// @Success 200 {object} swagger.Response{data=models.Draft} "The draft"
+// @Failure 400 {object} map[string]string "Invalid draft ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Draft not found"
+// @Router /posts/drafts/{id} [get]
+func (h *DraftHandler) GetDraft(c *gin.Context) {
+	userID, draftID, ok := h.draftRequest(c)
+	if !ok {
+		return
+	}
+
+	draft, err := h.service.GetDraft(c.Request.Context(), userID, draftID)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": draft})
+}
+
+// UpdateDraft replaces the content and schedule of a draft
+// @Summary Edit a draft
+// @Description Replaces the whole draft. Set publish_at to schedule or reschedule it, leave it out to keep it unpublished.
+// @Description A draft that failed to publish is scheduled again with a new publish_at.
+// @Tags drafts
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Draft ID"
+// @Param request body dto.DraftRequest true "Draft content and optional publish time"
+// @Success 200 {object} swagger.Response{data=models.Draft} "The updated draft"
+// @Failure 400 {object} map[string]string "Invalid draft ID, request body, post lifetime or publish time, or a scheduled draft without description"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "A picture was not uploaded by you"
+// @Failure 404 {object} map[string]string "Draft not found"
+// @Failure 409 {object} map[string]string "The draft is being published"
+// @Router /posts/drafts/{id} [put]
+func (h *DraftHandler) UpdateDraft(c *gin.Context) {
+	userID, draftID, ok := h.draftRequest(c)
+	if !ok {
+		return
+	}
+
+	var request dto.DraftRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	draft, err := h.service.UpdateDraft(c.Request.Context(), userID, draftID, &request)
+	if err != nil {
+		h.respondError(c, err, "Failed to update draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": draft})
+}
+
+// CancelSchedule keeps a scheduled draft from being published
+// @Summary Cancel a scheduled post
+// @Description Unschedules the draft, it is kept as a draft.
+// @Tags drafts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Draft ID"
+// @Success 200 {object} swagger.Response{data=models.Draft} "The unscheduled draft"
+// @Failure 400 {object} map[string]string "Invalid draft ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Draft not found"
+// @Failure 409 {object} map[string]string "The draft is not scheduled, or is being published"
+// @Router /posts/drafts/{id}/schedule [delete]
+func (h *DraftHandler) CancelSchedule(c *gin.Context) {
+	userID, draftID, ok := h.draftRequest(c)
+	if !ok {
+		return
+	}
+
+	draft, err := h.service.CancelSchedule(c.Request.Context(), userID, draftID)
+	if err != nil {
+		h.respondError(c, err, "Failed to cancel scheduled post")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": draft})
+}
+
+// PublishDraft publishes a draft right away
+// @Summary Publish a draft
+// @Description Publishes the draft now, scheduled or not. The post keeps the draft's ID and the draft is removed.
+// @Tags drafts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Draft ID"
+// @Success 201 {object} swagger.Response{data=models.Post} "The published post"
+// @Failure 400 {object} map[string]string "Invalid draft ID, or the draft has no description"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Draft not found"
+// @Failure 409 {object} map[string]string "The draft is being published"
+// @Router /posts/drafts/{id}/publish [post]
+func (h *DraftHandler) PublishDraft(c *gin.Context) {
+	userID, draftID, ok := h.draftRequest(c)
+	if !ok {
+		return
+	}
+
+	post, err := h.service.PublishDraft(c.Request.Context(), userID, draftID)
+	if err != nil {
+		h.respondError(c, err, "Failed to publish draft")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": post})
+}
+
+// DeleteDraft discards a draft
+// @Summary Delete a draft
+// @Description Deletes the draft and its pictures. A scheduled draft is not published.
+// @Tags drafts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Draft ID"
+// @Success 200 {object} map[string]string "Deleted"
+// @Failure 400 {object} map[string]string "Invalid draft ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Draft not found"
+// @Failure 409 {object} map[string]string "The draft is being published"
+// @Router /posts/drafts/{id} [delete]
+func (h *DraftHandler) DeleteDraft(c *gin.Context) {
+	userID, draftID, ok := h.draftRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteDraft(c.Request.Context(), userID, draftID); err != nil {
+		h.respondError(c, err, "Failed to delete draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "deleted"})
+}
+
+// draftRequest reads the authenticated user and the draft ID of the path, responding itself when either is missing
+func (h *DraftHandler) draftRequest(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	draftID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid draft ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	return userID, draftID, true
+}
+
+func (h *DraftHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrInvalidPostLifetime), errors.Is(err, dto.ErrInvalidPublishTime),
+		errors.Is(err, dto.ErrDescriptionRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrFileNotOwned):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrDraftNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrDraftPublishing), errors.Is(err, dto.ErrDraftNotScheduled),
+		errors.Is(err, dto.ErrTooManyDrafts):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -51,16 +51,25 @@ func (h *FIleStorageHandler) GetFileById(c *gin.Context) {
 // UploadFile handles file uploads via form data
 // @Summary      Upload a file
 // @Description  Uploads a single file to the storage service (MinIO). The file is sent as form data and stored, returning the file URL on success.
+// @Description  Only the uploader can attach the file to posts, drafts and comments, and it is deleted with them.
 // @Tags         Files
+// @Security     BearerAuth
 // @Accept       multipart/form-data
 // @Produce      json
 // @Param        file  formData  file  true  "File to upload (Supported formats: any file type supported by MinIO, e.g., images, PDFs, audio. Max size: 10MB recommended)"
 // @Success      200  {object}  map[string]interface{}  "Returns the uploaded file URL"
 // @Failure      400  {object}  map[string]interface{}  "Invalid file upload or request format"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
 // @Failure      500  {object}  map[string]interface{}  "Server error during file upload"
 // @Router       /files [post]
-// @Note        For frontend devs: Send the file in a multipart/form-data request with the key 'file'. Example in JS: `formData.append('file', fileInput.files[0])`. Keep files under 10MB to avoid timeouts. No authentication required for this endpoint (add if needed).
+// @Note        For frontend devs: Send the file in a multipart/form-data request with the key 'file'. Example in JS: `formData.append('file', fileInput.files[0])`. Keep files under 10MB to avoid timeouts. API keys need the posts:write or comments:write scope.
 func (h *FIleStorageHandler) UploadFile(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
 	// Get the file from the form data
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -70,7 +79,7 @@ func (h *FIleStorageHandler) UploadFile(c *gin.Context) {
 	}
 
 	// Upload the file to MinIO using file_service
-	fileObj, err := h.file_service.UploadFile(file)
+	fileObj, err := h.file_service.UploadFile(file, userID)
 	if err != nil {
 		h.logger.Println("Failed to upload file to storage:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload file"})
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	_ "github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FollowHandler handles follow and follow request endpoints
+type FollowHandler struct {
+	service repos.IFollowService
+	logger  *log.Logger
+}
+
+// NewFollowHandler creates a new FollowHandler instance
+func NewFollowHandler(service repos.IFollowService, logger *log.Logger) *FollowHandler {
+	return &FollowHandler{service: service, logger: logger}
+}
+
+// Follow follows a user
+// @Summary Follow a user
+// @Description Follows the user. If their account is private, a follow request is sent instead and the returned status is "pending" until they accept it.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the user to follow"
+// @Success 200 {object} swagger.Response{data=object{status=string}} "Follow status: accepted or pending"
+// @Failure 400 {object} map[string]string "Invalid user ID or following yourself"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /users/{id}/follow [post]
+func (h *FollowHandler) Follow(c *gin.Context) {
+	followerID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	followeeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	status, err := h.service.Follow(c.Request.Context(), followerID, followeeID)
+	if err != nil {
+		h.respondError(c, err, "Failed to follow user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"status": status}})
+}
+
+// Unfollow stops following a user
+// @Summary Unfollow a user
+// @Description Stops following the user, or withdraws a pending follow request.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the user to unfollow"
+// @Success 200 {object} map[string]string "Unfollowed"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Not following this user"
+// @Router /users/{id}/follow [delete]
+func (h *FollowHandler) Unfollow(c *gin.Context) {
+	followerID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	followeeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.service.Unfollow(c.Request.Context(), followerID, followeeID); err != nil {
+		h.respondError(c, err, "Failed to unfollow user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "unfollowed"})
+}
+
+// GetFollowers lists the followers of a user
+// @Summary List followers
+// @Description Returns a page of the users following the user, newest first. The lists of a private account are only visible to its owner and accepted followers.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of users per page" default(20)
+// @Success 200 {object} swagger.Response{data=[]models.UserSummary} "Followers"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 403 {object} map[string]string "The account is private"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /users/{id}/followers [get]
+func (h *FollowHandler) GetFollowers(c *gin.Context) {
+	viewerID, userID, page, pageSize, ok := h.listParams(c)
+	if !ok {
+		return
+	}
+
+	users, err := h.service.GetFollowers(c.Request.Context(), viewerID, userID, page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch followers")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// GetFollowing lists the users a user follows
+// @Summary List followed users
+// @Description Returns a page of the users the user follows, newest first. The lists of a private account are only visible to its owner and accepted followers.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of users per page" default(20)
+// @Success 200 {object} swagger.Response{data=[]models.UserSummary} "Followed users"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 403 {object} map[string]string "The account is private"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /users/{id}/following [get]
+func (h *FollowHandler) GetFollowing(c *gin.Context) {
+	viewerID, userID, page, pageSize, ok := h.listParams(c)
+	if !ok {
+		return
+	}
+
+	users, err := h.service.GetFollowing(c.Request.Context(), viewerID, userID, page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch followed users")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// GetFollowRequests lists pending requests to follow the authenticated user
+// @Summary List follow requests
+// @Description Returns a page of the pending requests to follow the authenticated user, newest first.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of users per page" default(20)
+// @Success 200 {object} swagger.Response{data=[]models.UserSummary} "Users who asked to follow"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/me/follow-requests [get]
+func (h *FollowHandler) GetFollowRequests(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	page, pageSize := pageParams(c)
+	users, err := h.service.GetFollowRequests(c.Request.Context(), userID, page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch follow requests")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// AcceptFollowRequest accepts a follow request
+// @Summary Accept a follow request
+// @Description Lets the user who sent the request follow the authenticated user.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the user who sent the request"
+// @Success 200 {object} map[string]string "Request accepted"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Follow request not found"
+// @Router /users/me/follow-requests/{id} [post]
+func (h *FollowHandler) AcceptFollowRequest(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	followerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.service.AcceptFollowRequest(c.Request.Context(), userID, followerID); err != nil {
+		h.respondError(c, err, "Failed to accept follow request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "request accepted"})
+}
+
+// RejectFollowRequest rejects a follow request
+// @Summary Reject a follow request
+// @Description Deletes the follow request. The user who sent it may send another one later.
+// @Tags follows
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "ID of the user who sent the request"
+// @Success 200 {object} map[string]string "Request rejected"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Follow request not found"
+// @Router /users/me/follow-requests/{id} [delete]
+func (h *FollowHandler) RejectFollowRequest(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	followerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.service.RejectFollowRequest(c.Request.Context(), userID, followerID); err != nil {
+		h.respondError(c, err, "Failed to reject follow request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "request rejected"})
+}
+
+// listParams reads the viewer, the user in the path and paging of a follow list request
+func (h *FollowHandler) listParams(c *gin.Context) (viewerID, userID primitive.ObjectID, page, pageSize int64, ok bool) {
+	viewerID, err := getUserIdFromRequest(c)
+	if err != nil {
+		viewerID = primitive.NilObjectID
+	}
+
+	userID, err = primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return viewerID, userID, 0, 0, false
+	}
+
+	page, pageSize = pageParams(c)
+	return viewerID, userID, page, pageSize, true
+}
+
+func (h *FollowHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrSelfFollow):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrPrivateAccount):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrUserNotFound), errors.Is(err, dto.ErrNotFollowing), errors.Is(err, dto.ErrFollowRequestAbsent):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// pageParams reads page and pageSize from the query, keeping pageSize between 1 and 100
+func pageParams(c *gin.Context) (int64, int64) {
+	page := stringToInt64(c.DefaultQuery("page", "1"))
+	pageSize := stringToInt64(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return page, pageSize
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ruziba3vich/soand/internal/jwtkeys"
+)
+
+// JWKSHandler publishes the public JWT verification keys
+type JWKSHandler struct {
+	keys *jwtkeys.KeySet
+}
+
+// NewJWKSHandler creates a new JWKSHandler instance
+func NewJWKSHandler(keys *jwtkeys.KeySet) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+// GetJWKS godoc
+// @Summary JSON Web Key Set
+// @Description Returns the public keys access tokens are signed with, matched by the token's kid header
+// @Tags auth
+// @Produce json
+// @Success 200 {object} jwtkeys.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *JWKSHandler) GetJWKS(c *gin.Context) {
+	// Short enough that verifiers see a newly published key well before it becomes active
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
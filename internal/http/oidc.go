@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCHandler handles logins with OpenID Connect providers and linking provider accounts
+type OIDCHandler struct {
+	service repos.IOIDCService
+	logger  *log.Logger
+}
+
+// NewOIDCHandler creates a new OIDCHandler instance
+func NewOIDCHandler(service repos.IOIDCService, logger *log.Logger) *OIDCHandler {
+	return &OIDCHandler{service: service, logger: logger}
+}
+
+// GetProviders lists the providers users can log in with
+// @Summary List login providers
+// @Description Returns the names of the OpenID Connect providers configured on this server.
+// @Tags oidc
+// @Produce json
+// @Success 200 {object} swagger.Response{data=[]string} "Provider names"
+// @Router /auth/oidc/providers [get]
+func (h *OIDCHandler) GetProviders(c *gin.Context) {
+	providers := h.service.Providers()
+	if providers == nil {
+		providers = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": providers})
+}
+
+// Login starts a login at a provider
+// @Summary Log in with a provider
+// @Description Redirects to the provider's login page (authorization code flow with PKCE). The provider sends the user back to /auth/oidc/{provider}/callback.
+// @Description The first login with an account nobody linked yet creates a user without a password.
+// @Tags oidc
+// @Param provider path string true "Provider name"
+// @Success 302 "Redirect to the provider"
+// @Failure 404 {object} map[string]string "Unknown provider"
+// @Failure 502 {object} map[string]string "The provider cannot be reached"
+// @Router /auth/oidc/{provider}/login [get]
+func (h *OIDCHandler) Login(c *gin.Context) {
+	url, binding, err := h.service.StartLogin(c.Request.Context(), c.Param("provider"), primitive.NilObjectID)
+	if err != nil {
+		h.respondError(c, err, "Failed to start login")
+		return
+	}
+
+	setBindingCookie(c, binding)
+	c.Redirect(http.StatusFound, url)
+}
+
+// Callback finishes a login or account link
+// @Summary Finish a provider login
+// @Description The redirect URL registered with the provider. Logs the user in like /users/login, so with two-factor authentication enabled the login is finished at /users/login/2fa.
+// @Description If the login was started from POST /users/me/identities/{provider}, the provider account is linked instead and "linked" is returned.
+// @Tags oidc
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State the login was started with"
+// @Success 200 {object} swagger.Response{data=models.ExternalLoginResult} "Tokens of the new session, a 2FA challenge, or the linked account"
+// @Failure 400 {object} map[string]string "Login denied at the provider, or an invalid or expired state, or one started in another browser"
+// @Failure 403 {object} map[string]string "The account is deactivated"
+// @Failure 404 {object} map[string]string "Unknown provider"
+// @Failure 409 {object} map[string]string "The provider account is linked to another user, or one is linked already"
+// @Failure 502 {object} map[string]string "The provider rejected the code or cannot be reached"
+// @Router /auth/oidc/{provider}/callback [get]
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login was denied: " + reason})
+		return
+	}
+
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+		return
+	}
+
+	binding, _ := c.Cookie(oidcBindingCookie)
+	setBindingCookie(c, "") // every state is used once
+
+	result, err := h.service.Callback(c.Request.Context(), c.Param("provider"), code, state, binding, sessionMetaFromRequest(c, ""))
+	if err != nil {
+		h.respondError(c, err, "Failed to finish login")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// LinkIdentity starts linking a provider account to the authenticated user
+// @Summary Link a provider account
+// @Description Returns the URL of the provider's login page. Once the user logs in there, the callback links that account so it can be used to log in from then on.
+// @Description The response sets a cookie the callback checks, so the URL has to be opened in the browser that made this request.
+// @Tags oidc
+// @Security BearerAuth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Success 200 {object} swagger.Response{data=object{authorization_url=string}} "Where to send the user"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Unknown provider"
+// @Failure 502 {object} map[string]string "The provider cannot be reached"
+// @Router /users/me/identities/{provider} [post]
+func (h *OIDCHandler) LinkIdentity(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	url, binding, err := h.service.StartLogin(c.Request.Context(), c.Param("provider"), userID)
+	if err != nil {
+		h.respondError(c, err, "Failed to start linking")
+		return
+	}
+
+	setBindingCookie(c, binding)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"authorization_url": url}})
+}
+
+// UnlinkIdentity unlinks a provider account from the authenticated user
+// @Summary Unlink a provider account
+// @Description Removes the linked account at the provider. Users without a password cannot remove their only linked account.
+// @Tags oidc
+// @Security BearerAuth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Success 200 {object} map[string]string "Unlinked"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "No account at this provider is linked"
+// @Failure 409 {object} map[string]string "It is the only way to log in"
+// @Router /users/me/identities/{provider} [delete]
+func (h *OIDCHandler) UnlinkIdentity(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.service.Unlink(c.Request.Context(), userID, c.Param("provider")); err != nil {
+		h.respondError(c, err, "Failed to unlink account")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "unlinked"})
+}
+
+// oidcBindingCookie holds the binding of the login or link the browser started last
+const oidcBindingCookie = "oidc_binding"
+
+// setBindingCookie keeps binding in an HttpOnly cookie sent only to the OIDC routes, an empty one removes it
+func setBindingCookie(c *gin.Context, binding string) {
+	maxAge := 0
+	if binding == "" {
+		maxAge = -1
+	}
+	c.SetSameSite(http.SameSiteLaxMode) // the provider sends the user back with a top-level redirect
+	c.SetCookie(oidcBindingCookie, binding, maxAge, "/auth/oidc", "", c.Request.TLS != nil, true)
+}
+
+func (h *OIDCHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrInvalidOIDCState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrAccountDeactivated):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrUnknownProvider), errors.Is(err, dto.ErrIdentityNotLinked), errors.Is(err, dto.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrIdentityTaken), errors.Is(err, dto.ErrIdentityAlreadyLinked), errors.Is(err, dto.ErrLastLoginMethod):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrExternalLoginFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+)
+
+// RequestPhoneVerification sends a verification code to a phone number
+// @Summary Request phone verification
+// @Description Texts a one-time code to the given phone number (international format). The number is added to the account once the code is confirmed.
+// @Tags phone
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body object{phone=string} true "Phone number, e.g. +998901234567"
+// @Success 200 {object} map[string]string "Code sent"
+// @Failure 400 {object} map[string]string "Invalid phone number"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 409 {object} map[string]string "Phone number already in use"
+// @Failure 429 {object} map[string]string "A code was sent recently"
+// @Router /users/phone/verify/request [post]
+func (h *UserHandler) RequestPhoneVerification(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.repo.RequestPhoneVerification(c.Request.Context(), userID, request.Phone); err != nil {
+		h.logger.Printf("Error requesting phone verification: %v", err)
+		c.JSON(otpErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "verification code sent"})
+}
+
+// ConfirmPhoneVerification verifies the phone number with the code that was sent to it
+// @Summary Confirm phone verification
+// @Description Checks the code sent by /users/phone/verify/request and stores the phone number as verified.
+// @Tags phone
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body object{code=string} true "Code from the SMS"
+// @Success 200 {object} map[string]string "Phone verified"
+// @Failure 400 {object} map[string]string "Invalid or expired code"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 409 {object} map[string]string "Phone number already in use"
+// @Failure 429 {object} map[string]string "Too many attempts"
+// @Router /users/phone/verify [post]
+func (h *UserHandler) ConfirmPhoneVerification(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.repo.ConfirmPhoneVerification(c.Request.Context(), userID, request.Code); err != nil {
+		h.logger.Printf("Error confirming phone verification: %v", err)
+		c.JSON(otpErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "phone number verified"})
+}
+
+// RequestPasswordReset sends a password reset code to a verified phone number
+// @Summary Request a password reset
+// @Description Texts a reset code to the phone number if an account has verified it. The response is the same whether or not such an account exists.
+// @Tags phone
+// @Accept json
+// @Produce json
+// @Param request body object{phone=string} true "Verified phone number of the account"
+// @Success 200 {object} map[string]string "Code sent if the number belongs to an account"
+// @Failure 400 {object} map[string]string "Invalid phone number"
+// @Failure 429 {object} map[string]string "A code was requested recently"
+// @Router /users/password/reset/request [post]
+func (h *UserHandler) RequestPasswordReset(c *gin.Context) {
+	var request struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.repo.RequestPasswordReset(c.Request.Context(), request.Phone); err != nil {
+		h.logger.Printf("Error requesting password reset: %v", err)
+		c.JSON(otpErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "if this phone number belongs to an account, a reset code has been sent"})
+}
+
+// ResetPassword sets a new password using the code sent to the account's phone
+// @Summary Reset the password
+// @Description Sets a new password using the code from /users/password/reset/request. Every session of the account is revoked.
+// @Tags phone
+// @Accept json
+// @Produce json
+// @Param request body object{phone=string,code=string,new_password=string} true "Phone number, code from the SMS and the new password"
+// @Success 200 {object} map[string]string "Password reset"
+// @Failure 400 {object} map[string]string "Invalid or expired code, or invalid password"
+// @Failure 429 {object} map[string]string "Too many attempts"
+// @Router /users/password/reset [post]
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var request struct {
+		Phone       string `json:"phone" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.repo.ResetPassword(c.Request.Context(), request.Phone, request.Code, request.NewPassword); err != nil {
+		h.logger.Printf("Error resetting password: %v", err)
+		c.JSON(otpErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "password has been reset, log in with the new password"})
+}
+
+func otpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, dto.ErrResendTooEarly), errors.Is(err, dto.ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, dto.ErrPhoneTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -1,14 +1,18 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin" // Assuming your model is here
+	"github.com/gin-gonic/gin/binding"
 	dto "github.com/ruziba3vich/soand/internal/dtos"
 	_ "github.com/ruziba3vich/soand/internal/models"
 	"github.com/ruziba3vich/soand/internal/repos" // Assuming a package for common swagger DTOs
+	"github.com/ruziba3vich/soand/internal/storage"
 	_ "github.com/ruziba3vich/soand/pkg/swagger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -29,15 +33,17 @@ func NewPostHandler(service repos.IPostService, logger *log.Logger) *PostHandler
 
 // CreatePost creates a new post from a JSON payload
 // @Summary Create a new post
-// @Description Creates a post with description and tags from a JSON body. Note: This version does not support file uploads.
+// @Description Creates a post with description and tags from a JSON body. Without delete_after the post lives for the creator's default post lifetime setting,
+// @Description otherwise delete_after must be at most the longest lifetime this server allows (POST_MAX_TTL). Note: This version does not support file uploads.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param postRequest body dto.PostRequest true "Post creation payload"
 // @Success 201 {object} swagger.Response{data=models.Post} "Post created successfully"
-// @Failure 400 {object} swagger.ErrorResponse "Invalid request payload"
+// @Failure 400 {object} swagger.ErrorResponse "Invalid request payload or post lifetime"
 // @Failure 401 {object} swagger.ErrorResponse "Unauthorized"
+// @Failure 403 {object} swagger.ErrorResponse "A picture was not uploaded by you"
 // @Failure 500 {object} swagger.ErrorResponse "Internal server error"
 // @Router /posts [post]
 func (h *PostHandler) CreatePost(c *gin.Context) {
@@ -54,6 +60,10 @@ func (h *PostHandler) CreatePost(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request : " + err.Error()})
 		return
 	}
+	if err := req.Validate(h.service.MaxTTLHours()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// This part of the code is now unreachable if you are using ShouldBindJSON,
 	// because c.PostForm reads from form data, not a JSON body.
@@ -78,7 +88,7 @@ func (h *PostHandler) CreatePost(c *gin.Context) {
 
 	err = h.service.CreatePost(c.Request.Context(), post, req.DeleteAfter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create post"})
+		h.respondError(c, err, "Failed to create post")
 		return
 	}
 
@@ -138,52 +148,101 @@ func (h *PostHandler) GetAllPosts(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": posts})
 }
 
-// UpdatePost updates an existing post
-// @Summary Update a post
-// @Description Updates a post by its ID (from the URL path) with data from a JSON body.
-// @Description **Security Note:** The current implementation is insecure as it takes `creator_id` from the body and does not check for post ownership.
+// EditPost edits the content of a post
+// @Summary Edit a post
+// @Description Changes the title, description, tags or pics of your post, fields left out keep their value. Any other field is refused.
+// @Description Send the version of the post you edited: if it was edited since, the edit is refused with 409 and you should reload the post.
+// @Description The content the post had before is kept and listed at /posts/{id}/revisions.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param id path string true "Post ID (MongoDB ObjectID)" Format(hex)
-// @Param updateRequest body map[string]interface{} true "Fields to update in JSON format"
-// @Success 200 {object} swagger.SuccessResponse "Post updated successfully"
-// @Failure 400 {object} swagger.ErrorResponse "Invalid post ID or payload"
+// @Param request body dto.PostUpdateRequest true "The version edited and the fields to change"
+// @Success 200 {object} swagger.Response{data=models.Post} "The edited post"
+// @Failure 400 {object} swagger.ErrorResponse "Invalid post ID or payload, nothing to change, or an empty description"
 // @Failure 401 {object} swagger.ErrorResponse "Unauthorized"
-// @Failure 500 {object} swagger.ErrorResponse "Failed to update post"
-// @Router /posts/{id} [put]
-func (h *PostHandler) UpdatePost(c *gin.Context) {
-	idParam := c.Param("id")
-	id, err := primitive.ObjectIDFromHex(idParam)
+// @Failure 403 {object} swagger.ErrorResponse "Not the creator of the post, or a picture was not uploaded by you"
+// @Failure 404 {object} swagger.ErrorResponse "Post not found"
+// @Failure 409 {object} swagger.ErrorResponse "The post was edited since this version"
+// @Router /posts/{id} [patch]
+func (h *PostHandler) EditPost(c *gin.Context) {
+	userId, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		h.logger.Println("error", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
 		return
 	}
 
-	var updateData map[string]any
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		h.logger.Println("error", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update payload " + err.Error()})
+	// unknown fields are refused rather than ignored, so a client sending creator_id or delete_at
+	// learns they cannot be changed here
+	var req dto.PostUpdateRequest
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update payload: " + err.Error()})
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update payload: " + err.Error()})
 		return
 	}
 
-	// FIXME: This is a security vulnerability. The updater's ID should come from the request context (token), not the payload.
-	updaterId, _ := primitive.ObjectIDFromHex(updateData["creator_id"].(string))
+	post, err := h.service.EditPost(c.Request.Context(), id, userId, &req)
+	if err != nil {
+		h.respondError(c, err, "Failed to edit post")
+		return
+	}
 
-	if err := h.service.UpdatePost(c.Request.Context(), id, updaterId, updateData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
+	c.JSON(http.StatusOK, gin.H{"data": post})
+}
+
+// GetRevisions lists the earlier versions of a post
+// @Summary Get a post's edit history
+// @Description Returns a page of the versions the post had before its edits, newest first.
+// @Tags posts
+// @Produce json
+// @Param id path string true "Post ID (MongoDB ObjectID)" Format(hex)
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of revisions per page" default(10)
+// @Success 200 {object} swagger.Response{data=[]models.PostRevision} "Earlier versions"
+// @Failure 400 {object} swagger.ErrorResponse "Invalid post ID format"
+// @Failure 404 {object} swagger.ErrorResponse "Post not found"
+// @Router /posts/{id}/revisions [get]
+func (h *PostHandler) GetRevisions(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": "Post updated successfully"})
+	page := stringToInt64(c.DefaultQuery("page", "1"))
+	pageSize := stringToInt64(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	revisions, err := h.service.GetRevisions(c.Request.Context(), id, page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch post revisions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": revisions})
 }
 
 // DeletePost deletes a post by its ID
 // @Summary Delete a post
-// @Description Deletes a post using its MongoDB ObjectID from the URL path.
-// @Description **Security Note:** This does not currently check if the requester is the owner of the post.
+// @Description Deletes your post, or any post if you are a moderator, with its comments, likes, pins, edit history and pictures.
+// @Description Subscribers of the post's comments get a "post_deleted" event and their WebSocket is closed.
 // @Tags posts
 // @Accept json
 // @Produce json
@@ -192,9 +251,17 @@ func (h *PostHandler) UpdatePost(c *gin.Context) {
 // @Success 200 {object} swagger.SuccessResponse "Post deleted successfully"
 // @Failure 400 {object} swagger.ErrorResponse "Invalid post ID format"
 // @Failure 401 {object} swagger.ErrorResponse "Unauthorized"
+// @Failure 403 {object} swagger.ErrorResponse "Neither the creator of the post nor a moderator"
+// @Failure 404 {object} swagger.ErrorResponse "Post not found"
 // @Failure 500 {object} swagger.ErrorResponse "Failed to delete post"
 // @Router /posts/{id} [delete]
 func (h *PostHandler) DeletePost(c *gin.Context) {
+	userId, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	idParam := c.Param("id")
 	id, err := primitive.ObjectIDFromHex(idParam)
 	if err != nil {
@@ -203,14 +270,61 @@ func (h *PostHandler) DeletePost(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.DeletePost(c.Request.Context(), id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post"})
+	if err := h.service.DeletePost(c.Request.Context(), id, userId, getRoleFromRequest(c)); err != nil {
+		h.respondError(c, err, "Failed to delete post")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": "Post deleted successfully"})
 }
 
+// ChangeLifetime changes when a post expires
+// @Summary Change a post's lifetime
+// @Description Lets the creator keep a post before it expires. "extend" pushes the expiry back by the given hours, "renew" sets it to the given hours from now
+// @Description and "permanent" keeps the post until it is deleted, if the server allows permanent posts. A post cannot be set to expire later than the
+// @Description longest lifetime this server allows (POST_MAX_TTL) from now. Subscribers of the post's comments get the new expiry as a "lifetime" event.
+// @Tags posts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Post ID" Format(hex)
+// @Param request body dto.PostLifetimeRequest true "How to change the lifetime"
+// @Success 200 {object} swagger.Response{data=models.PostLifetime} "The new lifetime"
+// @Failure 400 {object} swagger.ErrorResponse "Invalid post ID, action or hours, or an extend of a permanent post"
+// @Failure 401 {object} swagger.ErrorResponse "Unauthorized"
+// @Failure 403 {object} swagger.ErrorResponse "Not the creator of the post, or permanent posts are disabled"
+// @Failure 404 {object} swagger.ErrorResponse "Post not found"
+// @Failure 409 {object} swagger.ErrorResponse "The post was changed at the same time"
+// @Failure 410 {object} swagger.ErrorResponse "The post has expired"
+// @Router /posts/{id}/lifetime [patch]
+func (h *PostHandler) ChangeLifetime(c *gin.Context) {
+	userId, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+		return
+	}
+
+	var req dto.PostLifetimeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request : " + err.Error()})
+		return
+	}
+
+	lifetime, err := h.service.ChangeLifetime(c.Request.Context(), id, userId, &req)
+	if err != nil {
+		h.respondError(c, err, "Failed to change post lifetime")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": lifetime})
+}
+
 // SearchPostsByTitle searches for posts by title
 // @Summary Search for posts
 // @Description Searches for posts by title (from a JSON body) with pagination (from query parameters).
@@ -326,3 +440,24 @@ func stringToInt(s string) int {
 	}
 	return num
 }
+
+func (h *PostHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrInvalidPostLifetime), errors.Is(err, dto.ErrInvalidLifetimeAction),
+		errors.Is(err, dto.ErrPostPermanent), errors.Is(err, dto.ErrEmptyPostUpdate),
+		errors.Is(err, dto.ErrDescriptionRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrNotPostCreator), errors.Is(err, dto.ErrPermanentPostsOff),
+		errors.Is(err, dto.ErrPostDeleteForbidden), errors.Is(err, dto.ErrFileNotOwned):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrPostNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+	case errors.Is(err, dto.ErrPostChanged), errors.Is(err, dto.ErrPostVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrPostExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxPresenceIDs caps how many users one presence query may ask about
+const maxPresenceIDs = 100
+
+// PresenceHandler handles presence queries
+type PresenceHandler struct {
+	service repos.IPresenceService
+	logger  *log.Logger
+}
+
+// NewPresenceHandler creates a new PresenceHandler instance
+func NewPresenceHandler(service repos.IPresenceService, logger *log.Logger) *PresenceHandler {
+	return &PresenceHandler{service: service, logger: logger}
+}
+
+// GetPresence returns whether users are online and when they were last seen
+// @Summary Get presence of users
+// @Description Returns whether each user is online and when they were last seen. Users who hide their last seen, and users blocked in either direction, come back with hidden set. Unknown and deactivated users are left out.
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Param ids query string true "Comma separated user IDs, at most 100"
+// @Success 200 {object} swagger.Response{data=[]models.Presence} "Presence of the users"
+// @Failure 400 {object} map[string]string "Missing, invalid or too many user IDs"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to fetch presence"
+// @Router /users/presence [get]
+func (h *PresenceHandler) GetPresence(c *gin.Context) {
+	viewerID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var userIDs []primitive.ObjectID
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID: " + id})
+			return
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	if len(userIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+	if len(userIDs) > maxPresenceIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at most 100 user IDs can be queried at once"})
+		return
+	}
+
+	presences, err := h.service.GetPresence(c.Request.Context(), viewerID, userIDs)
+	if err != nil {
+		h.logger.Printf("Failed to fetch presence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": presences})
+}
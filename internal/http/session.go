@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetSessions lists the devices the authenticated user is logged in on
+// @Summary List active sessions
+// @Description Returns every active session (device) of the authenticated user, most recently used first. The session making the request is flagged with current=true.
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} swagger.Response{data=[]models.Session} "Active sessions"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to fetch sessions"
+// @Router /users/sessions [get]
+func (h *UserHandler) GetSessions(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := getSessionIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := h.repo.GetSessions(c.Request.Context(), userID, sessionID)
+	if err != nil {
+		h.logger.Printf("Error fetching sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeSession logs the authenticated user out of one device
+// @Summary Revoke a session
+// @Description Revokes one of the authenticated user's sessions. Access and refresh tokens of that session stop working immediately.
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string "Session revoked"
+// @Failure 400 {object} map[string]string "Invalid session ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 404 {object} map[string]string "Session not found"
+// @Router /users/sessions/{id} [delete]
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+
+	if err := h.repo.RevokeSession(c.Request.Context(), userID, sessionID); err != nil {
+		h.logger.Printf("Error revoking session: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "session revoked"})
+}
+
+// RevokeOtherSessions logs the authenticated user out of every other device
+// @Summary Revoke all other sessions
+// @Description Revokes every session of the authenticated user except the one making the request.
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string "Other sessions revoked"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to revoke sessions"
+// @Router /users/sessions [delete]
+func (h *UserHandler) RevokeOtherSessions(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := getSessionIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.repo.RevokeOtherSessions(c.Request.Context(), userID, sessionID); err != nil {
+		h.logger.Printf("Error revoking other sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "other sessions revoked"})
+}
+
+// sessionMetaFromRequest collects the device details stored on a session.
+// The device name comes from the request body when given, otherwise from the X-Device-Name header.
+func sessionMetaFromRequest(c *gin.Context, deviceName string) *models.SessionMeta {
+	if deviceName == "" {
+		deviceName = c.GetHeader("X-Device-Name")
+	}
+
+	return &models.SessionMeta{
+		DeviceName: deviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+)
+
+// EnrollTwoFactor starts enabling TOTP two-factor authentication
+// @Summary Start 2FA enrolment
+// @Description Generates a new TOTP secret and returns it with an otpauth:// URI to show as a QR code. 2FA is not enabled until the first code is confirmed.
+// @Tags 2fa
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} swagger.Response{data=models.TwoFactorEnrollment} "TOTP secret and otpauth URI"
+// @Failure 400 {object} map[string]string "2FA is already enabled"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/2fa/enroll [post]
+func (h *UserHandler) EnrollTwoFactor(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	enrollment, err := h.repo.EnrollTwoFactor(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Error starting 2FA enrolment: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+}
+
+// ConfirmTwoFactor finishes enabling 2FA with the first code from the authenticator app
+// @Summary Confirm 2FA enrolment
+// @Description Enables 2FA once a valid code for the pending secret is sent. Returns ten single-use recovery codes; they are shown only once.
+// @Tags 2fa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body object{code=string} true "Current TOTP code"
+// @Success 200 {object} swagger.Response{data=object{recovery_codes=[]string}} "Recovery codes"
+// @Failure 400 {object} map[string]string "Invalid code or no enrolment in progress"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/2fa/confirm [post]
+func (h *UserHandler) ConfirmTwoFactor(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	codes, err := h.repo.ConfirmTwoFactor(c.Request.Context(), userID, request.Code)
+	if err != nil {
+		h.logger.Printf("Error confirming 2FA enrolment: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"recovery_codes": codes}})
+}
+
+// DisableTwoFactor turns 2FA off
+// @Summary Disable 2FA
+// @Description Disables two-factor authentication. Requires the account password and a current TOTP code or a recovery code. Users without a password, who sign in through an identity provider, give the code alone.
+// @Tags 2fa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body object{password=string,code=string} true "Password, unless the account has none, and a TOTP or recovery code"
+// @Success 200 {object} map[string]string "2FA disabled"
+// @Failure 400 {object} map[string]string "Invalid request body or 2FA not enabled"
+// @Failure 401 {object} map[string]string "Wrong password or code"
+// @Failure 429 {object} map[string]string "Too many invalid codes"
+// @Router /users/2fa/disable [post]
+func (h *UserHandler) DisableTwoFactor(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.repo.DisableTwoFactor(c.Request.Context(), userID, request.Password, request.Code); err != nil {
+		h.logger.Printf("Error disabling 2FA: %v", err)
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "two-factor authentication disabled"})
+}
+
+// LoginTwoFactor exchanges a 2FA challenge token and a code for a session
+// @Summary Complete a 2FA login
+// @Description Second step of the login for users with 2FA. Exchanges the challenge_token returned by /users/login and a TOTP or recovery code for an access/refresh token pair.
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Param request body object{challenge_token=string,code=string,device_name=string} true "Challenge token, TOTP or recovery code, and an optional device name"
+// @Success 200 {object} swagger.Response{data=models.AuthTokens} "Access and refresh tokens of the new session"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid challenge token or code"
+// @Failure 429 {object} map[string]string "Too many invalid codes"
+// @Router /users/login/2fa [post]
+func (h *UserHandler) LoginTwoFactor(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+		DeviceName     string `json:"device_name"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.repo.LoginTwoFactor(c.Request.Context(), request.ChallengeToken, request.Code, sessionMetaFromRequest(c, request.DeviceName))
+	if err != nil {
+		h.logger.Printf("Error completing 2FA login: %v", err)
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tokens})
+}
+
+func twoFactorErrorStatus(err error) int {
+	if errors.Is(err, dto.ErrTwoFactorLocked) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
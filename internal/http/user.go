@@ -17,14 +17,21 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/models"
 	"github.com/ruziba3vich/soand/internal/repos"
+	_ "github.com/ruziba3vich/soand/pkg/swagger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -42,51 +49,61 @@ func NewUserHandler(repo repos.UserRepo, file_store repos.IFIleStoreService, log
 
 // CreateUser handles user creation requests
 // @Summary Create a new user
-// @Description Creates a new user with the provided data and returns a JWT authentication token
+// @Description Creates a new user with the provided data and returns an access/refresh token pair
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User data (username and password are required)"
-// @Success 200 {object} map[string]string "Response containing the JWT token, e.g., {'data': 'jwt_token_string'}"
-// @Failure 400 {object} map[string]string "Invalid request body"
+// @Param user body dto.SignupRequest true "User data (full_name and password are required)"
+// @Success 200 {object} swagger.Response{data=models.AuthTokens} "Access and refresh tokens of the new session"
+// @Failure 400 {object} map[string]string "Invalid request body, or the password does not meet the password policy"
 // @Failure 500 {object} map[string]string "Failed to create user"
 // @Router /users/ [post]
 func (h *UserHandler) CreateUser(c *gin.Context) {
-	var user models.User
+	var request dto.SignupRequest
 
-	if err := c.ShouldBindJSON(&user); err != nil {
+	if err := c.ShouldBindJSON(&request); err != nil {
 		h.logger.Printf("Error parsing user data: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
+	user := request.ToUser()
 	user.Status = "basic"
-	h.logger.Println(user.Password, len(user.Password))
 
-	token, err := h.repo.CreateUser(c.Request.Context(), &user)
+	tokens, err := h.repo.CreateUser(c.Request.Context(), user, sessionMetaFromRequest(c, ""))
 	if err != nil {
 		h.logger.Printf("Error creating user: %v", err)
+		if isPasswordPolicyError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": token})
+	c.JSON(http.StatusOK, gin.H{"data": tokens})
 }
 
 // LoginUser handles user login requests
 // @Summary Login a user
-// @Description Authenticates a user with username and password, returning a JWT authentication token
+// @Description Authenticates a user with username and password and opens a new session, returning an access/refresh token pair.
+// @Description If the user has two-factor authentication enabled, no session is opened; the response has two_factor_required set
+// @Description and a short-lived challenge_token to send to /users/login/2fa together with a code.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param credentials body object{username=string,password=string} true "User login credentials"
-// @Success 200 {object} map[string]string "Response containing the JWT token, e.g., {'data': 'jwt_token_string'}"
+// @Param credentials body object{username=string,password=string,device_name=string} true "User login credentials and an optional device name shown in the sessions list"
+// @Success 200 {object} swagger.Response{data=models.LoginResult} "Tokens of the new session, or a 2FA challenge"
 // @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid username or password"
+// @Failure 403 {object} map[string]string "The account is deactivated, log in through /users/reactivate"
+// @Failure 429 {object} map[string]string "Too many failed logins for this username or from this IP, retry after the Retry-After header"
 // @Failure 500 {object} map[string]string "Failed to login user"
 // @Router /users/login [post]
 func (h *UserHandler) LoginUser(c *gin.Context) {
 	var request struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -95,45 +112,154 @@ func (h *UserHandler) LoginUser(c *gin.Context) {
 		return
 	}
 
-	token, err := h.repo.LoginUser(c.Request.Context(), request.Username, request.Password)
+	result, err := h.repo.LoginUser(c.Request.Context(), request.Username, request.Password, sessionMetaFromRequest(c, request.DeviceName))
 	if err != nil {
 		h.logger.Printf("Error logging in user: %v", err)
+		if errors.Is(err, dto.ErrAccountDeactivated) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if respondLoginFailure(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to login user " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": token})
+	c.JSON(http.StatusOK, gin.H{"data": result})
 }
 
-// DeleteUser handles user deletion requests
-// @Summary Delete the authenticated user
-// @Description Deletes the authenticated user's account using their JWT token.
+// ReactivateUser handles logins to deactivated accounts
+// @Summary Reactivate a deactivated account
+// @Description Logs in to a deactivated account with username and password, which reactivates it and cancels its scheduled deletion.
+// @Description The response is the same as for /users/login, so with two-factor authentication enabled the login is finished at /users/login/2fa.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param credentials body object{username=string,password=string,device_name=string} true "User login credentials and an optional device name shown in the sessions list"
+// @Success 200 {object} swagger.Response{data=models.LoginResult} "Tokens of the new session, or a 2FA challenge"
+// @Failure 400 {object} map[string]string "Invalid request body or the account is not deactivated"
+// @Failure 401 {object} map[string]string "Invalid username or password"
+// @Failure 429 {object} map[string]string "Too many failed logins for this username or from this IP, retry after the Retry-After header"
+// @Router /users/reactivate [post]
+func (h *UserHandler) ReactivateUser(c *gin.Context) {
+	var request struct {
+		Username   string `json:"username" binding:"required"`
+		Password   string `json:"password" binding:"required"`
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	result, err := h.repo.ReactivateUser(c.Request.Context(), request.Username, request.Password, sessionMetaFromRequest(c, request.DeviceName))
+	if err != nil {
+		if respondLoginFailure(c, err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// RefreshToken handles access token refresh requests
+// @Summary Refresh the access token
+// @Description Exchanges a refresh token for a new access/refresh token pair. The presented refresh token is rotated and can not be used again; reusing it revokes the session.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body object{refresh_token=string} true "Refresh token"
+// @Success 200 {object} swagger.Response{data=models.AuthTokens} "New access and refresh tokens"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid, expired or revoked refresh token"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Router /users/token/refresh [post]
+func (h *UserHandler) RefreshToken(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.logger.Printf("Error parsing refresh request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.repo.RefreshTokens(c.Request.Context(), request.RefreshToken, sessionMetaFromRequest(c, ""))
+	if err != nil {
+		h.logger.Printf("Error refreshing tokens: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tokens})
+}
+
+// Logout handles logout requests
+// @Summary Log out of the current session
+// @Description Revokes the session the access token belongs to. Its refresh token stops working immediately.
 // @Tags users
 // @Security BearerAuth
 // @Produce json
-// @Success 200 {object} map[string]string "User deleted successfully"
+// @Success 200 {object} map[string]string "Logged out successfully"
 // @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
-// @Failure 500 {object} map[string]string "Failed to delete user"
-// @Router /users/ [delete]
-func (h *UserHandler) DeleteUser(c *gin.Context) {
-	userId, err := getUserIdFromRequest(c)
+// @Failure 500 {object} map[string]string "Failed to log out"
+// @Router /users/logout [post]
+func (h *UserHandler) Logout(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := getSessionIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.repo.Logout(c.Request.Context(), userID, sessionID); err != nil {
+		h.logger.Printf("Error logging out: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "logged out successfully"})
+}
+
+// LogoutAll handles "log out all devices" requests
+// @Summary Log out of all devices
+// @Description Revokes every session of the authenticated user, including the current one.
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string "Logged out of all devices"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to log out"
+// @Router /users/logout/all [post]
+func (h *UserHandler) LogoutAll(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	if err := h.repo.DeleteUser(c.Request.Context(), userId); err != nil {
-		h.logger.Printf("Error deleting user: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+	if err := h.repo.LogoutAll(c.Request.Context(), userID); err != nil {
+		h.logger.Printf("Error logging out of all devices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": "user has successfully been deleted"})
+	c.JSON(http.StatusOK, gin.H{"data": "logged out of all devices"})
 }
 
 // GetUserByID handles retrieving a user by ID
 // @Summary Get a public user profile by ID
-// @Description Retrieves public user details by their ID. This is a public endpoint.
+// @Description Retrieves public user details by their ID. This is a public endpoint; signed-in users get 404 for accounts that blocked them.
 // @Tags users
 // @Produce json
 // @Param id path string true "User ID (MongoDB ObjectID)"
@@ -154,7 +280,9 @@ func (h *UserHandler) GetUserByID(c *gin.Context) {
 		return
 	}
 
-	user, err := h.repo.GetUserByID(c.Request.Context(), userID)
+	viewerID, _ := getUserIdFromRequest(c)
+
+	user, err := h.repo.GetUserByID(c.Request.Context(), viewerID, userID)
 	if err != nil {
 		h.logger.Printf("Error fetching user: %v", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
@@ -166,26 +294,131 @@ func (h *UserHandler) GetUserByID(c *gin.Context) {
 
 // GetUserByUsername handles retrieving a user by username
 // @Summary Get a public user profile by username
-// @Description Retrieves public user details by their username. This is a public endpoint.
+// @Description Retrieves public user details by their username. This is a public endpoint; signed-in users get 404 for accounts that blocked them.
+// @Description A username its owner changed recently still finds them: the user then has renamed_from set and the response carries a 'redirect' to their current profile.
 // @Tags users
 // @Produce json
 // @Param username path string true "Username of the user"
-// @Success 200 {object} map[string]interface{} "User details wrapped in a 'data' key"
+// @Success 200 {object} map[string]interface{} "User details wrapped in a 'data' key, plus 'redirect' when found by a previous username"
 // @Failure 404 {object} map[string]string "User not found"
 // @Router /users/username/{username} [get]
 func (h *UserHandler) GetUserByUsername(c *gin.Context) {
 	username := c.Param("username")
 
-	user, err := h.repo.GetUserByUsername(c.Request.Context(), username)
+	viewerID, _ := getUserIdFromRequest(c)
+
+	user, err := h.repo.GetUserByUsername(c.Request.Context(), viewerID, username)
 	if err != nil {
 		h.logger.Printf("Error fetching user: %v", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
+	if user.RenamedFrom != "" && user.Username != nil {
+		c.JSON(http.StatusOK, gin.H{"data": user, "redirect": "/users/username/" + url.PathEscape(*user.Username)})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": user})
 }
 
+// SearchUsers handles searching users by username and full name
+// @Summary Search users
+// @Description Finds users by username and full name. Matches prefixes of the username and of every name, and tolerates small typos. Results are ranked, best first; deactivated and hidden profiles are never returned, and signed-in users do not see users blocked in either direction.
+// @Tags users
+// @Produce json
+// @Param q query string true "Search query, a leading @ is ignored"
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of users per page" default(20)
+// @Success 200 {object} swagger.Response{data=[]models.UserSummary} "Matching users"
+// @Failure 400 {object} map[string]string "Empty search query"
+// @Failure 500 {object} map[string]string "Failed to search users"
+// @Router /users/search [get]
+func (h *UserHandler) SearchUsers(c *gin.Context) {
+	viewerID, _ := getUserIdFromRequest(c)
+	page, pageSize := pageParams(c)
+
+	users, err := h.repo.SearchUsers(c.Request.Context(), viewerID, c.Query("q"), page, pageSize)
+	if errors.Is(err, dto.ErrEmptySearchQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Printf("Error searching users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// GetSettings returns the settings of the authenticated user
+// @Summary Get my settings
+// @Description Returns the privacy and notification settings of the authenticated user. Users who never changed them get the defaults.
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} swagger.Response{data=models.UserSettings} "Settings"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to fetch settings"
+// @Router /users/me/settings [get]
+func (h *UserHandler) GetSettings(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	settings, err := h.repo.GetSettings(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Error fetching settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": settings})
+}
+
+// UpdateSettings changes settings of the authenticated user
+// @Summary Update my settings
+// @Description Changes the settings present in the body and leaves the others alone. direct_messages and profile_pictures take everyone, followers or nobody; default_post_ttl is in hours, between 1 and 720.
+// @Tags users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param settings body models.SettingsUpdate true "Settings to change"
+// @Success 200 {object} swagger.Response{data=models.UserSettings} "Settings after the change"
+// @Failure 400 {object} map[string]string "Invalid request body or setting value"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 500 {object} map[string]string "Failed to update settings"
+// @Router /users/me/settings [patch]
+func (h *UserHandler) UpdateSettings(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var update models.SettingsUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	settings, err := h.repo.UpdateSettings(c.Request.Context(), userID, &update)
+	if errors.Is(err, dto.ErrInvalidAudience) || errors.Is(err, dto.ErrInvalidPostTTL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Printf("Error updating settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": settings})
+}
+
 // UpdatePassword handles updating a user's password
 // @Summary Update user password
 // @Description Updates the authenticated user's password after verifying the old password
@@ -193,9 +426,9 @@ func (h *UserHandler) GetUserByUsername(c *gin.Context) {
 // @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param passwords body object{old_password=string,new_password=string} true "Old and new passwords"
+// @Param passwords body object{old_password=string,new_password=string} true "Old and new passwords, users created by a provider login set their first password without old_password"
 // @Success 200 {object} map[string]string "Password updated successfully"
-// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 400 {object} map[string]string "Invalid request body, or the new password does not meet the password policy"
 // @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
 // @Failure 500 {object} map[string]string "Failed to update password"
 // @Router /users/password [patch]
@@ -218,6 +451,10 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 
 	if err := h.repo.UpdatePassword(c.Request.Context(), userId, request.OldPassword, request.NewPassword); err != nil {
 		h.logger.Printf("Error updating password: %v", err)
+		if isPasswordPolicyError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
@@ -227,15 +464,18 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 
 // UpdateUsername handles updating a user's username
 // @Summary Update user username
-// @Description Updates the authenticated user's username to a new value
+// @Description Updates the authenticated user's username to a new value. The old username stays reserved for the user and redirects to them for a while;
+// @Description usernames can only be changed a limited number of times per period. A verified user loses the badge.
 // @Tags users
 // @Security BearerAuth
 // @Accept json
 // @Produce json
 // @Param username body object{new_username=string} true "New username"
 // @Success 200 {object} map[string]string "Username updated successfully"
-// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 400 {object} map[string]string "Invalid request body or empty username"
 // @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 409 {object} map[string]string "Username taken or reserved"
+// @Failure 429 {object} map[string]string "Too many username changes"
 // @Failure 500 {object} map[string]string "Failed to update username"
 // @Router /users/username [patch]
 func (h *UserHandler) UpdateUsername(c *gin.Context) {
@@ -255,7 +495,18 @@ func (h *UserHandler) UpdateUsername(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.UpdateUsername(c.Request.Context(), userId, request.NewUsername); err != nil {
+	err = h.repo.UpdateUsername(c.Request.Context(), userId, request.NewUsername)
+	switch {
+	case errors.Is(err, dto.ErrInvalidUsername):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, dto.ErrUsernameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, dto.ErrUsernameChangeLimit):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		h.logger.Printf("Error updating username: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update username"})
 		return
@@ -266,7 +517,7 @@ func (h *UserHandler) UpdateUsername(c *gin.Context) {
 
 // UpdateUser handles updating user data
 // @Summary Update user data
-// @Description Updates the authenticated user's data based on the provided fields. Only non-nil fields in the request will be updated.
+// @Description Updates the authenticated user's data based on the provided fields. Only non-nil fields in the request will be updated. Changing full_name takes away the verified badge.
 // @Tags users
 // @Security BearerAuth
 // @Accept json
@@ -307,6 +558,33 @@ func getUserIdFromRequest(c *gin.Context) (primitive.ObjectID, error) {
 	return oid, err
 }
 
+// getRoleFromRequest returns the role the request was authenticated with
+func getRoleFromRequest(c *gin.Context) string {
+	role, _ := c.Get("role")
+	current, _ := role.(string)
+	return current
+}
+
+// hasScope reports whether the request may do what scope grants. JWTs may do everything, API keys
+// only what they were granted.
+func hasScope(c *gin.Context, scope string) bool {
+	scopes, isAPIKey := c.Get("apiKeyScopes")
+	if !isAPIKey {
+		return true
+	}
+	granted, _ := scopes.([]string)
+	return slices.Contains(granted, scope)
+}
+
+func getSessionIdFromRequest(c *gin.Context) (primitive.ObjectID, error) {
+	sessionID, exists := c.Get("sessionID")
+	if !exists {
+		return primitive.NilObjectID, fmt.Errorf("no session in request context")
+	}
+
+	return primitive.ObjectIDFromHex(sessionID.(string))
+}
+
 // SetBackgroundPic handles setting a user's chat background picture
 // @Summary Set user background picture
 // @Description Updates the authenticated user's chat background picture using a provided picture ID
@@ -365,6 +643,11 @@ func (h *UserHandler) Home(c *gin.Context) {
 	}
 
 	parts := strings.Split(tokenString, " ")
+	if len(parts) != 2 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
 
 	_, err := h.repo.ValidateJWT(parts[1])
 	if err != nil {
@@ -404,7 +687,7 @@ func (h *UserHandler) AddProfilePicture(c *gin.Context) {
 		return
 	}
 
-	fileObj, err := h.file_store.UploadFile(file)
+	fileObj, err := h.file_store.UploadFile(file, userID)
 	if err != nil {
 		h.logger.Println("Failed to upload file to MinIO:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload file"})
@@ -445,7 +728,7 @@ func (h *UserHandler) AddProfilePicture(c *gin.Context) {
 
 // DeleteProfilePicture godoc
 // @Summary      Delete a profile picture
-// @Description  Removes a profile picture from the authenticated user's profile and deletes it from storage.
+// @Description  Removes a profile picture from the authenticated user's profile and deletes it from storage if you uploaded it.
 // @Tags         Profile
 // @Security     BearerAuth
 // @Produce      json
@@ -470,11 +753,7 @@ func (h *UserHandler) DeleteProfilePicture(c *gin.Context) {
 		return
 	}
 
-	if err := h.file_store.DeleteFile(fileURL); err != nil {
-		// Note: We might still proceed to remove from DB even if file deletion fails.
-		h.logger.Printf("Failed to delete file %s from storage, but will attempt to remove from DB: %v", fileURL, err)
-	}
-
+	// removing it from the profile first makes sure it is one of the user's pictures
 	err = h.repo.DeleteProfilePicture(c.Request.Context(), userID, fileURL)
 	if err != nil {
 		h.logger.Println("Failed to delete profile picture from MongoDB:", err)
@@ -482,17 +761,23 @@ func (h *UserHandler) DeleteProfilePicture(c *gin.Context) {
 		return
 	}
 
+	if err := h.file_store.DeleteOwnedFile(c.Request.Context(), userID, fileURL); err != nil {
+		// the picture is off the profile either way, a file left behind only takes space
+		h.logger.Printf("Failed to delete file %s from storage: %v", fileURL, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": "profile picture deleted"})
 }
 
 // GetProfilePictures godoc
 // @Summary      Get all profile pictures for a user
-// @Description  Retrieves all profile pictures for a given user ID, sorted by posted date (newest to oldest). This is a public endpoint.
+// @Description  Retrieves all profile pictures for a given user ID, sorted by posted date (newest to oldest). This is a public endpoint; the list is empty when the user's settings keep their pictures from the caller.
 // @Tags         Profile
 // @Produce      json
 // @Param        id   query     string  true  "User ID for whom to fetch pictures"
 // @Success      200  {object}  map[string]interface{}  "List of profile pictures with URLs and posted dates, wrapped in a 'data' key"
 // @Failure      400  {object}  map[string]string "Invalid or missing user ID"
+// @Failure      404  {object}  map[string]string "User not found"
 // @Failure      500  {object}  map[string]string "Server error fetching pictures"
 // @Router       /users/profile/pic [get]
 func (h *UserHandler) GetProfilePictures(c *gin.Context) {
@@ -505,9 +790,16 @@ func (h *UserHandler) GetProfilePictures(c *gin.Context) {
 	userID, err := primitive.ObjectIDFromHex(userIDstr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id provided"})
+		return
 	}
 
-	pics, err := h.repo.GetProfilePictures(c.Request.Context(), userID)
+	viewerID, _ := getUserIdFromRequest(c)
+
+	pics, err := h.repo.GetProfilePictures(c.Request.Context(), viewerID, userID)
+	if errors.Is(err, dto.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
 	if err != nil {
 		h.logger.Println("Failed to fetch profile pictures:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -533,10 +825,34 @@ func (h *UserHandler) GetUserMe(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	user, err := h.repo.GetUserByID(c, userId)
+	user, err := h.repo.GetUserByID(c, userId, userId)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": user})
 }
+
+// isPasswordPolicyError reports whether err rejects a password the user chose
+func isPasswordPolicyError(err error) bool {
+	return errors.Is(err, dto.ErrPasswordTooShort) ||
+		errors.Is(err, dto.ErrPasswordTooLong) ||
+		errors.Is(err, dto.ErrPasswordTooCommon)
+}
+
+// respondLoginFailure answers wrong credentials and lockouts of password logins and reports whether err was one.
+// Both are answered the same whether or not the username exists.
+func respondLoginFailure(c *gin.Context, err error) bool {
+	var locked *dto.LoginLockedError
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return true
+	case errors.Is(err, dto.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VerificationHandler handles verified badge requests and their review by moderators
+type VerificationHandler struct {
+	service repos.IVerificationService
+	logger  *log.Logger
+}
+
+// NewVerificationHandler creates a new VerificationHandler instance
+func NewVerificationHandler(service repos.IVerificationService, logger *log.Logger) *VerificationHandler {
+	return &VerificationHandler{service: service, logger: logger}
+}
+
+// RequestVerification asks moderators for the verified badge
+// @Summary Request verification
+// @Description Queues a request for the verified badge, shown next to your name on your profile, posts and comments.
+// @Description Explain who you are and how moderators can confirm it. One request can be pending at a time, and after a rejection you can ask again a week later.
+// @Tags verification
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body object{note=string} true "Who you are and how to confirm it, up to 1000 characters"
+// @Success 201 {object} swagger.Response{data=models.VerificationRequest} "The queued request"
+// @Failure 400 {object} map[string]string "Invalid request body or note"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 409 {object} map[string]string "Already verified, or a request is pending"
+// @Failure 429 {object} map[string]string "The last request was rejected recently"
+// @Router /users/me/verification [post]
+func (h *VerificationHandler) RequestVerification(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Note string `json:"note" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	created, err := h.service.RequestVerification(c.Request.Context(), userID, request.Note)
+	if err != nil {
+		h.respondError(c, err, "Failed to request verification")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": created})
+}
+
+// GetRequests lists the verification requests of the authenticated user
+// @Summary List your verification requests
+// @Description Returns your verification requests with their status, newest first. Rejected requests carry the moderator's reason.
+// @Tags verification
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} swagger.Response{data=[]models.VerificationRequest} "Verification requests"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Router /users/me/verification [get]
+func (h *VerificationHandler) GetRequests(c *gin.Context) {
+	userID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	requests, err := h.service.GetRequests(c.Request.Context(), userID)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch verification requests")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": requests})
+}
+
+// GetPendingRequests lists the verification requests waiting for review
+// @Summary List pending verification requests
+// @Description Returns a page of the pending verification requests, oldest first, with the users who made them.
+// @Tags moderation
+// @Security BearerAuth
+// @Produce json
+// @Param page query integer false "Page number" default(1)
+// @Param pageSize query integer false "Number of requests per page" default(10)
+// @Success 200 {object} swagger.Response{data=[]models.VerificationRequest} "Pending requests"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "Forbidden - moderator role required"
+// @Router /moderation/verifications [get]
+func (h *VerificationHandler) GetPendingRequests(c *gin.Context) {
+	page := stringToInt64(c.DefaultQuery("page", "1"))
+	pageSize := stringToInt64(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	requests, err := h.service.GetPendingRequests(c.Request.Context(), page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "Failed to fetch verification requests")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": requests})
+}
+
+// Approve approves a verification request
+// @Summary Approve a verification request
+// @Description Grants the verified badge to the user who made the request. Moderators cannot review their own requests.
+// @Tags moderation
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Verification request ID"
+// @Success 200 {object} map[string]string "Approved"
+// @Failure 400 {object} map[string]string "Invalid request ID, or your own request"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "Forbidden - moderator role required"
+// @Failure 404 {object} map[string]string "Verification request not found"
+// @Failure 409 {object} map[string]string "The request was reviewed already"
+// @Router /moderation/verifications/{id}/approve [post]
+func (h *VerificationHandler) Approve(c *gin.Context) {
+	moderatorID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	requestID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	if err := h.service.Approve(c.Request.Context(), moderatorID, requestID); err != nil {
+		h.respondError(c, err, "Failed to approve verification request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "approved"})
+}
+
+// Reject rejects a verification request
+// @Summary Reject a verification request
+// @Description Turns the request down. The reason is shown to the user, who can ask again a week later.
+// @Tags moderation
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Verification request ID"
+// @Param request body object{reason=string} true "Why the request was rejected"
+// @Success 200 {object} map[string]string "Rejected"
+// @Failure 400 {object} map[string]string "Invalid request ID or body, a missing reason, or your own request"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "Forbidden - moderator role required"
+// @Failure 404 {object} map[string]string "Verification request not found"
+// @Failure 409 {object} map[string]string "The request was reviewed already"
+// @Router /moderation/verifications/{id}/reject [post]
+func (h *VerificationHandler) Reject(c *gin.Context) {
+	moderatorID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	requestID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.service.Reject(c.Request.Context(), moderatorID, requestID, request.Reason); err != nil {
+		h.respondError(c, err, "Failed to reject verification request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "rejected"})
+}
+
+// Revoke takes the verified badge away from a user
+// @Summary Revoke a user's verification
+// @Description Removes the verified badge, e.g. after the account changed hands. The user can request verification again.
+// @Tags moderation
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]string "Revoked"
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 401 {object} map[string]string "Unauthorized - missing or invalid token"
+// @Failure 403 {object} map[string]string "Forbidden - moderator role required"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /moderation/users/{id}/verification [delete]
+func (h *VerificationHandler) Revoke(c *gin.Context) {
+	moderatorID, err := getUserIdFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.service.Revoke(c.Request.Context(), moderatorID, userID); err != nil {
+		h.respondError(c, err, "Failed to revoke verification")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "revoked"})
+}
+
+func (h *VerificationHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dto.ErrInvalidVerificationNote), errors.Is(err, dto.ErrRejectionReasonRequired),
+		errors.Is(err, dto.ErrOwnVerification):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrVerificationNotFound), errors.Is(err, dto.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrAlreadyVerified), errors.Is(err, dto.ErrVerificationPending),
+		errors.Is(err, dto.ErrVerificationReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, dto.ErrVerificationTooSoon):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		h.logger.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
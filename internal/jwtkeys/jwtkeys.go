@@ -0,0 +1,270 @@
+// Package jwtkeys holds the asymmetric keys soand signs its JWTs with.
+//
+// Keys live in a directory, one PEM file per key, and the file name without
+// the extension is the key id ("kid") written to the token header:
+//
+//	keys/20261016T120000-a1b2.pem      private key, can sign and verify
+//	keys/20250101T090000-c3d4.pub.pem  public key only, can only verify
+//
+// RSA keys sign with RS256 and Ed25519 keys with EdDSA. Every key in the
+// directory is published on /.well-known/jwks.json so other services can
+// verify soand tokens. The signing key is JWT_ACTIVE_KID, or the private key
+// with the greatest kid when it is not set (cmd/keygen names keys by time).
+//
+// Rotation:
+//  1. go run ./cmd/keygen -dir keys writes a new private key.
+//  2. Reload (SIGHUP or restart) with JWT_ACTIVE_KID still pinned to the old
+//     key, so verifiers pick the new public key up from the JWKS first.
+//  3. Point JWT_ACTIVE_KID at the new key and reload; new tokens use it.
+//  4. Once ACCESS_TOKEN_TTL has passed, delete the old private key or replace
+//     it with its .pub.pem to keep verifying for a while longer.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a single signing or verification key
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer    // nil for verification-only keys
+	Public  crypto.PublicKey // *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeySet is the set of keys loaded from the keys directory
+type KeySet struct {
+	mu        sync.RWMutex
+	dir       string
+	pinnedKID string
+	activeKID string
+	keys      map[string]*Key
+}
+
+// JWK is a single public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served on /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Load reads every key from dir. activeKID pins the signing key; when empty the
+// private key with the greatest kid is used.
+func Load(dir, activeKID string) (*KeySet, error) {
+	ks := &KeySet{dir: dir, pinnedKID: activeKID}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// NewEphemeral returns a key set with a single in-memory Ed25519 key. Tokens signed
+// with it stop validating on restart and on other instances, so it is for local use only.
+func NewEphemeral() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: "ephemeral", Method: jwt.SigningMethodEdDSA, Private: private, Public: public}
+	return &KeySet{activeKID: key.ID, keys: map[string]*Key{key.ID: key}}, nil
+}
+
+// Reload re-reads the keys directory, replacing the keys in memory
+func (ks *KeySet) Reload() error {
+	if ks.dir == "" {
+		return errors.New("no keys directory configured")
+	}
+
+	files, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*Key, len(files))
+	var signers []string
+	for _, file := range files {
+		key, err := loadKey(file)
+		if err != nil {
+			return fmt.Errorf("failed to load key %s: %v", file, err)
+		}
+		if _, exists := keys[key.ID]; exists {
+			return fmt.Errorf("duplicate key id %s", key.ID)
+		}
+		keys[key.ID] = key
+		if key.Private != nil {
+			signers = append(signers, key.ID)
+		}
+	}
+
+	if len(signers) == 0 {
+		return fmt.Errorf("no private keys found in %s", ks.dir)
+	}
+
+	activeKID := ks.pinnedKID
+	if activeKID == "" {
+		sort.Strings(signers)
+		activeKID = signers[len(signers)-1]
+	}
+	if key, ok := keys[activeKID]; !ok || key.Private == nil {
+		return fmt.Errorf("active key %s has no private key in %s", activeKID, ks.dir)
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.activeKID = activeKID
+	ks.mu.Unlock()
+
+	return nil
+}
+
+// ActiveKID returns the id of the key new tokens are signed with
+func (ks *KeySet) ActiveKID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.activeKID
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.keys[ks.activeKID]
+	ks.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key from the token's kid header. It also checks
+// the token's alg matches the key so a token can not pick a weaker algorithm.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+// JWKS returns every public key in JSON Web Key Set format
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKS{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// loadKey parses a PEM file into a Key, taking the kid from the file name
+func loadKey(file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	name := filepath.Base(file)
+	key := &Key{ID: strings.TrimSuffix(strings.TrimSuffix(name, ".pem"), ".pub")}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 are supported", parsed)
+	}
+
+	if rsaKey, ok := key.Public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+
+	return key, nil
+}
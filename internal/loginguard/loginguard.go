@@ -0,0 +1,113 @@
+// Package loginguard slows down password guessing. Failed logins are counted in Redis per
+// username and per client IP; once either count reaches its limit, further attempts are
+// locked out for a period that doubles with every additional failure.
+package loginguard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// failScript counts a failure in KEYS[1] and, from ARGV[2] failures on, locks KEYS[2] for
+// ARGV[3] ms doubled per failure past the limit, at most ARGV[4] ms. The counter lives ARGV[1] ms
+// past the lock, so failing again right after a lockout backs off further. Returns the lock in ms.
+var failScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+local lock = 0
+if failures >= limit then
+	lock = math.min(tonumber(ARGV[3]) * 2 ^ math.min(failures - limit, 30), tonumber(ARGV[4]))
+	lock = math.floor(lock)
+	if lock > 0 then
+		redis.call('SET', KEYS[2], failures, 'PX', lock)
+	end
+end
+
+redis.call('PEXPIRE', KEYS[1], window + lock)
+return lock
+`)
+
+// Limits configures a Guard
+type Limits struct {
+	MaxUserFailures int           // failures per username before it is locked
+	MaxIPFailures   int           // failures per IP before it is locked
+	Window          time.Duration // how long failures are remembered
+	BaseLockout     time.Duration // the first lockout, doubled for every further failure
+	MaxLockout      time.Duration
+}
+
+// Guard keeps failed login counters in Redis
+type Guard struct {
+	redis  *redis.Client
+	limits Limits
+}
+
+// NewGuard creates a Guard
+func NewGuard(redis *redis.Client, limits Limits) *Guard {
+	return &Guard{redis: redis, limits: limits}
+}
+
+// Check returns how long logins to username from ip are still locked out, or 0 if they are not
+func (g *Guard) Check(ctx context.Context, username, ip string) (time.Duration, error) {
+	pipe := g.redis.Pipeline()
+	userLock := pipe.PTTL(ctx, lockKey("user", username))
+	ipLock := pipe.PTTL(ctx, lockKey("ip", ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return max(userLock.Val(), ipLock.Val(), 0), nil
+}
+
+// Fail records a failed login to username from ip and returns how long both are now locked out
+func (g *Guard) Fail(ctx context.Context, username, ip string) (time.Duration, error) {
+	userLock, err := g.fail(ctx, "user", username, g.limits.MaxUserFailures)
+	if err != nil {
+		return 0, err
+	}
+
+	ipLock, err := g.fail(ctx, "ip", ip, g.limits.MaxIPFailures)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(userLock, ipLock), nil
+}
+
+// Succeed forgets the failures of username after a successful login. Failures of the IP are
+// kept, one valid account must not let an attacker keep guessing the passwords of others.
+func (g *Guard) Succeed(ctx context.Context, username string) error {
+	return g.redis.Del(ctx, failuresKey("user", username), lockKey("user", username)).Err()
+}
+
+func (g *Guard) fail(ctx context.Context, kind, value string, limit int) (time.Duration, error) {
+	lock, err := failScript.Run(ctx, g.redis,
+		[]string{failuresKey(kind, value), lockKey(kind, value)},
+		g.limits.Window.Milliseconds(), limit, g.limits.BaseLockout.Milliseconds(), g.limits.MaxLockout.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(lock) * time.Millisecond, nil
+}
+
+// Usernames come straight from the request, hashing them keeps keys short and free of odd characters
+func failuresKey(kind, value string) string {
+	return "login_failures:" + kind + ":" + digest(value)
+}
+
+func lockKey(kind, value string) string {
+	return "login_lock:" + kind + ":" + digest(value)
+}
+
+func digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
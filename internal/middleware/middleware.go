@@ -2,11 +2,15 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
 	limiter "github.com/ruziba3vich/soand/internal/rate_limiter"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,21 +19,39 @@ import (
 // AuthHandler holds dependencies for authentication
 type AuthHandler struct {
 	userRepo repos.UserRepo
+	apiKeys  repos.IAPIKeyService
 	logger   *log.Logger
 	limiter  *limiter.TokenBucketLimiter
 }
 
 // NewAuthHandler initializes and returns an AuthHandler instance
-func NewAuthHandler(userRepo repos.UserRepo, logger *log.Logger, limiter *limiter.TokenBucketLimiter) *AuthHandler {
+func NewAuthHandler(userRepo repos.UserRepo, apiKeys repos.IAPIKeyService, logger *log.Logger, limiter *limiter.TokenBucketLimiter) *AuthHandler {
 	return &AuthHandler{
 		userRepo: userRepo,
+		apiKeys:  apiKeys,
 		logger:   logger,
 		limiter:  limiter,
 	}
 }
 
-// AuthMiddleware validates JWT and sets user ID before executing the given handlers
+// AuthMiddleware validates JWT and sets user and session IDs before executing the given handlers.
+// API keys are refused, the endpoints that take them are wrapped with RequireScope instead.
 func (a *AuthHandler) AuthMiddleware() func(gin.HandlerFunc) gin.HandlerFunc {
+	return a.bearerAuth()
+}
+
+// RequireScope authenticates the request like AuthMiddleware, and also accepts API keys that were granted scope
+func (a *AuthHandler) RequireScope(scope string) func(gin.HandlerFunc) gin.HandlerFunc {
+	return a.bearerAuth(scope)
+}
+
+// RequireAnyScope is RequireScope for endpoints that serve several scopes, API keys need one of them
+func (a *AuthHandler) RequireAnyScope(scopes ...string) func(gin.HandlerFunc) gin.HandlerFunc {
+	return a.bearerAuth(scopes...)
+}
+
+// bearerAuth authenticates "Authorization: Bearer <token>" requests, see authenticate
+func (a *AuthHandler) bearerAuth(scopes ...string) func(gin.HandlerFunc) gin.HandlerFunc {
 	return func(handler gin.HandlerFunc) gin.HandlerFunc {
 		return func(c *gin.Context) {
 			ip := c.ClientIP() // Get user IP for rate limiting
@@ -58,17 +80,16 @@ func (a *AuthHandler) AuthMiddleware() func(gin.HandlerFunc) gin.HandlerFunc {
 			}
 
 			parts := strings.Split(tokenString, " ")
-
-			userID, err := a.userRepo.ValidateJWT(parts[1])
-			if err != nil {
-				a.logger.Println("Invalid token:", err)
+			if len(parts) != 2 {
+				a.logger.Println("Malformed authorization header")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 				c.Abort()
 				return
 			}
 
-			// Set user ID in context
-			c.Set("userID", userID)
+			if !a.authenticate(c, parts[1], scopes) {
+				return
+			}
 
 			// Call the actual handler
 			handler(c)
@@ -76,7 +97,45 @@ func (a *AuthHandler) AuthMiddleware() func(gin.HandlerFunc) gin.HandlerFunc {
 	}
 }
 
+// RateLimitMiddleware limits requests per IP without requiring a token, for the public endpoints
+// that check passwords or codes
+func (a *AuthHandler) RateLimitMiddleware() func(gin.HandlerFunc) gin.HandlerFunc {
+	return func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			ip := c.ClientIP()
+
+			allowed, err := a.limiter.AllowRequest(c, ip)
+			if err != nil {
+				a.logger.Println("Rate limiter error:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				c.Abort()
+				return
+			}
+
+			if !allowed {
+				a.logger.Println("Rate limit exceeded for IP:", ip)
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+				c.Abort()
+				return
+			}
+
+			handler(c)
+		}
+	}
+}
+
+// WebSocketAuthMiddleware authenticates WebSocket upgrades, which carry the bare token in the Authorization header.
+// Like AuthMiddleware it refuses API keys.
 func (a *AuthHandler) WebSocketAuthMiddleware() func(gin.HandlerFunc) gin.HandlerFunc {
+	return a.webSocketAuth()
+}
+
+// WebSocketRequireScope authenticates like WebSocketAuthMiddleware, and also accepts API keys that were granted scope
+func (a *AuthHandler) WebSocketRequireScope(scope string) func(gin.HandlerFunc) gin.HandlerFunc {
+	return a.webSocketAuth(scope)
+}
+
+func (a *AuthHandler) webSocketAuth(scopes ...string) func(gin.HandlerFunc) gin.HandlerFunc {
 	return func(handler gin.HandlerFunc) gin.HandlerFunc {
 		return func(c *gin.Context) {
 			ctx := context.Background()
@@ -114,23 +173,95 @@ func (a *AuthHandler) WebSocketAuthMiddleware() func(gin.HandlerFunc) gin.Handle
 				return
 			}
 
-			userID, err := a.userRepo.ValidateJWT(token)
-			if err != nil {
-				a.logger.Println("Invalid WebSocket token:", err)
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-				c.Abort()
+			if !a.authenticate(c, token, scopes) {
 				return
 			}
 
-			// Set user ID in context
-			c.Set("userID", userID)
-
 			// Call the actual handler
 			handler(c)
 		}
 	}
 }
 
+// authenticate validates a JWT or, on endpoints that declare scopes, an API key granted one of them,
+// and sets the user ID and role in the context. A JWT also sets the session ID, an API key its key ID
+// and scopes.
+// It answers and aborts the request and returns false if the token is not accepted.
+func (a *AuthHandler) authenticate(c *gin.Context, token string, scopes []string) bool {
+	if strings.HasPrefix(token, models.APIKeyPrefix) {
+		return a.authenticateAPIKey(c, token, scopes)
+	}
+
+	claims, err := a.userRepo.ValidateJWT(token)
+	if err != nil {
+		a.logger.Println("Invalid token:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return false
+	}
+
+	// Set user and session IDs in context
+	c.Set("userID", claims.UserID)
+	c.Set("sessionID", claims.SessionID)
+	c.Set("role", claims.Role)
+	return true
+}
+
+func (a *AuthHandler) authenticateAPIKey(c *gin.Context, token string, scopes []string) bool {
+	if len(scopes) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used for this endpoint"})
+		c.Abort()
+		return false
+	}
+
+	key, role, err := a.apiKeys.Authenticate(c.Request.Context(), token)
+	if errors.Is(err, dto.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return false
+	}
+	if err != nil {
+		a.logger.Println("API key authentication error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		c.Abort()
+		return false
+	}
+
+	if !slices.ContainsFunc(scopes, key.HasScope) {
+		scope := strings.Join(scopes, " or ")
+		a.logger.Printf("API key %s without scope %s denied access to %s\n", key.ID.Hex(), scope, c.FullPath())
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing the " + scope + " scope"})
+		c.Abort()
+		return false
+	}
+
+	c.Set("userID", key.UserID.Hex())
+	c.Set("apiKeyID", key.ID.Hex())
+	c.Set("apiKeyScopes", key.Scopes)
+	c.Set("role", role)
+	return true
+}
+
+// RequireRole authenticates the request like AuthMiddleware and lets it through only if the
+// user has at least the given role, e.g. RequireRole(models.RoleModerator) also admits admins
+func (a *AuthHandler) RequireRole(role string) func(gin.HandlerFunc) gin.HandlerFunc {
+	authMiddleware := a.AuthMiddleware()
+
+	return func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return authMiddleware(func(c *gin.Context) {
+			userRole, _ := c.Get("role")
+			if current, _ := userRole.(string); !models.HasRole(current, role) {
+				a.logger.Printf("User %v with role %v denied access to %s\n", c.Value("userID"), userRole, c.FullPath())
+				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+				c.Abort()
+				return
+			}
+
+			handler(c)
+		})
+	}
+}
+
 // CommentsMiddleware validates JWT and sets user ID before executing the given handlers
 func (a *AuthHandler) CommentsMiddleware() func(gin.HandlerFunc) gin.HandlerFunc {
 	return func(handler gin.HandlerFunc) gin.HandlerFunc {
@@ -161,7 +292,14 @@ func (a *AuthHandler) CommentsMiddleware() func(gin.HandlerFunc) gin.HandlerFunc
 
 			// If token exists, validate it (optional for setting user ID)
 			parts := strings.Split(tokenString, " ")
-			userID, err := a.userRepo.ValidateJWT(parts[1])
+			if len(parts) != 2 {
+				a.logger.Println("Malformed authorization header")
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+
+			claims, err := a.userRepo.ValidateJWT(parts[1])
 			if err != nil {
 				a.logger.Println("Invalid token:", err)
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -169,7 +307,9 @@ func (a *AuthHandler) CommentsMiddleware() func(gin.HandlerFunc) gin.HandlerFunc
 				return
 			}
 
-			c.Set("userID", userID)
+			c.Set("userID", claims.UserID)
+			c.Set("sessionID", claims.SessionID)
+			c.Set("role", claims.Role)
 			handler(c)
 		}
 	}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs
+const APIKeyPrefix = "sk_"
+
+// Scopes an API key can be granted. Endpoints that declare none of them do not accept API keys at all.
+const (
+	ScopePostsWrite    = "posts:write"    // create, update, delete and like posts
+	ScopeCommentsWrite = "comments:write" // write, react to, update and delete comments
+	ScopeChatRead      = "chat:read"      // read direct messages, also live over the chat WebSocket
+	ScopeChatWrite     = "chat:write"     // send, update and delete direct messages
+)
+
+// Scopes lists every scope, in the order they are documented
+var Scopes = []string{ScopePostsWrite, ScopeCommentsWrite, ScopeChatRead, ScopeChatWrite}
+
+// IsValidScope reports whether scope is one of Scopes
+func IsValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// APIKey lets bots and integrations act as the user who created it, limited to its scopes.
+// Only a hash of the key is stored, the key itself is shown once when it is created.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name       string             `bson:"name" json:"name"`
+	Hint       string             `bson:"hint" json:"hint"` // the start of the key, to recognize it by
+	Hash       string             `bson:"hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// Expired reports whether the key has expired
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// NewAPIKey is returned once, when a key is created
+type NewAPIKey struct {
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"api_key"`
+}
+
+// APIKeyRequest creates an API key
+type APIKeyRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 for a key that does not expire
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Block is one user blocking another. Blocked users cannot message, follow or see the blocker,
+// and comments between the two are hidden from each other.
+type Block struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BlockerID primitive.ObjectID `bson:"blocker_id" json:"blocker_id"`
+	BlockedID primitive.ObjectID `bson:"blocked_id" json:"blocked_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ActionBlocksChanged is published on the NotificationChannel of both users when one blocks or
+// unblocks the other
+const ActionBlocksChanged = "blocks_changed"
@@ -16,6 +16,7 @@ type Comment struct {
 	ReplyTo         primitive.ObjectID              `json:"reply_to,omitempty" bson:"reply_to"`
 	OwnerFullname   string                          `bson:"owner_full_name" json:"owner_full_name"`
 	OwnerProfilePic string                          `bson:"owner_profile_pic" json:"owner_profile_pic"`
+	OwnerVerified   bool                            `bson:"owner_verified" json:"owner_verified"`
 	CreatedAt       time.Time                       `json:"created_at" bson:"created_at"`
 	Reactions       map[string][]primitive.ObjectID `json:"reactions" bson:"reactions"`
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of a draft. Published drafts are deleted, the post takes their place.
+const (
+	DraftUnscheduled = "draft"     // only published when the creator says so
+	DraftScheduled   = "scheduled" // published at PublishAt
+	DraftFailed      = "failed"    // scheduled but could not be published, see Error
+)
+
+// Draft is a post that is not published yet. Only its creator sees it, and its lifetime only
+// starts counting once it is published.
+type Draft struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"` // the post keeps this ID once published
+	CreatorId   primitive.ObjectID `bson:"creator_id" json:"creator_id"`
+	Title       string             `bson:"title" json:"title"`
+	Description string             `bson:"description" json:"description"`
+	Tags        []string           `bson:"tags" json:"tags"`
+	Pictures    []string           `bson:"pictures" json:"pictures"`
+	DeleteAfter int                `bson:"delete_after,omitempty" json:"delete_after,omitempty"` // hours, the creator's default post lifetime when 0
+	Status      string             `bson:"status" json:"status"`
+	PublishAt   *time.Time         `bson:"publish_at,omitempty" json:"publish_at,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"` // why the last publication attempt failed
+	Attempts    int                `bson:"attempts,omitempty" json:"-"`
+	LeaseUntil  *time.Time         `bson:"lease_until,omitempty" json:"-"` // held while it is being published
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ToPost is the post the draft is published as
+func (d *Draft) ToPost() *Post {
+	return &Post{
+		ID:          d.ID,
+		CreatorId:   d.CreatorId,
+		Title:       d.Title,
+		Description: d.Description,
+		Tags:        slices.Clone(d.Tags),
+		Pictures:    slices.Clone(d.Pictures),
+	}
+}
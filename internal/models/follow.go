@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Follow statuses. Following a private account creates a pending request that its owner accepts.
+const (
+	FollowAccepted = "accepted"
+	FollowPending  = "pending"
+)
+
+// Follow is one user following another
+type Follow struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FollowerID primitive.ObjectID `bson:"follower_id" json:"follower_id"`
+	FolloweeID primitive.ObjectID `bson:"followee_id" json:"followee_id"`
+	Status     string             `bson:"status" json:"status"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	AcceptedAt *time.Time         `bson:"accepted_at,omitempty" json:"accepted_at,omitempty"`
+}
+
+// UserSummary is the short public form of a user shown in lists
+type UserSummary struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Fullname   string             `bson:"full_name" json:"full_name"`
+	Username   *string            `bson:"username" json:"username"`
+	ProfilePic string             `bson:"profile_pic,omitempty" json:"profile_pic,omitempty"`
+	Verified   bool               `bson:"verified,omitempty" json:"verified"`
+	Since      time.Time          `bson:"since,omitempty" json:"since,omitzero"` // when the follow, request or block was made, unset in search results
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job types
+const (
+	JobAccountDeletion = "account_deletion"
+	JobAccountExport   = "account_export"
+)
+
+// Job statuses
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job is a unit of background work owned by a user, e.g. deleting their account.
+// Workers claim jobs with a lease, so a job whose worker died is picked up again.
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type        string             `bson:"type" json:"type"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"-"`
+	Status      string             `bson:"status" json:"status"`
+	Step        string             `bson:"step" json:"step"`
+	StepsDone   int                `bson:"steps_done" json:"steps_done"`
+	StepsTotal  int                `bson:"steps_total" json:"steps_total"`
+	Result      string             `bson:"result,omitempty" json:"result,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	Attempts    int                `bson:"attempts" json:"-"`
+	RunAfter    time.Time          `bson:"run_after" json:"-"`
+	LeaseUntil  time.Time          `bson:"lease_until,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	DownloadURL string             `bson:"-" json:"download_url,omitempty"` // time-limited link to the result of a finished export
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons a login failed
+const (
+	LoginFailedCredentials = "invalid_credentials"
+	LoginFailedLockedOut   = "locked_out"
+)
+
+// LoginAudit records a failed login. The username is what was submitted, it may not belong to any user.
+type LoginAudit struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username  string             `bson:"username" json:"username"`
+	IP        string             `bson:"ip" json:"ip"`
+	UserAgent string             `bson:"user_agent" json:"user_agent"`
+	Reason    string             `bson:"reason" json:"reason"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
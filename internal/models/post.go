@@ -7,16 +7,58 @@ import (
 )
 
 type Post struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`                // MongoDB ObjectID
-	CreatorId       primitive.ObjectID `bson:"creator_id,omitempty" json:"creator_id"` // Creator id
-	Pictures        []string           `bson:"pictures" json:"picture"`                // Image URLs or file path
-	Tags            []string           `bson:"tags" json:"tags"`                       // List of tags
-	Description     string             `bson:"description" json:"description"`         // Post description
-	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`           // Timestamp
-	DeleteAt        time.Time          `bson:"delete_at" json:"delete_at"`             // Field for automatic deletion
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`                       // MongoDB ObjectID
+	CreatorId       primitive.ObjectID `bson:"creator_id,omitempty" json:"creator_id"`        // Creator id
+	Pictures        []string           `bson:"pictures" json:"picture"`                       // Image URLs or file path
+	Tags            []string           `bson:"tags" json:"tags"`                              // List of tags
+	Description     string             `bson:"description" json:"description"`                // Post description
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`                  // Timestamp
+	DeleteAt        time.Time          `bson:"delete_at,omitempty" json:"delete_at,omitzero"` // Field for automatic deletion, unset for permanent posts
+	Permanent       bool               `bson:"permanent,omitempty" json:"permanent"`
+	ExpiryNotified  bool               `bson:"expiry_notified,omitempty" json:"-"` // the expiry warning went out for the current delete_at
 	OwnerFullname   string             `bson:"owner_full_name" json:"owner_full_name"`
 	OwnerProfilePic string             `bson:"owner_profile_pic" json:"owner_profile_pic"`
+	OwnerVerified   bool               `bson:"owner_verified" json:"owner_verified"`
 	Title           string             `bson:"title" json:"title"`
 	Likes           int                `bson:"likes" json:"likes"`
 	Reactions       map[string]int     `bson:"reactions" json:"reactions"`
+	Version         int                `bson:"version" json:"version"`                         // goes up with every edit, see PostRevision
+	EditedAt        *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"` // last edit
+}
+
+// PostRevision is the content a post had at one version, kept when the post is edited
+type PostRevision struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostID      primitive.ObjectID `bson:"post_id" json:"post_id"`
+	CreatorID   primitive.ObjectID `bson:"creator_id" json:"-"`
+	Version     int                `bson:"version" json:"version"`
+	Title       string             `bson:"title" json:"title"`
+	Description string             `bson:"description" json:"description"`
+	Tags        []string           `bson:"tags" json:"tags"`
+	Pictures    []string           `bson:"pictures" json:"pictures"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`   // when the post got this content
+	ReplacedAt  time.Time          `bson:"replaced_at" json:"replaced_at"` // when it was edited away
+}
+
+// PostLifetime is when a post expires, as changed by its creator. It is also what subscribers of
+// the post's comments receive, with the "lifetime" action, when it changes.
+type PostLifetime struct {
+	PostID    primitive.ObjectID `json:"post_id"`
+	DeleteAt  *time.Time         `json:"delete_at"` // nil for permanent posts
+	Permanent bool               `json:"permanent"`
+}
+
+// ArchivedPost is an expired post with its comment thread, kept when the post expiry sweeper
+// archives posts before deleting them. The pictures and comment media stay in the bucket under
+// their names until the archive is deleted with the account of the post's creator.
+type ArchivedPost struct {
+	Post       *Post      `json:"post"`
+	Comments   []*Comment `json:"comments"`
+	ArchivedAt time.Time  `json:"archived_at"`
+}
+
+// NotificationChannel is the Redis channel events meant for the user alone are published on,
+// e.g. that a post of theirs is about to expire
+func NotificationChannel(userID primitive.ObjectID) string {
+	return "notifications:" + userID.Hex()
 }
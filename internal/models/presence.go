@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Presence tells whether a user is online and when they were last seen. Users who hide
+// their last seen, and users blocked either way, are returned with Hidden set and nothing else.
+type Presence struct {
+	UserID   primitive.ObjectID `json:"user_id"`
+	Online   bool               `json:"online"`
+	LastSeen *time.Time         `json:"last_seen,omitempty"`
+	Hidden   bool               `json:"hidden,omitempty"`
+}
+
+// PresenceEvent is pushed to the open direct message chats with a user when they come online or go offline
+type PresenceEvent struct {
+	Type     string             `json:"type"` // always "presence"
+	UserID   primitive.ObjectID `json:"user_id"`
+	Online   bool               `json:"online"`
+	LastSeen *time.Time         `json:"last_seen,omitempty"`
+}
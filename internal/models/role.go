@@ -0,0 +1,29 @@
+package models
+
+// Roles, each one can do everything the ones before it can
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether a user with role may act as required. Accounts created
+// before roles existed have none and count as regular users.
+func HasRole(role, required string) bool {
+	if role == "" {
+		role = RoleUser
+	}
+	return roleRanks[role] >= roleRanks[required]
+}
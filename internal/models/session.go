@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a single login of a user. Every refresh token belongs to exactly one session
+// and access tokens carry the session id so a revoked session stops working immediately.
+type Session struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RefreshTokenHash string             `bson:"refresh_token_hash" json:"-"`
+	DeviceName       string             `bson:"device_name" json:"device_name"`
+	UserAgent        string             `bson:"user_agent" json:"user_agent"`
+	IP               string             `bson:"ip" json:"ip"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	LastSeenAt       time.Time          `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt        *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	Current          bool               `bson:"-" json:"current"` // set when listing, true for the requesting session
+}
+
+// SessionMeta describes the device a session is opened or refreshed from
+type SessionMeta struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+// AuthTokens is returned to the client after a successful login, registration or refresh
+type AuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
+}
+
+// TokenClaims holds the identity extracted from a validated access token
+type TokenClaims struct {
+	UserID    string
+	SessionID string
+	Role      string
+}
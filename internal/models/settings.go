@@ -0,0 +1,131 @@
+package models
+
+// Audiences decide who may do something with a user: message them or see their profile pictures
+const (
+	AudienceEveryone  = "everyone"
+	AudienceFollowers = "followers" // accepted followers only
+	AudienceNobody    = "nobody"
+)
+
+// DefaultPostTTL is how many hours a post lives when neither the request nor the settings say otherwise
+const DefaultPostTTL = 24
+
+// MaxDefaultPostTTL caps the default post lifetime users can choose, 30 days
+const MaxDefaultPostTTL = 30 * 24
+
+// UserSettings holds the privacy and notification preferences of a user
+type UserSettings struct {
+	DirectMessages  string               `json:"direct_messages" bson:"direct_messages"`   // who can message the user
+	ProfilePictures string               `json:"profile_pictures" bson:"profile_pictures"` // who can see the user's profile pictures
+	HideLastSeen    bool                 `json:"hide_last_seen" bson:"hide_last_seen"`     // hides online status and last seen from others
+	DefaultPostTTL  int                  `json:"default_post_ttl" bson:"default_post_ttl"` // hours, used for posts created without delete_after
+	Notifications   NotificationSettings `json:"notifications" bson:"notifications"`
+}
+
+// Notifications, published on the user's NotificationChannel with the kind as their action. Each
+// one can be turned off in NotificationSettings.
+const (
+	NotifyDirectMessage = "direct_message"
+	NotifyComment       = "comment"
+	NotifyNewFollower   = "new_follower"
+	NotifyFollowRequest = "follow_request"
+	NotifyPostExpiring  = "expiring"
+	NotifyPostPublished = "published"
+)
+
+// NotificationSettings says which events the user wants to be notified about
+type NotificationSettings struct {
+	DirectMessages bool `json:"direct_messages" bson:"direct_messages"`
+	Comments       bool `json:"comments" bson:"comments"` // comments on the user's posts
+	NewFollowers   bool `json:"new_followers" bson:"new_followers"`
+	FollowRequests bool `json:"follow_requests" bson:"follow_requests"`
+	PostExpiring   bool `json:"post_expiring" bson:"post_expiring"`     // a post of the user is about to expire
+	ScheduledPosts bool `json:"scheduled_posts" bson:"scheduled_posts"` // a scheduled post of the user was published
+}
+
+// Allows reports whether the user wants notifications of the given kind
+func (n NotificationSettings) Allows(kind string) bool {
+	switch kind {
+	case NotifyDirectMessage:
+		return n.DirectMessages
+	case NotifyComment:
+		return n.Comments
+	case NotifyNewFollower:
+		return n.NewFollowers
+	case NotifyFollowRequest:
+		return n.FollowRequests
+	case NotifyPostExpiring:
+		return n.PostExpiring
+	case NotifyPostPublished:
+		return n.ScheduledPosts
+	}
+	return true
+}
+
+// DefaultSettings are the settings of users who never changed them
+func DefaultSettings() UserSettings {
+	return UserSettings{
+		DirectMessages:  AudienceEveryone,
+		ProfilePictures: AudienceEveryone,
+		DefaultPostTTL:  DefaultPostTTL,
+		Notifications: NotificationSettings{
+			DirectMessages: true,
+			Comments:       true,
+			NewFollowers:   true,
+			FollowRequests: true,
+			PostExpiring:   true,
+			ScheduledPosts: true,
+		},
+	}
+}
+
+// SettingsUpdate changes the settings it has fields for and leaves the rest alone
+type SettingsUpdate struct {
+	DirectMessages  *string                     `json:"direct_messages"`
+	ProfilePictures *string                     `json:"profile_pictures"`
+	HideLastSeen    *bool                       `json:"hide_last_seen"`
+	DefaultPostTTL  *int                        `json:"default_post_ttl"`
+	Notifications   *NotificationSettingsUpdate `json:"notifications"`
+}
+
+// NotificationSettingsUpdate changes the notification preferences it has fields for
+type NotificationSettingsUpdate struct {
+	DirectMessages *bool `json:"direct_messages"`
+	Comments       *bool `json:"comments"`
+	NewFollowers   *bool `json:"new_followers"`
+	FollowRequests *bool `json:"follow_requests"`
+	PostExpiring   *bool `json:"post_expiring"`
+	ScheduledPosts *bool `json:"scheduled_posts"`
+}
+
+// Apply copies the fields set in update into s
+func (s *UserSettings) Apply(update *SettingsUpdate) {
+	setIf(&s.DirectMessages, update.DirectMessages)
+	setIf(&s.ProfilePictures, update.ProfilePictures)
+	setIf(&s.HideLastSeen, update.HideLastSeen)
+	setIf(&s.DefaultPostTTL, update.DefaultPostTTL)
+
+	if n := update.Notifications; n != nil {
+		setIf(&s.Notifications.DirectMessages, n.DirectMessages)
+		setIf(&s.Notifications.Comments, n.Comments)
+		setIf(&s.Notifications.NewFollowers, n.NewFollowers)
+		setIf(&s.Notifications.FollowRequests, n.FollowRequests)
+		setIf(&s.Notifications.PostExpiring, n.PostExpiring)
+		setIf(&s.Notifications.ScheduledPosts, n.ScheduledPosts)
+	}
+}
+
+// IsValidAudience reports whether audience is one of the known audiences
+func IsValidAudience(audience string) bool {
+	switch audience {
+	case AudienceEveryone, AudienceFollowers, AudienceNobody:
+		return true
+	}
+	return false
+}
+
+func setIf[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// TwoFactor is the TOTP state embedded in the user document. Only Enabled is ever
+// sent to clients; the secrets and recovery code hashes never leave the server.
+type TwoFactor struct {
+	Enabled       bool      `json:"enabled" bson:"enabled"`
+	Secret        string    `json:"-" bson:"secret,omitempty"`
+	PendingSecret string    `json:"-" bson:"pending_secret,omitempty"`
+	RecoveryCodes []string  `json:"-" bson:"recovery_codes,omitempty"` // sha256 hashes
+	LastUsedStep  int64     `json:"-" bson:"last_used_step,omitempty"`
+	FailedCodes   int       `json:"-" bson:"failed_codes,omitempty"`
+	LockedUntil   time.Time `json:"-" bson:"locked_until,omitempty"`
+}
+
+// TwoFactorEnrollment is returned when the user starts enabling 2FA
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// LoginResult is the outcome of the password step of a login. Users without 2FA get their
+// tokens right away; users with 2FA get a challenge token to exchange together with a TOTP code.
+type LoginResult struct {
+	Tokens            *AuthTokens `json:"tokens,omitempty"`
+	TwoFactorRequired bool        `json:"two_factor_required"`
+	ChallengeToken    string      `json:"challenge_token,omitempty"`
+	ChallengeExpires  int64       `json:"challenge_expires_in,omitempty"`
+}
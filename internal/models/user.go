@@ -7,16 +7,86 @@ import (
 )
 
 type User struct {
-	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Fullname      string             `json:"full_name" bson:"full_name" binding:"required"`
-	Phone         string             `json:"phone" bson:"phone"`
-	Username      *string            `json:"username" bson:"username"`
-	Password      string             `json:"password" bson:"password" binding:"required"`
-	Bio           string             `json:"bio" bson:"bio"`
-	Status        string             `json:"status" bson:"status"`
-	ProfilePics   []ProfilePic       `json:"profile_pics" bson:"profile_pics"`
-	BackgroundPic string             `json:"background_pic" bson:"background_pic"`
-	HiddenProfile bool               `json:"profile_hidden" bson:"profile_hidden"`
+	ID              primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Fullname        string             `json:"full_name" bson:"full_name" binding:"required"`
+	Phone           string             `json:"phone" bson:"phone"`
+	PhoneVerified   bool               `json:"phone_verified" bson:"phone_verified"`
+	Username        *string            `json:"username" bson:"username"`
+	UsernameHistory []UsernameChange   `json:"username_history,omitempty" bson:"username_history,omitempty"` // only shown to the user themselves
+	RenamedFrom     string             `json:"renamed_from,omitempty" bson:"-"`                              // set when the user was found by a previous username
+	Password        string             `json:"-" bson:"password"`
+	Identities      []ExternalIdentity `json:"identities,omitempty" bson:"identities,omitempty"` // external accounts the user logs in with, only shown to the user themselves
+	Bio             string             `json:"bio" bson:"bio"`
+	Status          string             `json:"status" bson:"status"`
+	ProfilePics     []ProfilePic       `json:"profile_pics" bson:"profile_pics"`
+	BackgroundPic   string             `json:"background_pic" bson:"background_pic"`
+	HiddenProfile   bool               `json:"profile_hidden" bson:"profile_hidden"`
+	LastSeen        *time.Time         `json:"last_seen,omitempty" bson:"last_seen,omitempty"` // when the user's last connection closed
+	Settings        *UserSettings      `json:"-" bson:"settings,omitempty"`                    // nil until the user changes a setting, see GetSettings
+	Role            string             `json:"role" bson:"role"`
+	Verified        bool               `json:"verified" bson:"verified,omitempty"` // granted by a moderator, see VerificationRequest
+	TwoFactor       TwoFactor          `json:"two_factor" bson:"two_factor"`
+	DeletingAt      *time.Time         `json:"-" bson:"deleting_at,omitempty"`    // set once account deletion has been requested
+	DeactivatedAt   *time.Time         `json:"-" bson:"deactivated_at,omitempty"` // set while the account is deactivated
+	SearchGrams     []string           `json:"-" bson:"search_grams,omitempty"`   // see storage.searchGrams, kept in sync with username and full_name
+	Followers       int64              `json:"followers_count" bson:"-"`
+	Following       int64              `json:"following_count" bson:"-"`
+}
+
+// IsHidden reports whether the user's profile is hidden from others, either by choice
+// or because the account is deactivated or being deleted. Their posts and comments are
+// then shown anonymously.
+func (u *User) IsHidden() bool {
+	return u.HiddenProfile || u.DeactivatedAt != nil || u.DeletingAt != nil
+}
+
+// GetSettings returns the user's settings, the defaults if they never changed any
+func (u *User) GetSettings() UserSettings {
+	if u.Settings == nil {
+		return DefaultSettings()
+	}
+	return *u.Settings
+}
+
+// PublicProfilePic returns the current profile picture if everyone may see it, "" otherwise.
+// It is what posts, comments and user lists show next to the user's name.
+func (u *User) PublicProfilePic() string {
+	if len(u.ProfilePics) == 0 || u.GetSettings().ProfilePictures != AudienceEveryone {
+		return ""
+	}
+	return u.ProfilePics[0].Url
+}
+
+// UsernameChange records a username the user gave up
+type UsernameChange struct {
+	Username  string    `bson:"username" json:"username"`
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+// ExternalIdentity is an account at an OpenID Connect provider linked to the user
+type ExternalIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// ExternalLoginResult is the outcome of coming back from an OpenID Connect provider: either a
+// login, of a user that may have just been created, or an account linked to the signed-in user
+type ExternalLoginResult struct {
+	*LoginResult
+	NewUser bool              `json:"new_user,omitempty"`
+	Linked  *ExternalIdentity `json:"linked,omitempty"`
+}
+
+// HasIdentity reports whether the user has linked an account at provider
+func (u *User) HasIdentity(provider string) bool {
+	for _, identity := range u.Identities {
+		if identity.Provider == provider {
+			return true
+		}
+	}
+	return false
 }
 
 type ProfilePic struct {
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// States of a verification request
+const (
+	VerificationPending  = "pending"
+	VerificationApproved = "approved"
+	VerificationRejected = "rejected"
+)
+
+// VerificationRequest asks moderators for the verified badge, which marks the account as really
+// belonging to who it claims to be. At most one request per user is pending at a time.
+type VerificationRequest struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Note       string              `bson:"note" json:"note"` // who the user is and how to confirm it, e.g. links to official pages
+	Status     string              `bson:"status" json:"status"`
+	ReviewerID *primitive.ObjectID `bson:"reviewer_id,omitempty" json:"reviewer_id,omitempty"`
+	Reason     string              `bson:"reason,omitempty" json:"reason,omitempty"` // why the request was rejected
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+	ReviewedAt *time.Time          `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	User       *UserSummary        `bson:"-" json:"user,omitempty"` // the requesting user, in the moderation queue
+}
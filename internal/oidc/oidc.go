@@ -0,0 +1,315 @@
+// Package oidc signs users in with external OpenID Connect providers using the
+// authorization code flow with PKCE (RFC 7636). A provider's endpoints are
+// discovered from its issuer's /.well-known/openid-configuration and its signing
+// keys are fetched from its JWKS, both on first use; keys are fetched again when
+// an ID token names a key id that is not known yet.
+//
+// cmd/mockoidc is a provider to test the flow against locally.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/ruziba3vich/soand/internal/jwtkeys"
+)
+
+// keysRefreshInterval bounds how often an unknown key id makes the provider's JWKS be fetched again
+const keysRefreshInterval = time.Minute
+
+// Config describes a provider soand is registered with
+type Config struct {
+	Name         string // how the provider is called in URLs, e.g. "google"
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients, which rely on PKCE alone
+	RedirectURL  string // soand's callback URL registered with the provider
+	Scopes       []string
+}
+
+// Claims are the parts of a verified ID token soand uses
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider is an OpenID Connect provider
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+// metadata is the part of the discovery document soand needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider creates a Provider. Nothing is fetched until the provider is first used.
+func NewProvider(config Config, client *http.Client) *Provider {
+	return &Provider{config: config, client: client}
+}
+
+// Name returns the name of the provider
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL to send the user to. state and nonce are checked when the user
+// comes back, and verifier is the PKCE code verifier that has to be presented with the code.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the claims of the verified ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(request, &response)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s", strings.TrimSpace(response.Error+" "+response.ErrorDescription))
+	}
+	if status != http.StatusOK || response.IDToken == "" {
+		return nil, fmt.Errorf("token request failed with status %d", status)
+	}
+
+	return p.verify(ctx, meta, response.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID token
+func (p *Provider) verify(ctx context.Context, meta *metadata, rawToken, nonce string) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	_, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	if !claims.VerifyIssuer(meta.Issuer, true) {
+		return nil, errors.New("invalid ID token: wrong issuer")
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errors.New("invalid ID token: wrong audience")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("invalid ID token: no expiry")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("invalid ID token: wrong nonce")
+	}
+
+	result := &Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.EmailVerified, _ = claims["email_verified"].(bool)
+	result.Name, _ = claims["name"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	if result.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+
+	return result, nil
+}
+
+// discover fetches the provider's metadata once; a failed attempt is retried on the next call
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta metadata
+	status, err := p.do(request, &meta)
+	if err != nil {
+		return nil, fmt.Errorf("discovery of %s failed: %v", p.config.Name, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("discovery of %s failed with status %d", p.config.Name, status)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery of %s returned issuer %q", p.config.Name, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery of %s returned incomplete metadata", p.config.Name)
+	}
+
+	p.metadata = &meta
+	return p.metadata, nil
+}
+
+// key returns the provider's RSA key with the given id, fetching the JWKS again if it is unknown
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jwtkeys.JWKS
+	status, err := p.do(request, &set)
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys failed: %v", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching keys failed with status %d", status)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		if key, err := parseRSAKey(jwk); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// do sends the request and decodes the JSON response into v, whatever its status
+func (p *Provider) do(request *http.Request, v any) (int, error) {
+	response, err := p.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && response.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("invalid response: %v", err)
+	}
+
+	return response.StatusCode, nil
+}
+
+func parseRSAKey(jwk jwtkeys.JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// RandomToken returns a random URL-safe string, for states, nonces and PKCE code verifiers
+func RandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrStateNotFound is returned for a state that was never issued, has expired or was used already
+var ErrStateNotFound = errors.New("unknown or expired state")
+
+// Pending is a login started at a provider, kept until the user comes back with the code
+type Pending struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Binding  string `json:"binding"`           // also kept in a cookie of the browser that started, so no other browser can finish
+	LinkTo   string `json:"link_to,omitempty"` // the user ID when an account is being linked instead of logged in with
+}
+
+// StateStore keeps pending logins in Redis under their state
+type StateStore struct {
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+// NewStateStore creates a StateStore. Logins not finished within ttl are forgotten.
+func NewStateStore(redis *redis.Client, ttl time.Duration) *StateStore {
+	return &StateStore{redis: redis, ttl: ttl}
+}
+
+// Save stores a pending login under state
+func (s *StateStore) Save(ctx context.Context, state string, pending *Pending) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Set(ctx, stateKey(state), data, s.ttl).Err()
+}
+
+// Take returns the pending login stored under state and removes it, so every state is used once
+func (s *StateStore) Take(ctx context.Context, state string) (*Pending, error) {
+	data, err := s.redis.GetDel(ctx, stateKey(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pending Pending
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+func stateKey(state string) string {
+	return "oidc:state:" + state
+}
@@ -0,0 +1,161 @@
+// Package otp issues and verifies short numeric one-time codes delivered by SMS.
+// Codes are kept in Redis as hashes with a TTL, a limited number of attempts and
+// a resend cooldown per purpose and target.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/repos"
+)
+
+// Purposes keep codes for different flows apart, so a phone verification code
+// can never be used to reset a password
+const (
+	PurposePhoneVerification = "phone_verification"
+	PurposePasswordReset     = "password_reset"
+)
+
+const codeDigits = 6
+
+// Service issues and verifies one-time codes
+type Service struct {
+	redis       *redis.Client
+	sender      repos.SMSSender
+	ttl         time.Duration
+	maxAttempts int
+	cooldown    time.Duration
+}
+
+// NewService creates an OTP service. Codes live for ttl, allow maxAttempts wrong guesses,
+// and a new code for the same purpose and target can be requested once per cooldown.
+func NewService(redis *redis.Client, sender repos.SMSSender, ttl time.Duration, maxAttempts int, cooldown time.Duration) *Service {
+	return &Service{
+		redis:       redis,
+		sender:      sender,
+		ttl:         ttl,
+		maxAttempts: maxAttempts,
+		cooldown:    cooldown,
+	}
+}
+
+// Send generates a code for purpose and target, stores it with payload and texts it to phone.
+// The payload is handed back by Verify, e.g. the phone number that is being verified.
+func (s *Service) Send(ctx context.Context, purpose, target, phone, payload string) error {
+	if err := s.Throttle(ctx, purpose, target); err != nil {
+		return err
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+
+	key := codeKey(purpose, target)
+	pipe := s.redis.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, map[string]any{
+		"hash":     hashCode(purpose, target, code),
+		"attempts": 0,
+		"payload":  payload,
+	})
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store code: %v", err)
+	}
+
+	message := fmt.Sprintf("Your soand code is %s. It expires in %d minutes. Do not share it with anyone.", code, int(s.ttl.Minutes()))
+	if err := s.sender.Send(ctx, phone, message); err != nil {
+		s.redis.Del(ctx, key)
+		return fmt.Errorf("failed to send code: %v", err)
+	}
+
+	return nil
+}
+
+// Throttle starts the resend cooldown for purpose and target, failing with dto.ErrResendTooEarly
+// if it is already running. Send calls it; flows that must not reveal whether anything was
+// sent call it on their own so both paths are rate-limited the same way.
+func (s *Service) Throttle(ctx context.Context, purpose, target string) error {
+	ok, err := s.redis.SetNX(ctx, cooldownKey(purpose, target), 1, s.cooldown).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dto.ErrResendTooEarly
+	}
+	return nil
+}
+
+// Verify checks code for purpose and target and returns the payload it was sent with.
+// A code can be used once; it is also dropped after too many wrong guesses.
+func (s *Service) Verify(ctx context.Context, purpose, target, code string) (string, error) {
+	key := codeKey(purpose, target)
+
+	attempts, err := s.redis.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := s.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+
+	// HIncrBy creates the hash when the code does not exist, so a missing hash field means no code
+	if data["hash"] == "" {
+		s.redis.Del(ctx, key)
+		return "", dto.ErrCodeExpired
+	}
+
+	if attempts > int64(s.maxAttempts) {
+		s.redis.Del(ctx, key)
+		return "", dto.ErrTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(purpose, target, code)), []byte(data["hash"])) != 1 {
+		return "", dto.ErrInvalidCode
+	}
+
+	// Only one of two concurrent correct guesses gets to use the code
+	deleted, err := s.redis.Del(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	if deleted == 0 {
+		return "", dto.ErrCodeExpired
+	}
+
+	return data["payload"], nil
+}
+
+func generateCode() (string, error) {
+	max := big.NewInt(1_000_000) // 10^codeDigits
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %v", err)
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n.Int64()), nil
+}
+
+func hashCode(purpose, target, code string) string {
+	sum := sha256.Sum256([]byte(purpose + ":" + target + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func codeKey(purpose, target string) string {
+	return fmt.Sprintf("otp:%s:%s", purpose, target)
+}
+
+func cooldownKey(purpose, target string) string {
+	return fmt.Sprintf("otp_cooldown:%s:%s", purpose, target)
+}
@@ -0,0 +1,118 @@
+// Package passwords hashes and verifies user passwords and enforces the password policy.
+// New hashes are Argon2id in the PHC string format, e.g.
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// so the algorithm and its parameters travel with every hash. Older bcrypt hashes still
+// verify and are reported as needing a rehash.
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// Params are the Argon2id cost parameters
+type Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Hasher makes Argon2id hashes with fixed parameters
+type Hasher struct {
+	params Params
+}
+
+// NewHasher creates a Hasher that hashes with params
+func NewHasher(params Params) *Hasher {
+	return &Hasher{params: params}
+}
+
+// Hash hashes password with a random salt and returns it as a PHC string
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, keyLength)
+	return encode(h.params, salt, key), nil
+}
+
+// NeedsRehash reports whether encoded was made with another algorithm or other parameters than
+// the hasher's, and should be replaced by a fresh hash the next time the password is known
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := decode(encoded)
+	if err != nil {
+		return true
+	}
+	return params != h.params || len(key) != keyLength
+}
+
+// Verify checks password against a hash made by any supported algorithm
+func Verify(encoded, password string) bool {
+	if strings.HasPrefix(encoded, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+
+	params, salt, key, err := decode(encoded)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func encode(params Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// decode parses an Argon2id PHC string
+func decode(encoded string) (params Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Params{}, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, errInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Params{}, nil, nil, errInvalidHash
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Params{}, nil, nil, errInvalidHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return Params{}, nil, nil, errInvalidHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return Params{}, nil, nil, errInvalidHash
+	}
+
+	return params, salt, key, nil
+}
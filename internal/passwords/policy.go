@@ -0,0 +1,47 @@
+package passwords
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+)
+
+// common.txt lists passwords that show up at the top of breach dumps, one per line, lowercase.
+// It is bundled so the check works offline and never sends passwords anywhere.
+//
+//go:embed common.txt
+var commonList string
+
+var common = func() map[string]bool {
+	set := map[string]bool{}
+	for _, line := range strings.Split(commonList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			set[line] = true
+		}
+	}
+	return set
+}()
+
+// Policy decides which passwords users may choose
+type Policy struct {
+	MinLength int // in characters
+	MaxLength int // in characters
+}
+
+// Check returns why password is not allowed, or nil if it is
+func (p Policy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", dto.ErrPasswordTooShort, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w, use at most %d characters", dto.ErrPasswordTooLong, p.MaxLength)
+	}
+	if common[strings.ToLower(password)] {
+		return dto.ErrPasswordTooCommon
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Package presence tracks which users are connected over WebSocket. Every connection
+// is a field of the hash presence:<user id> holding the time it expires at; connections
+// are refreshed by heartbeats, so a connection of a crashed server simply runs out.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// updateScript drops expired connections, then sets (ARGV[4] = "1") or removes the connection
+// ARGV[3] and returns how many live connections the user had before and has after.
+var updateScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local fields = redis.call('HGETALL', KEYS[1])
+local before = 0
+for i = 1, #fields, 2 do
+	if tonumber(fields[i + 1]) > now then
+		before = before + 1
+	else
+		redis.call('HDEL', KEYS[1], fields[i])
+	end
+end
+
+if ARGV[4] == '1' then
+	redis.call('HSET', KEYS[1], ARGV[3], ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+else
+	redis.call('HDEL', KEYS[1], ARGV[3])
+end
+
+return {before, redis.call('HLEN', KEYS[1])}
+`)
+
+// Tracker keeps the connections of users in Redis
+type Tracker struct {
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+// NewTracker creates a Tracker. A connection counts as live for ttl after its last heartbeat.
+func NewTracker(redis *redis.Client, ttl time.Duration) *Tracker {
+	return &Tracker{redis: redis, ttl: ttl}
+}
+
+// Connect registers a new connection of the user and reports whether the user just came online
+func (t *Tracker) Connect(ctx context.Context, userID primitive.ObjectID) (connID string, cameOnline bool, err error) {
+	connID = primitive.NewObjectID().Hex()
+	before, _, err := t.update(ctx, userID, connID, true)
+	if err != nil {
+		return "", false, err
+	}
+	return connID, before == 0, nil
+}
+
+// Heartbeat keeps the connection live for another ttl
+func (t *Tracker) Heartbeat(ctx context.Context, userID primitive.ObjectID, connID string) error {
+	_, _, err := t.update(ctx, userID, connID, true)
+	return err
+}
+
+// Disconnect removes the connection and reports whether it was the last one of the user
+func (t *Tracker) Disconnect(ctx context.Context, userID primitive.ObjectID, connID string) (wentOffline bool, err error) {
+	_, after, err := t.update(ctx, userID, connID, false)
+	if err != nil {
+		return false, err
+	}
+	return after == 0, nil
+}
+
+// Online reports which of the users have at least one live connection
+func (t *Tracker) Online(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	pipe := t.redis.Pipeline()
+	results := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		results[i] = pipe.HGetAll(ctx, key(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read presence: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	online := make(map[primitive.ObjectID]bool, len(userIDs))
+	for i, userID := range userIDs {
+		for _, expiresAt := range results[i].Val() {
+			if ms, err := strconv.ParseInt(expiresAt, 10, 64); err == nil && ms > now {
+				online[userID] = true
+				break
+			}
+		}
+	}
+
+	return online, nil
+}
+
+func (t *Tracker) update(ctx context.Context, userID primitive.ObjectID, connID string, live bool) (before, after int64, err error) {
+	now := time.Now()
+	set := "0"
+	if live {
+		set = "1"
+	}
+
+	result, err := updateScript.Run(ctx, t.redis, []string{key(userID)},
+		now.UnixMilli(), now.Add(t.ttl).UnixMilli(), connID, set, t.ttl.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update presence: %v", err)
+	}
+
+	return result[0], result[1], nil
+}
+
+// Channel is the Redis channel presence changes of the user are published on
+func Channel(userID primitive.ObjectID) string {
+	return "presence:events:" + userID.Hex()
+}
+
+func key(userID primitive.ObjectID) string {
+	return "presence:" + userID.Hex()
+}
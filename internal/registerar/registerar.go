@@ -8,13 +8,22 @@ import (
 	"github.com/redis/go-redis/v9"
 	_ "github.com/ruziba3vich/soand/docs"
 	handler "github.com/ruziba3vich/soand/internal/http"
+	"github.com/ruziba3vich/soand/internal/jwtkeys"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"github.com/ruziba3vich/soand/internal/service"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func RegisterUserRoutes(r *gin.Engine, userRepo repos.UserRepo, file_store repos.IFIleStoreService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+func RegisterUserRoutes(
+	r *gin.Engine,
+	userRepo repos.UserRepo,
+	file_store repos.IFIleStoreService,
+	logger *log.Logger,
+	authMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
+	optionalAuthMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
+	rateLimitMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
+) {
 	r.Use(CORSMiddleware())
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	userHandler := handler.NewUserHandler(userRepo, file_store, logger)
@@ -22,15 +31,32 @@ func RegisterUserRoutes(r *gin.Engine, userRepo repos.UserRepo, file_store repos
 
 	userRoutes := r.Group("/users")
 	{
-		userRoutes.POST("/", userHandler.CreateUser)
-		userRoutes.POST("/login", userHandler.LoginUser)
+		userRoutes.POST("/", rateLimitMiddleware(userHandler.CreateUser))
+		userRoutes.POST("/login", rateLimitMiddleware(userHandler.LoginUser))
+		userRoutes.POST("/login/2fa", rateLimitMiddleware(userHandler.LoginTwoFactor))
+		userRoutes.POST("/reactivate", rateLimitMiddleware(userHandler.ReactivateUser))
+		userRoutes.POST("/token/refresh", rateLimitMiddleware(userHandler.RefreshToken))
+		userRoutes.POST("/2fa/enroll", authMiddleware(userHandler.EnrollTwoFactor))
+		userRoutes.POST("/2fa/confirm", authMiddleware(userHandler.ConfirmTwoFactor))
+		userRoutes.POST("/2fa/disable", authMiddleware(userHandler.DisableTwoFactor))
+		userRoutes.POST("/phone/verify/request", authMiddleware(userHandler.RequestPhoneVerification))
+		userRoutes.POST("/phone/verify", authMiddleware(userHandler.ConfirmPhoneVerification))
+		userRoutes.POST("/password/reset/request", rateLimitMiddleware(userHandler.RequestPasswordReset))
+		userRoutes.POST("/password/reset", rateLimitMiddleware(userHandler.ResetPassword))
+		userRoutes.POST("/logout", authMiddleware(userHandler.Logout))
+		userRoutes.POST("/logout/all", authMiddleware(userHandler.LogoutAll))
+		userRoutes.GET("/sessions", authMiddleware(userHandler.GetSessions))
+		userRoutes.DELETE("/sessions", authMiddleware(userHandler.RevokeOtherSessions))
+		userRoutes.DELETE("/sessions/:id", authMiddleware(userHandler.RevokeSession))
 		userRoutes.POST("profile/pic", authMiddleware(userHandler.AddProfilePicture))
 		userRoutes.DELETE("profile/pic", authMiddleware(userHandler.DeleteProfilePicture))
-		userRoutes.DELETE("/:id", authMiddleware(userHandler.DeleteUser))
 		userRoutes.GET("/me", authMiddleware(userHandler.GetUserMe))
-		userRoutes.GET("/:id", userHandler.GetUserByID)
-		userRoutes.GET("/username/:username", userHandler.GetUserByUsername)
-		userRoutes.GET("profile/pic", userHandler.GetProfilePictures)
+		userRoutes.GET("/me/settings", authMiddleware(userHandler.GetSettings))
+		userRoutes.PATCH("/me/settings", authMiddleware(userHandler.UpdateSettings))
+		userRoutes.GET("/search", optionalAuthMiddleware(userHandler.SearchUsers))
+		userRoutes.GET("/:id", optionalAuthMiddleware(userHandler.GetUserByID))
+		userRoutes.GET("/username/:username", optionalAuthMiddleware(userHandler.GetUserByUsername))
+		userRoutes.GET("profile/pic", optionalAuthMiddleware(userHandler.GetProfilePictures))
 		// userRoutes.PATCH("/fullname", authMiddleware(userHandler.UpdateFullname))
 		userRoutes.PUT("/update", authMiddleware(userHandler.UpdateUser))
 		userRoutes.PATCH("/password", authMiddleware(userHandler.UpdatePassword))
@@ -55,14 +81,17 @@ func RegisterPostRoutes(
 		posts.POST("/like", authMiddleware(h.LikePostHandler))
 		posts.GET("", h.GetPost)                           // Get post by query param "id"
 		posts.GET("/all", h.GetAllPosts)                   // Get all posts with pagination
-		posts.PUT("/:id", authMiddleware(h.UpdatePost))    // Update post by ID
+		posts.PATCH("/:id", authMiddleware(h.EditPost))    // Edit post by ID
 		posts.DELETE("/:id", authMiddleware(h.DeletePost)) // Delete post by ID
+		posts.PATCH("/:id/lifetime", authMiddleware(h.ChangeLifetime))
+		posts.GET("/:id/revisions", h.GetRevisions)
 	}
 }
 
 func RegisterCommentRoutes(
 	r *gin.Engine,
 	commentService repos.ICommentService,
+	presenceService repos.IPresenceService,
 	file_service repos.IFIleStoreService,
 	logger *log.Logger,
 	redis *redis.Client,
@@ -70,7 +99,7 @@ func RegisterCommentRoutes(
 	wsMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
 	commentMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
 
-	commentHandler := handler.NewCommentHandler(commentService, file_service, logger, redis)
+	commentHandler := handler.NewCommentHandler(commentService, presenceService, file_service, logger, redis)
 
 	commentRoutes := r.Group("/comments")
 	{
@@ -86,44 +115,171 @@ func RegisterBackgroundHandler(
 	r *gin.Engine,
 	background_service repos.IBackgroundService,
 	logger *log.Logger,
+	adminMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
 ) {
 
 	background_handler := handler.NewBackgroundHandler(background_service, logger)
 
 	backgroundRoutes := r.Group("/backgrounds")
 
-	backgroundRoutes.POST("/", background_handler.CreateBackground)
+	backgroundRoutes.POST("/", adminMiddleware(background_handler.CreateBackground))
 	backgroundRoutes.GET("/", background_handler.GetAllBackgrounds)
 	backgroundRoutes.GET("/:id", background_handler.GetBackgroundByID)
-	backgroundRoutes.DELETE("/:id", background_handler.DeleteBackground)
+	backgroundRoutes.DELETE("/:id", adminMiddleware(background_handler.DeleteBackground))
+}
+
+func RegisterAdminHandler(r *gin.Engine, adminService repos.IAdminService, logger *log.Logger, adminMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	adminHandler := handler.NewAdminHandler(adminService, logger)
+
+	adminRoutes := r.Group("/admin")
+	{
+		adminRoutes.GET("/users", adminMiddleware(adminHandler.GetUsersByRole))
+		adminRoutes.PATCH("/users/:id/role", adminMiddleware(adminHandler.SetUserRole))
+	}
+}
+
+func RegisterVerificationHandler(r *gin.Engine, verificationService repos.IVerificationService, logger *log.Logger, authMiddleware, moderatorMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	verificationHandler := handler.NewVerificationHandler(verificationService, logger)
+
+	userRoutes := r.Group("/users")
+	{
+		userRoutes.POST("/me/verification", authMiddleware(verificationHandler.RequestVerification))
+		userRoutes.GET("/me/verification", authMiddleware(verificationHandler.GetRequests))
+	}
+
+	moderationRoutes := r.Group("/moderation")
+	{
+		moderationRoutes.GET("/verifications", moderatorMiddleware(verificationHandler.GetPendingRequests))
+		moderationRoutes.POST("/verifications/:id/approve", moderatorMiddleware(verificationHandler.Approve))
+		moderationRoutes.POST("/verifications/:id/reject", moderatorMiddleware(verificationHandler.Reject))
+		moderationRoutes.DELETE("/users/:id/verification", moderatorMiddleware(verificationHandler.Revoke))
+	}
+}
+
+func RegisterDraftHandler(r *gin.Engine, draftService repos.IDraftService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	draftHandler := handler.NewDraftHandler(draftService, logger)
+
+	draftRoutes := r.Group("/posts/drafts")
+	{
+		draftRoutes.POST("", authMiddleware(draftHandler.CreateDraft))
+		draftRoutes.GET("", authMiddleware(draftHandler.GetDrafts))
+		draftRoutes.GET("/:id", authMiddleware(draftHandler.GetDraft))
+		draftRoutes.PUT("/:id", authMiddleware(draftHandler.UpdateDraft))
+		draftRoutes.DELETE("/:id", authMiddleware(draftHandler.DeleteDraft))
+		draftRoutes.POST("/:id/publish", authMiddleware(draftHandler.PublishDraft))
+		draftRoutes.DELETE("/:id/schedule", authMiddleware(draftHandler.CancelSchedule))
+	}
 }
 
 func RegisterChatHandler(
 	r *gin.Engine,
 	service repos.IChatService,
+	presenceService repos.IPresenceService,
 	fileService repos.IFIleStoreService,
 	logger *log.Logger,
 	redis *redis.Client,
 	authMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
 	wsMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
+	wsWriteMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
 ) {
-	chat_handler := handler.NewChatHandler(service, fileService, logger, redis)
+	chat_handler := handler.NewChatHandler(service, presenceService, fileService, logger, redis)
 
 	chat_handler_routes := r.Group("/chat")
 
 	chat_handler_routes.GET("direct", wsMiddleware(chat_handler.HandleChatWebSocket))
 	chat_handler_routes.GET("direct/messages", authMiddleware(chat_handler.GetMessages))
-	chat_handler_routes.PATCH("update", wsMiddleware(chat_handler.UpdateMessage))
-	chat_handler_routes.DELETE("dlete", wsMiddleware(chat_handler.DeleteMessage))
+	chat_handler_routes.PATCH("update", wsWriteMiddleware(chat_handler.UpdateMessage))
+	chat_handler_routes.DELETE("dlete", wsWriteMiddleware(chat_handler.DeleteMessage))
 }
 
-func RegisterFileStorageHandler(r *gin.Engine, file_service repos.IFIleStoreService, logger *log.Logger) {
+func RegisterFileStorageHandler(r *gin.Engine, file_service repos.IFIleStoreService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
 	file_getter_handler := handler.NewFIleGetterHandler(file_service, logger)
 
-	r.POST("/upload/file/soand/secure", file_getter_handler.UploadFile)
+	r.POST("/upload/file/soand/secure", authMiddleware(file_getter_handler.UploadFile))
 	r.GET("get/file/by/query", file_getter_handler.GetFileById)
 }
 
+func RegisterAccountHandler(r *gin.Engine, accountService repos.IAccountService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	accountHandler := handler.NewAccountHandler(accountService, logger)
+
+	r.DELETE("/users/me", authMiddleware(accountHandler.DeleteAccount))
+	r.POST("/users/me/export", authMiddleware(accountHandler.RequestExport))
+	r.POST("/users/me/deactivate", authMiddleware(accountHandler.DeactivateAccount))
+	r.GET("/users/jobs/:id", authMiddleware(accountHandler.GetJob))
+}
+
+func RegisterFollowHandler(
+	r *gin.Engine,
+	followService repos.IFollowService,
+	logger *log.Logger,
+	authMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
+	optionalAuthMiddleware func(gin.HandlerFunc) gin.HandlerFunc,
+) {
+	followHandler := handler.NewFollowHandler(followService, logger)
+
+	userRoutes := r.Group("/users")
+	{
+		userRoutes.POST("/:id/follow", authMiddleware(followHandler.Follow))
+		userRoutes.DELETE("/:id/follow", authMiddleware(followHandler.Unfollow))
+		userRoutes.GET("/:id/followers", optionalAuthMiddleware(followHandler.GetFollowers))
+		userRoutes.GET("/:id/following", optionalAuthMiddleware(followHandler.GetFollowing))
+		userRoutes.GET("/me/follow-requests", authMiddleware(followHandler.GetFollowRequests))
+		userRoutes.POST("/me/follow-requests/:id", authMiddleware(followHandler.AcceptFollowRequest))
+		userRoutes.DELETE("/me/follow-requests/:id", authMiddleware(followHandler.RejectFollowRequest))
+	}
+}
+
+func RegisterBlockHandler(r *gin.Engine, blockService repos.IBlockService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	blockHandler := handler.NewBlockHandler(blockService, logger)
+
+	userRoutes := r.Group("/users")
+	{
+		userRoutes.POST("/:id/block", authMiddleware(blockHandler.Block))
+		userRoutes.DELETE("/:id/block", authMiddleware(blockHandler.Unblock))
+		userRoutes.GET("/me/blocks", authMiddleware(blockHandler.GetBlockedUsers))
+	}
+}
+
+func RegisterAPIKeyHandler(r *gin.Engine, apiKeyService repos.IAPIKeyService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService, logger)
+
+	userRoutes := r.Group("/users")
+	{
+		userRoutes.POST("/me/api-keys", authMiddleware(apiKeyHandler.CreateKey))
+		userRoutes.GET("/me/api-keys", authMiddleware(apiKeyHandler.GetKeys))
+		userRoutes.DELETE("/me/api-keys/:id", authMiddleware(apiKeyHandler.RevokeKey))
+	}
+}
+
+func RegisterOIDCHandler(r *gin.Engine, oidcService repos.IOIDCService, logger *log.Logger, authMiddleware, rateLimitMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	oidcHandler := handler.NewOIDCHandler(oidcService, logger)
+
+	oidcRoutes := r.Group("/auth/oidc")
+	{
+		oidcRoutes.GET("/providers", oidcHandler.GetProviders)
+		oidcRoutes.GET("/:provider/login", rateLimitMiddleware(oidcHandler.Login))
+		oidcRoutes.GET("/:provider/callback", rateLimitMiddleware(oidcHandler.Callback))
+	}
+
+	userRoutes := r.Group("/users")
+	{
+		userRoutes.POST("/me/identities/:provider", authMiddleware(oidcHandler.LinkIdentity))
+		userRoutes.DELETE("/me/identities/:provider", authMiddleware(oidcHandler.UnlinkIdentity))
+	}
+}
+
+func RegisterPresenceHandler(r *gin.Engine, presenceService repos.IPresenceService, logger *log.Logger, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc) {
+	presenceHandler := handler.NewPresenceHandler(presenceService, logger)
+
+	r.GET("/users/presence", authMiddleware(presenceHandler.GetPresence))
+}
+
+func RegisterJWKSHandler(r *gin.Engine, keys *jwtkeys.KeySet) {
+	jwksHandler := handler.NewJWKSHandler(keys)
+
+	r.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
+}
+
 func RegisterPinnedChatsHandler(r *gin.Engine, pinnedChatService *service.PinnedChatsService, authMiddleware func(gin.HandlerFunc) gin.HandlerFunc, logger *log.Logger) {
 	pinnedChatHandler := handler.NewPinnedChatsHandler(pinnedChatService, logger)
 
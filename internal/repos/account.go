@@ -0,0 +1,17 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IAccountService interface {
+		RequestAccountDeletion(ctx context.Context, userID, sessionID primitive.ObjectID, password, code string) (*models.Job, error)
+		DeactivateAccount(ctx context.Context, userID, sessionID primitive.ObjectID, password, code string) error
+		RequestExport(ctx context.Context, userID primitive.ObjectID) (*models.Job, error)
+		GetJob(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error)
+	}
+)
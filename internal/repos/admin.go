@@ -0,0 +1,15 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IAdminService interface {
+		SetUserRole(ctx context.Context, adminID, userID primitive.ObjectID, role string) error
+		GetUsersByRole(ctx context.Context, role string, page, pageSize int64) ([]*models.User, error)
+	}
+)
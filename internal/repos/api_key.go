@@ -0,0 +1,17 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IAPIKeyService interface {
+		CreateKey(ctx context.Context, userID primitive.ObjectID, request *models.APIKeyRequest) (*models.NewAPIKey, error)
+		GetKeys(ctx context.Context, userID primitive.ObjectID) ([]*models.APIKey, error)
+		RevokeKey(ctx context.Context, userID, keyID primitive.ObjectID) error
+		Authenticate(ctx context.Context, key string) (apiKey *models.APIKey, role string, err error)
+	}
+)
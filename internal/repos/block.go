@@ -0,0 +1,16 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IBlockService interface {
+		Block(ctx context.Context, blockerID, blockedID primitive.ObjectID) error
+		Unblock(ctx context.Context, blockerID, blockedID primitive.ObjectID) error
+		GetBlockedUsers(ctx context.Context, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error)
+	}
+)
@@ -11,9 +11,10 @@ type (
 	ICommentService interface {
 		CreateComment(context.Context, *models.Comment) error
 		DeleteComment(context.Context, primitive.ObjectID, primitive.ObjectID) error
-		GetCommentsByPostID(context.Context, primitive.ObjectID, int64, int64) ([]*models.Comment, error)
+		GetCommentsByPostID(context.Context, primitive.ObjectID, primitive.ObjectID, int64, int64) ([]*models.Comment, error)
 		UpdateCommentText(context.Context, primitive.ObjectID, primitive.ObjectID, string) error
 		GetCommentByID(context.Context, primitive.ObjectID) (*models.Comment, error)
 		ReactToComment(context.Context, *models.Reaction) error
+		GetHiddenUserIDs(context.Context, primitive.ObjectID) ([]primitive.ObjectID, error)
 	}
 )
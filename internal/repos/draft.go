@@ -0,0 +1,21 @@
+package repos
+
+import (
+	"context"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IDraftService interface {
+		CreateDraft(ctx context.Context, userID primitive.ObjectID, request *dto.DraftRequest) (*models.Draft, error)
+		GetDrafts(ctx context.Context, userID primitive.ObjectID) ([]*models.Draft, error)
+		GetDraft(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Draft, error)
+		UpdateDraft(ctx context.Context, userID, draftID primitive.ObjectID, request *dto.DraftRequest) (*models.Draft, error)
+		CancelSchedule(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Draft, error)
+		PublishDraft(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Post, error)
+		DeleteDraft(ctx context.Context, userID, draftID primitive.ObjectID) error
+	}
+)
@@ -1,16 +1,20 @@
 package repos
 
 import (
+	"context"
 	"mime/multipart"
 
 	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type (
 	IFIleStoreService interface {
 		DeleteFile(string) error
+		DeleteOwnedFile(ctx context.Context, ownerID primitive.ObjectID, filename string) error
 		GetFile(string) (string, error)
-		UploadFile(*multipart.FileHeader) (*dto.FileObject, error)
+		UploadFile(file *multipart.FileHeader, ownerID primitive.ObjectID) (*dto.FileObject, error)
 		UploadFileFromBytes([]byte, string) (string, error)
+		CheckOwner(ctx context.Context, ownerID primitive.ObjectID, filenames ...string) error
 	}
 )
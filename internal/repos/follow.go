@@ -0,0 +1,20 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IFollowService interface {
+		Follow(ctx context.Context, followerID, followeeID primitive.ObjectID) (string, error)
+		Unfollow(ctx context.Context, followerID, followeeID primitive.ObjectID) error
+		GetFollowers(ctx context.Context, viewerID, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error)
+		GetFollowing(ctx context.Context, viewerID, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error)
+		GetFollowRequests(ctx context.Context, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error)
+		AcceptFollowRequest(ctx context.Context, userID, followerID primitive.ObjectID) error
+		RejectFollowRequest(ctx context.Context, userID, followerID primitive.ObjectID) error
+	}
+)
@@ -0,0 +1,17 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IOIDCService interface {
+		Providers() []string
+		StartLogin(ctx context.Context, provider string, linkTo primitive.ObjectID) (string, string, error)
+		Callback(ctx context.Context, provider, code, state, binding string, meta *models.SessionMeta) (*models.ExternalLoginResult, error)
+		Unlink(ctx context.Context, userID primitive.ObjectID, provider string) error
+	}
+)
@@ -0,0 +1,25 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	// PostArchiver keeps expired posts before they are deleted. Archiving the same post again
+	// replaces the earlier copy, so a sweep that fails halfway can simply run again.
+	PostArchiver interface {
+		ArchivePost(ctx context.Context, archive *models.ArchivedPost) error
+		// ForEachPostByCreator calls fn with every archived post of the user, without the comments
+		ForEachPostByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.ArchivedPost) error) error
+		// ForEachCommentOnPostsByCreator calls fn with every comment on the archived posts of the user
+		ForEachCommentOnPostsByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.Comment) error) error
+		// ForEachCommentByUser calls fn with every archived comment the user wrote, on any post
+		ForEachCommentByUser(ctx context.Context, userID primitive.ObjectID, fn func(*models.Comment) error) error
+		// DeleteByUser removes the archived posts of the user with their comments, and the
+		// comments the user wrote in other archived posts. It is idempotent.
+		DeleteByUser(ctx context.Context, userID primitive.ObjectID) error
+	}
+)
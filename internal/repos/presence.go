@@ -0,0 +1,16 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IPresenceService interface {
+		Track(ctx context.Context, userID primitive.ObjectID) func()
+		Channels(ctx context.Context, viewerID, userID primitive.ObjectID) ([]string, error)
+		GetPresence(ctx context.Context, viewerID primitive.ObjectID, userIDs []primitive.ObjectID) ([]*models.Presence, error)
+	}
+)
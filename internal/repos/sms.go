@@ -0,0 +1,10 @@
+package repos
+
+import "context"
+
+type (
+	// SMSSender delivers text messages. Swap the implementation to change SMS providers.
+	SMSSender interface {
+		Send(ctx context.Context, phone, message string) error
+	}
+)
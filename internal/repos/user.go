@@ -9,21 +9,38 @@ import (
 
 type (
 	UserRepo interface {
-		CreateUser(context.Context, *models.User) (string, error)
-		DeleteUser(context.Context, primitive.ObjectID) error
-		GetUserByID(context.Context, primitive.ObjectID) (*models.User, error)
-		GetUserByUsername(context.Context, string) (*models.User, error)
+		CreateUser(context.Context, *models.User, *models.SessionMeta) (*models.AuthTokens, error)
+		GetUserByID(context.Context, primitive.ObjectID, primitive.ObjectID) (*models.User, error)
+		GetUserByUsername(context.Context, primitive.ObjectID, string) (*models.User, error)
+		SearchUsers(context.Context, primitive.ObjectID, string, int64, int64) ([]*models.UserSummary, error)
+		GetSettings(context.Context, primitive.ObjectID) (*models.UserSettings, error)
+		UpdateSettings(context.Context, primitive.ObjectID, *models.SettingsUpdate) (*models.UserSettings, error)
 		// UpdateFullname(context.Context, primitive.ObjectID, string) error
 		UpdateUser(context.Context, primitive.ObjectID, *models.UserUpdate) error
 		UpdatePassword(context.Context, primitive.ObjectID, string, string) error
 		UpdateUsername(context.Context, primitive.ObjectID, string) error
-		ValidateJWT(string) (string, error)
-		LoginUser(context.Context, string, string) (string, error)
+		ValidateJWT(string) (*models.TokenClaims, error)
+		LoginUser(context.Context, string, string, *models.SessionMeta) (*models.LoginResult, error)
+		ReactivateUser(context.Context, string, string, *models.SessionMeta) (*models.LoginResult, error)
+		LoginTwoFactor(context.Context, string, string, *models.SessionMeta) (*models.AuthTokens, error)
+		EnrollTwoFactor(context.Context, primitive.ObjectID) (*models.TwoFactorEnrollment, error)
+		ConfirmTwoFactor(context.Context, primitive.ObjectID, string) ([]string, error)
+		DisableTwoFactor(context.Context, primitive.ObjectID, string, string) error
+		RefreshTokens(context.Context, string, *models.SessionMeta) (*models.AuthTokens, error)
+		Logout(context.Context, primitive.ObjectID, primitive.ObjectID) error
+		LogoutAll(context.Context, primitive.ObjectID) error
+		GetSessions(context.Context, primitive.ObjectID, primitive.ObjectID) ([]*models.Session, error)
+		RevokeSession(context.Context, primitive.ObjectID, primitive.ObjectID) error
+		RevokeOtherSessions(context.Context, primitive.ObjectID, primitive.ObjectID) error
+		RequestPhoneVerification(context.Context, primitive.ObjectID, string) error
+		ConfirmPhoneVerification(context.Context, primitive.ObjectID, string) error
+		RequestPasswordReset(context.Context, string) error
+		ResetPassword(context.Context, string, string, string) error
 		// ChangeProfileVisibility(context.Context, primitive.ObjectID, bool) error
 		// SetBio(context.Context, primitive.ObjectID, string) error
 		SetBackgroundPic(context.Context, primitive.ObjectID, string) error
 		AddNewProfilePicture(context.Context, primitive.ObjectID, string) error
 		DeleteProfilePicture(context.Context, primitive.ObjectID, string) error
-		GetProfilePictures(context.Context, primitive.ObjectID) ([]models.ProfilePic, error)
+		GetProfilePictures(context.Context, primitive.ObjectID, primitive.ObjectID) ([]models.ProfilePic, error)
 	}
 )
@@ -0,0 +1,19 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	IVerificationService interface {
+		RequestVerification(ctx context.Context, userID primitive.ObjectID, note string) (*models.VerificationRequest, error)
+		GetRequests(ctx context.Context, userID primitive.ObjectID) ([]*models.VerificationRequest, error)
+		GetPendingRequests(ctx context.Context, page, pageSize int64) ([]*models.VerificationRequest, error)
+		Approve(ctx context.Context, moderatorID, requestID primitive.ObjectID) error
+		Reject(ctx context.Context, moderatorID, requestID primitive.ObjectID, reason string) error
+		Revoke(ctx context.Context, moderatorID, userID primitive.ObjectID) error
+	}
+)
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportsPrefix is the bucket prefix under which export archives are stored
+const ExportsPrefix = "exports/"
+
+// AccountService handles account-wide operations that run as background jobs
+type AccountService struct {
+	users    *storage.UserStorage
+	sessions *storage.SessionStorage
+	jobs     *storage.JobStorage
+	cleanup  *Cleanup
+	exporter *Exporter
+	files    *storage.FileStorage
+	linkTTL  time.Duration
+	logger   *log.Logger
+}
+
+// NewAccountService initializes AccountService and registers its job handlers with the runner
+func NewAccountService(
+	users *storage.UserStorage,
+	sessions *storage.SessionStorage,
+	jobs *storage.JobStorage,
+	cleanup *Cleanup,
+	exporter *Exporter,
+	files *storage.FileStorage,
+	linkTTL time.Duration,
+	runner *JobRunner,
+	logger *log.Logger,
+) repos.IAccountService {
+	s := &AccountService{
+		users:    users,
+		sessions: sessions,
+		jobs:     jobs,
+		cleanup:  cleanup,
+		exporter: exporter,
+		files:    files,
+		linkTTL:  linkTTL,
+		logger:   logger,
+	}
+
+	runner.Handle(models.JobAccountDeletion, s.runAccountDeletion)
+	runner.Handle(models.JobAccountExport, s.runAccountExport)
+
+	return s
+}
+
+// RequestAccountDeletion confirms the user's identity, blocks new logins, logs out every other
+// device and queues the deletion job. The current session keeps working until the job finishes
+// so its progress can be followed.
+func (s *AccountService) RequestAccountDeletion(ctx context.Context, userID, sessionID primitive.ObjectID, password, code string) (*models.Job, error) {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching user %s for deletion: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	if err := s.users.ConfirmIdentity(ctx, user, sessionID, password, code); err != nil {
+		return nil, err
+	}
+
+	if user.DeletingAt != nil {
+		// Deletion was requested before; hand back the job that is already queued
+		job, err := s.jobs.FindUnfinishedJob(ctx, userID, models.JobAccountDeletion)
+		if err == nil {
+			return job, nil
+		}
+		if !errors.Is(err, dto.ErrJobNotFound) {
+			return nil, err
+		}
+		// The earlier job failed for good or was never queued, so queue a new one
+	} else if err := s.users.MarkDeleting(ctx, userID); err != nil {
+		s.logger.Printf("Error marking user %s for deletion: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	if _, err := s.users.RevokeOtherSessions(ctx, userID, sessionID); err != nil {
+		s.logger.Printf("Error revoking sessions of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	job, err := s.jobs.CreateJob(ctx, models.JobAccountDeletion, userID)
+	if err != nil {
+		s.logger.Printf("Error queueing deletion of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Printf("Queued deletion of user %s as job %s\n", userID.Hex(), job.ID.Hex())
+	return job, nil
+}
+
+// DeactivateAccount hides the user's profile and content and logs them out everywhere.
+// Logging back in through the reactivation flow undoes it; otherwise the account is
+// deleted once the grace period is over.
+func (s *AccountService) DeactivateAccount(ctx context.Context, userID, sessionID primitive.ObjectID, password, code string) error {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching user %s for deactivation: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	if err := s.users.ConfirmIdentity(ctx, user, sessionID, password, code); err != nil {
+		return err
+	}
+
+	if err := s.users.Deactivate(ctx, userID); err != nil {
+		s.logger.Printf("Error deactivating user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	if _, err := s.users.LogoutAll(ctx, userID); err != nil {
+		s.logger.Printf("Error revoking sessions of user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("User %s deactivated\n", userID.Hex())
+	return nil
+}
+
+// RequestExport queues an archive of everything stored about the user. While an export
+// is still queued or running, that job is returned instead of starting another one.
+func (s *AccountService) RequestExport(ctx context.Context, userID primitive.ObjectID) (*models.Job, error) {
+	job, err := s.jobs.FindUnfinishedJob(ctx, userID, models.JobAccountExport)
+	if err == nil {
+		return job, nil
+	}
+	if !errors.Is(err, dto.ErrJobNotFound) {
+		s.logger.Printf("Error looking up exports of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	job, err = s.jobs.CreateJob(ctx, models.JobAccountExport, userID)
+	if err != nil {
+		s.logger.Printf("Error queueing export of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Printf("Queued export of user %s as job %s\n", userID.Hex(), job.ID.Hex())
+	return job, nil
+}
+
+// GetJob returns one of the user's background jobs. A finished export comes with a fresh download link.
+func (s *AccountService) GetJob(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	job, err := s.jobs.GetJob(ctx, userID, jobID)
+	if err != nil {
+		s.logger.Printf("Error fetching job %s: %v\n", jobID.Hex(), err)
+		return nil, err
+	}
+
+	if job.Type == models.JobAccountExport && job.Status == models.JobCompleted {
+		url, err := s.files.GetFileWithExpiry(ctx, job.Result, s.linkTTL)
+		if err != nil {
+			s.logger.Printf("Error signing export %s: %v\n", job.Result, err)
+			return nil, err
+		}
+		job.DownloadURL = url
+	}
+
+	return job, nil
+}
+
+// runAccountDeletion removes everything the user owns, step by step. The user document goes last
+// so a retried job still knows whose data to delete and which profile pictures to remove.
+func (s *AccountService) runAccountDeletion(ctx context.Context, job *models.Job, progress JobProgress) (string, error) {
+	userID := job.UserID
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil // deleted by an earlier attempt that died before completing the job
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load user: %v", err)
+	}
+	if user.DeletingAt == nil {
+		// every deletion is marked before it is queued, the mark is gone only if it was called off
+		return "", errors.New("the user is not marked for deletion")
+	}
+
+	steps := []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		// drafts go first, so none is published after the posts are gone
+		{"drafts", func(ctx context.Context) error { return s.cleanup.DeleteDraftsByUser(ctx, userID) }},
+		{"posts", func(ctx context.Context) error { return s.cleanup.PurgePostsByUser(ctx, userID) }},
+		{"comments", func(ctx context.Context) error { return s.cleanup.DeleteCommentsByUser(ctx, userID) }},
+		{"archives", func(ctx context.Context) error { return s.cleanup.DeleteArchivesByUser(ctx, userID) }},
+		{"reactions", func(ctx context.Context) error { return s.cleanup.RemoveReactionsByUser(ctx, userID) }},
+		{"likes", func(ctx context.Context) error { return s.cleanup.RemoveLikesByUser(ctx, userID) }},
+		{"pinned_chats", func(ctx context.Context) error { return s.cleanup.DeletePinsByUser(ctx, userID) }},
+		{"messages", func(ctx context.Context) error { return s.cleanup.DeleteMessagesOfUser(ctx, userID) }},
+		{"follows", func(ctx context.Context) error { return s.cleanup.DeleteFollowsByUser(ctx, userID) }},
+		{"blocks", func(ctx context.Context) error { return s.cleanup.DeleteBlocksByUser(ctx, userID) }},
+		{"api_keys", func(ctx context.Context) error { return s.cleanup.DeleteAPIKeysByUser(ctx, userID) }},
+		{"verification_requests", func(ctx context.Context) error { return s.cleanup.DeleteVerificationRequestsByUser(ctx, userID) }},
+		{"profile_pictures", func(ctx context.Context) error { return s.cleanup.RemoveProfilePictures(ctx, user) }},
+		{"exports", func(ctx context.Context) error { return s.files.RemoveFilesWithPrefix(ctx, exportPrefix(userID)) }},
+		{"sessions", func(ctx context.Context) error { return s.sessions.DeleteAllSessions(ctx, userID) }},
+		{"user", func(ctx context.Context) error { return s.users.DeleteUser(ctx, userID) }},
+	}
+
+	for i, step := range steps {
+		if err := progress(step.name, i, len(steps)); err != nil {
+			return "", fmt.Errorf("failed to report progress: %v", err)
+		}
+
+		if err := step.run(ctx); err != nil {
+			return "", fmt.Errorf("%s: %v", step.name, err)
+		}
+	}
+
+	if err := progress("", len(steps), len(steps)); err != nil {
+		return "", fmt.Errorf("failed to report progress: %v", err)
+	}
+
+	s.logger.Printf("User %s and all their data deleted\n", userID.Hex())
+	return "", nil
+}
+
+// runAccountExport builds the user's archive and stores its object name as the job result. The
+// name is random so it cannot be guessed from the user and job IDs.
+func (s *AccountService) runAccountExport(ctx context.Context, job *models.Job, progress JobProgress) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to name archive: %v", err)
+	}
+	objectName := exportPrefix(job.UserID) + hex.EncodeToString(random) + ".zip"
+
+	if err := s.exporter.Export(ctx, job.UserID, objectName, progress); err != nil {
+		return "", err
+	}
+
+	s.logger.Printf("Export of user %s stored as %s\n", job.UserID.Hex(), objectName)
+	return objectName, nil
+}
+
+// exportPrefix is where the archives of a user are kept in the bucket
+func exportPrefix(userID primitive.ObjectID) string {
+	return ExportsPrefix + userID.Hex() + "/"
+}
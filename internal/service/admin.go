@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminService handles operations only admins may perform
+type AdminService struct {
+	users  *storage.UserStorage
+	logger *log.Logger
+}
+
+// NewAdminService initializes AdminService
+func NewAdminService(users *storage.UserStorage, logger *log.Logger) repos.IAdminService {
+	return &AdminService{users: users, logger: logger}
+}
+
+// SetUserRole changes the role of a user and logs them out everywhere, so tokens
+// carrying the old role stop working right away
+func (s *AdminService) SetUserRole(ctx context.Context, adminID, userID primitive.ObjectID, role string) error {
+	if !models.IsValidRole(role) {
+		return dto.ErrInvalidRole
+	}
+
+	// keeps the last admin from locking everyone out by demoting themselves
+	if adminID == userID {
+		return dto.ErrOwnRoleChange
+	}
+
+	if err := s.users.SetRole(ctx, userID, role); err != nil {
+		s.logger.Printf("Error setting role of user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	if _, err := s.users.LogoutAll(ctx, userID); err != nil {
+		s.logger.Printf("Error revoking sessions of user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("Admin %s set role of user %s to %s\n", adminID.Hex(), userID.Hex(), role)
+	return nil
+}
+
+// GetUsersByRole lists the users that have the given role
+func (s *AdminService) GetUsersByRole(ctx context.Context, role string, page, pageSize int64) ([]*models.User, error) {
+	if !models.IsValidRole(role) {
+		return nil, dto.ErrInvalidRole
+	}
+
+	users, err := s.users.GetUsersByRole(ctx, role, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error fetching users with role %s: %v\n", role, err)
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxAPIKeysPerUser    = 20
+	maxAPIKeyLifetime    = 365 // days
+	maxAPIKeyNameLength  = 64  // see dto.ErrInvalidKeyName
+	apiKeyHintLength     = len(models.APIKeyPrefix) + 6
+	apiKeySecretByteSize = 32
+)
+
+// APIKeyService manages the personal API keys of users and authenticates requests made with them
+type APIKeyService struct {
+	keys   *storage.APIKeyStorage
+	users  *storage.UserStorage
+	logger *log.Logger
+}
+
+// NewAPIKeyService initializes APIKeyService
+func NewAPIKeyService(keys *storage.APIKeyStorage, users *storage.UserStorage, logger *log.Logger) repos.IAPIKeyService {
+	return &APIKeyService{keys: keys, users: users, logger: logger}
+}
+
+// CreateKey creates a key for the user and returns it, this is the only time the key itself is available
+func (s *APIKeyService) CreateKey(ctx context.Context, userID primitive.ObjectID, request *models.APIKeyRequest) (*models.NewAPIKey, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return nil, dto.ErrInvalidKeyName
+	}
+
+	scopes, err := normalizeScopes(request.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > maxAPIKeyLifetime {
+		return nil, dto.ErrInvalidKeyExpiry
+	}
+
+	count, err := s.keys.CountKeysByUser(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error counting API keys of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, dto.ErrTooManyAPIKeys
+	}
+
+	secret := make([]byte, apiKeySecretByteSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %v", err)
+	}
+	plain := models.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &models.APIKey{
+		UserID: userID,
+		Name:   name,
+		Hint:   plain[:apiKeyHintLength],
+		Hash:   hashAPIKey(plain),
+		Scopes: scopes,
+	}
+	if request.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := s.keys.CreateKey(ctx, key); err != nil {
+		s.logger.Printf("Error creating API key for user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Printf("API key %s created for user %s with scopes %v\n", key.ID.Hex(), userID.Hex(), scopes)
+	return &models.NewAPIKey{Key: plain, APIKey: key}, nil
+}
+
+// GetKeys lists the keys of the user, newest first
+func (s *APIKeyService) GetKeys(ctx context.Context, userID primitive.ObjectID) ([]*models.APIKey, error) {
+	keys, err := s.keys.GetKeysByUser(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching API keys of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RevokeKey deletes a key of the user, requests made with it fail from then on
+func (s *APIKeyService) RevokeKey(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	deleted, err := s.keys.DeleteKey(ctx, userID, keyID)
+	if err != nil {
+		s.logger.Printf("Error revoking API key %s: %v\n", keyID.Hex(), err)
+		return err
+	}
+	if !deleted {
+		return dto.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// Authenticate returns the key a request was made with and the current role of its owner.
+// Keys of deactivated users and users being deleted do not work.
+func (s *APIKeyService) Authenticate(ctx context.Context, plain string) (*models.APIKey, string, error) {
+	key, err := s.keys.GetKeyByHash(ctx, hashAPIKey(plain))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", dto.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, "", dto.ErrInvalidAPIKey
+	}
+
+	user, err := s.users.GetUserByID(ctx, key.UserID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", dto.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if user.DeactivatedAt != nil || user.DeletingAt != nil {
+		return nil, "", dto.ErrInvalidAPIKey
+	}
+
+	if err := s.keys.TouchKey(ctx, key.ID, now); err != nil {
+		s.logger.Printf("Error recording use of API key %s: %v\n", key.ID.Hex(), err)
+	}
+
+	return key, user.Role, nil
+}
+
+// normalizeScopes checks every scope and drops duplicates
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, dto.ErrNoScopes
+	}
+
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, scope := range scopes {
+		if !models.IsValidScope(scope) {
+			return nil, fmt.Errorf("%w: %q", dto.ErrInvalidScope, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+
+	return normalized, nil
+}
+
+// hashAPIKey hashes a key for storage. Keys are long and random, so a fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
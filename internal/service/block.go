@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BlockService lets users block each other. The block itself is enforced where users meet:
+// direct messages, comments, profiles and follows.
+type BlockService struct {
+	blocks  *storage.BlockStorage
+	follows *storage.FollowStorage
+	users   *storage.UserStorage
+	redis   *redis.Client
+	logger  *log.Logger
+}
+
+// NewBlockService initializes BlockService
+func NewBlockService(blocks *storage.BlockStorage, follows *storage.FollowStorage, users *storage.UserStorage, redis *redis.Client, logger *log.Logger) repos.IBlockService {
+	return &BlockService{blocks: blocks, follows: follows, users: users, redis: redis, logger: logger}
+}
+
+// Block blocks the user and removes the follows and follow requests between the two
+func (s *BlockService) Block(ctx context.Context, blockerID, blockedID primitive.ObjectID) error {
+	if blockerID == blockedID {
+		return dto.ErrSelfBlock
+	}
+
+	_, err := s.users.GetUserByID(ctx, blockedID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return dto.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.blocks.Block(ctx, blockerID, blockedID); err != nil {
+		s.logger.Printf("Error blocking user %s by %s: %v\n", blockedID.Hex(), blockerID.Hex(), err)
+		return err
+	}
+
+	if _, err := s.follows.Unfollow(ctx, blockerID, blockedID); err != nil {
+		s.logger.Printf("Error removing follow of %s by %s: %v\n", blockedID.Hex(), blockerID.Hex(), err)
+		return err
+	}
+	if _, err := s.follows.Unfollow(ctx, blockedID, blockerID); err != nil {
+		s.logger.Printf("Error removing follow of %s by %s: %v\n", blockerID.Hex(), blockedID.Hex(), err)
+		return err
+	}
+
+	s.notifyChanged(ctx, blockerID, blockedID)
+
+	s.logger.Printf("User %s blocked %s\n", blockerID.Hex(), blockedID.Hex())
+	return nil
+}
+
+// Unblock lifts the block, follows removed by it are not restored
+func (s *BlockService) Unblock(ctx context.Context, blockerID, blockedID primitive.ObjectID) error {
+	removed, err := s.blocks.Unblock(ctx, blockerID, blockedID)
+	if err != nil {
+		s.logger.Printf("Error unblocking user %s by %s: %v\n", blockedID.Hex(), blockerID.Hex(), err)
+		return err
+	}
+	if !removed {
+		return dto.ErrNotBlocked
+	}
+
+	s.notifyChanged(ctx, blockerID, blockedID)
+	return nil
+}
+
+// notifyChanged tells open connections of both users to reload who they hide, e.g. the comment
+// WebSocket, which filters live comments by it
+func (s *BlockService) notifyChanged(ctx context.Context, userIDs ...primitive.ObjectID) {
+	message, err := json.Marshal(map[string]any{
+		"action":    models.ActionBlocksChanged,
+		"timestamp": time.Now(),
+	})
+	if err != nil {
+		s.logger.Println("Error marshaling block change:", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		if err := s.redis.Publish(ctx, models.NotificationChannel(userID), string(message)).Err(); err != nil {
+			s.logger.Printf("Error publishing block change to %s: %v\n", userID.Hex(), err)
+		}
+	}
+}
+
+// GetBlockedUsers lists the users the user has blocked
+func (s *BlockService) GetBlockedUsers(ctx context.Context, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	users, err := s.blocks.GetBlockedUsers(ctx, userID, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error fetching blocked users of %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 
+	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/models"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"github.com/ruziba3vich/soand/internal/storage"
@@ -11,25 +12,70 @@ import (
 )
 
 type ChatService struct {
-	storage *storage.ChatStorage
-	logger  *log.Logger
+	storage  *storage.ChatStorage
+	blocks   *storage.BlockStorage
+	follows  *storage.FollowStorage
+	users    *storage.UserStorage
+	notifier *Notifier
+	logger   *log.Logger
 }
 
-func NewChatService(storage *storage.ChatStorage, logger *log.Logger) repos.IChatService {
+func NewChatService(
+	storage *storage.ChatStorage,
+	blocks *storage.BlockStorage,
+	follows *storage.FollowStorage,
+	users *storage.UserStorage,
+	notifier *Notifier,
+	logger *log.Logger) repos.IChatService {
 	return &ChatService{
-		storage: storage,
-		logger:  logger,
+		storage:  storage,
+		blocks:   blocks,
+		follows:  follows,
+		users:    users,
+		notifier: notifier,
+		logger:   logger,
 	}
 }
 
-// CreateMessage creates a new message and publishes it to Redis
+// CreateMessage creates a new message and publishes it to Redis.
+// Messages between users where either one has blocked the other are refused, and so are
+// messages the recipient's settings do not accept from the sender.
 func (s *ChatService) CreateMessage(ctx context.Context, message *models.Message) error {
 	s.logger.Printf("Creating message from %s to %s", message.SenderID.Hex(), message.RecipientID.Hex())
-	err := s.storage.CreateMessage(ctx, message)
+	blocked, err := s.blocks.IsBlockedEitherWay(ctx, message.SenderID, message.RecipientID)
+	if err != nil {
+		s.logger.Printf("Failed to check blocks: %v", err)
+		return err
+	}
+	if blocked {
+		s.logger.Printf("Message from %s to %s refused, the users are blocked", message.SenderID.Hex(), message.RecipientID.Hex())
+		return dto.ErrBlocked
+	}
+
+	recipient, err := s.users.GetUserByID(ctx, message.RecipientID)
+	if err != nil {
+		s.logger.Printf("Failed to load recipient %s: %v", message.RecipientID.Hex(), err)
+		return err
+	}
+	allowed, err := audienceAllows(ctx, s.follows, message.SenderID, recipient.ID, recipient.GetSettings().DirectMessages)
+	if err != nil {
+		s.logger.Printf("Failed to check who may message %s: %v", recipient.ID.Hex(), err)
+		return err
+	}
+	if !allowed {
+		s.logger.Printf("Message from %s to %s refused by the recipient's settings", message.SenderID.Hex(), message.RecipientID.Hex())
+		return dto.ErrMessagesNotAllowed
+	}
+
+	err = s.storage.CreateMessage(ctx, message)
 	if err != nil {
 		s.logger.Printf("Failed to create message: %v", err)
 		return err
 	}
+	s.notifier.Notify(ctx, message.RecipientID, models.NotifyDirectMessage, map[string]any{
+		"message_id": message.ID.Hex(),
+		"sender_id":  message.SenderID.Hex(),
+	})
 	s.logger.Printf("Message created and published: %s", message.ID.Hex())
 	return nil
 }
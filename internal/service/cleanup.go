@@ -0,0 +1,378 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cleanupBatchSize is how many documents are loaded at a time while deleting
+const cleanupBatchSize = 100
+
+// Cleanup deletes documents together with everything that depends on them, across collections
+// and MinIO. Every method is idempotent, so a cleanup that stops halfway can simply run again.
+// Files are removed before the documents that reference them, so a retry can still find them, and
+// only those uploaded by the user the document belongs to.
+type Cleanup struct {
+	posts         *storage.Storage
+	comments      *storage.CommentStorage
+	likes         *storage.LikesStorage
+	pins          *storage.PinnedChat
+	chats         *storage.ChatStorage
+	follows       *storage.FollowStorage
+	blocks        *storage.BlockStorage
+	apiKeys       *storage.APIKeyStorage
+	verifications *storage.VerificationStorage
+	drafts        *storage.DraftStorage
+	revisions     *storage.PostRevisionStorage
+	archiver      repos.PostArchiver // nil when expired posts are not archived
+	files         *storage.FileStorage
+	logger        *log.Logger
+}
+
+// NewCleanup initializes Cleanup
+func NewCleanup(
+	posts *storage.Storage,
+	comments *storage.CommentStorage,
+	likes *storage.LikesStorage,
+	pins *storage.PinnedChat,
+	chats *storage.ChatStorage,
+	follows *storage.FollowStorage,
+	blocks *storage.BlockStorage,
+	apiKeys *storage.APIKeyStorage,
+	verifications *storage.VerificationStorage,
+	drafts *storage.DraftStorage,
+	revisions *storage.PostRevisionStorage,
+	archiver repos.PostArchiver,
+	files *storage.FileStorage,
+	logger *log.Logger,
+) *Cleanup {
+	return &Cleanup{
+		posts:         posts,
+		comments:      comments,
+		likes:         likes,
+		pins:          pins,
+		chats:         chats,
+		follows:       follows,
+		blocks:        blocks,
+		apiKeys:       apiKeys,
+		verifications: verifications,
+		drafts:        drafts,
+		revisions:     revisions,
+		archiver:      archiver,
+		files:         files,
+		logger:        logger,
+	}
+}
+
+// PurgePost deletes a post with its comments, likes, pins, edit history and pictures
+func (c *Cleanup) PurgePost(ctx context.Context, post *models.Post) error {
+	return c.purgePost(ctx, post, false)
+}
+
+// PurgeArchivedPost deletes an archived post like PurgePost, but keeps the pictures of the post and
+// the media of its comments the archive refers to. DeleteArchivesByUser removes them with the archive.
+func (c *Cleanup) PurgeArchivedPost(ctx context.Context, post *models.Post) error {
+	return c.purgePost(ctx, post, true)
+}
+
+func (c *Cleanup) purgePost(ctx context.Context, post *models.Post, archived bool) error {
+	for {
+		comments, err := c.comments.GetCommentsByPost(ctx, post.ID, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to load comments of post %s: %v", post.ID.Hex(), err)
+		}
+		if len(comments) == 0 {
+			break
+		}
+		if err := c.deleteComments(ctx, comments, archived); err != nil {
+			return err
+		}
+	}
+
+	if err := c.likes.DeleteLikesByPost(ctx, post.ID); err != nil {
+		return fmt.Errorf("failed to delete likes of post %s: %v", post.ID.Hex(), err)
+	}
+
+	if err := c.pins.DeleteByChat(ctx, post.ID); err != nil {
+		return fmt.Errorf("failed to delete pins of post %s: %v", post.ID.Hex(), err)
+	}
+
+	// pictures dropped by an edit are only referenced by the revisions, so they go before them
+	pictures, err := c.revisions.GetRevisionPictures(ctx, post.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load revision pictures of post %s: %v", post.ID.Hex(), err)
+	}
+	if archived {
+		// the revisions are not archived, the pictures only they refer to go
+		pictures = slices.DeleteFunc(pictures, func(picture string) bool {
+			return slices.Contains(post.Pictures, picture)
+		})
+	} else {
+		pictures = append(pictures, post.Pictures...)
+	}
+	if err := c.removeFiles(ctx, post.CreatorId, pictures...); err != nil {
+		return err
+	}
+
+	if err := c.revisions.DeleteByPost(ctx, post.ID); err != nil {
+		return fmt.Errorf("failed to delete revisions of post %s: %v", post.ID.Hex(), err)
+	}
+
+	if err := c.posts.DeletePost(ctx, post.ID); err != nil && !errors.Is(err, storage.ErrPostNotFound) {
+		return fmt.Errorf("failed to delete post %s: %v", post.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// PurgePostsByUser deletes every post of the user with everything attached to it
+func (c *Cleanup) PurgePostsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	for {
+		posts, err := c.posts.GetPostsByCreator(ctx, userID, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to load posts: %v", err)
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+
+		for _, post := range posts {
+			if err := c.PurgePost(ctx, post); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// DeleteDraftsByUser deletes the user's drafts and scheduled posts with their pictures
+func (c *Cleanup) DeleteDraftsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	for {
+		drafts, err := c.drafts.GetDraftsByUser(ctx, userID, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to load drafts: %v", err)
+		}
+		if len(drafts) == 0 {
+			return nil
+		}
+
+		ids := make([]primitive.ObjectID, len(drafts))
+		for i, draft := range drafts {
+			if err := c.removeFiles(ctx, draft.CreatorId, draft.Pictures...); err != nil {
+				return err
+			}
+			ids[i] = draft.ID
+		}
+
+		if err := c.drafts.DeleteDraftsByIDs(ctx, ids); err != nil {
+			return fmt.Errorf("failed to delete drafts: %v", err)
+		}
+	}
+}
+
+// DeleteArchivesByUser deletes the user's archived posts with their comments, and the comments the
+// user wrote in other archived posts. The media they refer to goes first, so a retry still finds it.
+func (c *Cleanup) DeleteArchivesByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if c.archiver == nil {
+		return nil
+	}
+
+	err := c.archiver.ForEachPostByCreator(ctx, userID, func(archive *models.ArchivedPost) error {
+		return c.removeFiles(ctx, userID, archive.Post.Pictures...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove pictures of archived posts: %v", err)
+	}
+
+	err = c.archiver.ForEachCommentOnPostsByCreator(ctx, userID, func(comment *models.Comment) error {
+		return c.removeCommentMedia(ctx, comment)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove media of comments on archived posts: %v", err)
+	}
+
+	err = c.archiver.ForEachCommentByUser(ctx, userID, func(comment *models.Comment) error {
+		return c.removeCommentMedia(ctx, comment)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove media of archived comments: %v", err)
+	}
+
+	if err := c.archiver.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete archived posts: %v", err)
+	}
+	return nil
+}
+
+// DeleteCommentsByUser deletes the comments the user wrote on any post, with their media
+func (c *Cleanup) DeleteCommentsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	for {
+		comments, err := c.comments.GetCommentsByUser(ctx, userID, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to load comments: %v", err)
+		}
+		if len(comments) == 0 {
+			return nil
+		}
+		if err := c.deleteComments(ctx, comments, false); err != nil {
+			return err
+		}
+	}
+}
+
+// RemoveReactionsByUser takes the user's reactions off every comment
+func (c *Cleanup) RemoveReactionsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := c.comments.RemoveUserReactions(ctx, userID); err != nil {
+		return fmt.Errorf("failed to remove reactions: %v", err)
+	}
+	return nil
+}
+
+// RemoveLikesByUser takes back every like of the user, keeping the posts' like counts right
+func (c *Cleanup) RemoveLikesByUser(ctx context.Context, userID primitive.ObjectID) error {
+	for {
+		postIDs, err := c.likes.GetLikedPostIDs(ctx, userID, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to load likes: %v", err)
+		}
+		if len(postIDs) == 0 {
+			return nil
+		}
+
+		for _, postID := range postIDs {
+			removed, err := c.likes.RemoveLike(ctx, userID, postID)
+			if err != nil {
+				return fmt.Errorf("failed to remove like: %v", err)
+			}
+			if !removed {
+				continue
+			}
+			if err := c.posts.LikeOrDislikePost(ctx, userID, postID, -1); err != nil {
+				return fmt.Errorf("failed to update like count of post %s: %v", postID.Hex(), err)
+			}
+		}
+	}
+}
+
+// DeletePinsByUser deletes the user's pinned chats
+func (c *Cleanup) DeletePinsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := c.pins.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete pinned chats: %v", err)
+	}
+	return nil
+}
+
+// DeleteMessagesOfUser deletes every direct message the user sent or received, with their pictures.
+// A conversation with a deleted account can not be continued, so both sides go.
+func (c *Cleanup) DeleteMessagesOfUser(ctx context.Context, userID primitive.ObjectID) error {
+	for {
+		messages, err := c.chats.GetMessagesOfUser(ctx, userID, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to load messages: %v", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]primitive.ObjectID, len(messages))
+		for i, message := range messages {
+			if err := c.removeFiles(ctx, message.SenderID, message.Pictures...); err != nil {
+				return err
+			}
+			ids[i] = message.ID
+		}
+
+		if err := c.chats.DeleteMessagesByIDs(ctx, ids); err != nil {
+			return fmt.Errorf("failed to delete messages: %v", err)
+		}
+	}
+}
+
+// DeleteFollowsByUser removes the follows and follow requests of the user in both directions
+func (c *Cleanup) DeleteFollowsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := c.follows.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete follows: %v", err)
+	}
+	return nil
+}
+
+// DeleteBlocksByUser removes the blocks the user made or is the target of
+func (c *Cleanup) DeleteBlocksByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := c.blocks.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete blocks: %v", err)
+	}
+	return nil
+}
+
+// DeleteAPIKeysByUser revokes every API key of the user
+func (c *Cleanup) DeleteAPIKeysByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := c.apiKeys.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete API keys: %v", err)
+	}
+	return nil
+}
+
+// DeleteVerificationRequestsByUser removes the user's verification requests
+func (c *Cleanup) DeleteVerificationRequestsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := c.verifications.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete verification requests: %v", err)
+	}
+	return nil
+}
+
+// RemoveProfilePictures deletes the files of the user's profile pictures
+func (c *Cleanup) RemoveProfilePictures(ctx context.Context, user *models.User) error {
+	for _, pic := range user.ProfilePics {
+		if err := c.removeFiles(ctx, user.ID, pic.Url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteComments deletes the comments, with their media unless an archive keeps it
+func (c *Cleanup) deleteComments(ctx context.Context, comments []*models.Comment, keepMedia bool) error {
+	ids := make([]primitive.ObjectID, len(comments))
+	for i, comment := range comments {
+		if !keepMedia {
+			if err := c.removeCommentMedia(ctx, comment); err != nil {
+				return err
+			}
+		}
+		ids[i] = comment.ID
+	}
+
+	if err := c.comments.DeleteCommentsByIDs(ctx, ids); err != nil {
+		return fmt.Errorf("failed to delete comments: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Cleanup) removeCommentMedia(ctx context.Context, comment *models.Comment) error {
+	if err := c.removeFiles(ctx, comment.UserID, comment.Pictures...); err != nil {
+		return err
+	}
+	return c.removeFiles(ctx, comment.UserID, comment.VoiceMessage)
+}
+
+// removeFiles removes the files ownerID uploaded, a document may refer to files of others
+func (c *Cleanup) removeFiles(ctx context.Context, ownerID primitive.ObjectID, filenames ...string) error {
+	var errs []error
+	for _, filename := range filenames {
+		if filename == "" {
+			continue
+		}
+		if err := c.files.RemoveOwnedFile(ctx, filename, ownerID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
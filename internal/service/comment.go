@@ -20,23 +20,32 @@ import (
 
 type CommentService struct {
 	storage      *storage.CommentStorage
+	posts        *storage.Storage
+	notifier     *Notifier
 	redis        *redis.Client
 	logger       *log.Logger
 	user_storage *storage.UserStorage
+	blocks       *storage.BlockStorage
 	file_storage repos.IFIleStoreService
 }
 
 func NewCommentService(
 	storage *storage.CommentStorage,
+	posts *storage.Storage,
 	user_storage *storage.UserStorage,
+	blocks *storage.BlockStorage,
 	file_storage repos.IFIleStoreService,
+	notifier *Notifier,
 	redis *redis.Client, logger *log.Logger) repos.ICommentService {
 	return &CommentService{
 		storage:      storage,
+		posts:        posts,
+		notifier:     notifier,
 		redis:        redis,
 		file_storage: file_storage,
 		logger:       logger,
 		user_storage: user_storage,
+		blocks:       blocks,
 	}
 }
 
@@ -56,12 +65,16 @@ func (s *CommentService) CreateComment(ctx context.Context, comment *models.Comm
 	if comment.Pictures == nil {
 		comment.Pictures = make([]string, 0)
 	}
+	if err := s.file_storage.CheckOwner(ctx, comment.UserID, append([]string{comment.VoiceMessage}, comment.Pictures...)...); err != nil {
+		return err
+	}
 
 	// Store the comment in MongoDB
 	if err := s.storage.CreateComment(ctx, comment); err != nil {
 		s.logger.Println("Error storing comment:", err)
 		return err
 	}
+	s.notifyPostCreator(ctx, comment)
 
 	user, err := s.user_storage.GetUserByID(ctx, comment.UserID)
 	if err != nil {
@@ -72,9 +85,8 @@ func (s *CommentService) CreateComment(ctx context.Context, comment *models.Comm
 		return err
 	}
 	comment.OwnerFullname = user.Fullname
-	if len(user.ProfilePics) > 0 {
-		comment.OwnerProfilePic = user.ProfilePics[0].Url
-	}
+	comment.OwnerProfilePic = user.PublicProfilePic()
+	comment.OwnerVerified = user.Verified
 	if len(comment.VoiceMessage) > 0 {
 		if err := s.fetchVoiceMessage(comment); err != nil {
 			return err
@@ -116,8 +128,30 @@ func (s *CommentService) DeleteComment(ctx context.Context, commentID primitive.
 	return nil
 }
 
-func (s *CommentService) GetCommentsByPostID(ctx context.Context, postID primitive.ObjectID, page int64, pageSize int64) ([]*models.Comment, error) {
-	comments, err := s.storage.GetCommentsByPostID(ctx, postID, page, pageSize)
+// GetHiddenUserIDs returns the users whose comments the viewer does not see
+func (s *CommentService) GetHiddenUserIDs(ctx context.Context, viewerID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	hidden, err := s.blocks.GetHiddenUserIDs(ctx, viewerID)
+	if err != nil {
+		s.logger.Println("Error fetching blocked users:", err)
+		return nil, err
+	}
+	return hidden, nil
+}
+
+// GetCommentsByPostID returns a page of comments on the post. Comments of users the viewer
+// has blocked, or was blocked by, are left out; anonymous viewers have a nil viewerID.
+func (s *CommentService) GetCommentsByPostID(ctx context.Context, viewerID, postID primitive.ObjectID, page int64, pageSize int64) ([]*models.Comment, error) {
+	var hidden []primitive.ObjectID
+	if !viewerID.IsZero() {
+		var err error
+		hidden, err = s.blocks.GetHiddenUserIDs(ctx, viewerID)
+		if err != nil {
+			s.logger.Println("Error fetching blocked users:", err)
+			return nil, err
+		}
+	}
+
+	comments, err := s.storage.GetCommentsByPostID(ctx, postID, hidden, page, pageSize)
 	if err != nil {
 		s.logger.Println("Error fetching comments:", err)
 		return nil, err
@@ -133,15 +167,13 @@ func (s *CommentService) GetCommentsByPostID(ctx context.Context, postID primiti
 			} else {
 				return nil, err
 			}
-		}
-		if owner.HiddenProfile {
+		} else if owner.IsHidden() {
 			comment.UserID = primitive.NilObjectID
 			comment.OwnerFullname = "Anonim user"
 		} else {
 			comment.OwnerFullname = owner.Fullname
-			if len(owner.ProfilePics) > 0 {
-				comment.OwnerProfilePic = owner.ProfilePics[0].Url
-			}
+			comment.OwnerProfilePic = owner.PublicProfilePic()
+			comment.OwnerVerified = owner.Verified
 		}
 		if len(comment.VoiceMessage) > 0 {
 			if err := s.fetchVoiceMessage(comment); err != nil {
@@ -215,8 +247,8 @@ func (s *CommentService) GetCommentByID(ctx context.Context, commentID primitive
 		return nil, err
 	}
 
-	// If the user's profile is private, clear the UserID
-	if user.HiddenProfile {
+	// If the user's profile is private or deactivated, clear the UserID
+	if user.IsHidden() {
 		comment.OwnerFullname = "Anonim user"
 		comment.UserID = primitive.NilObjectID // Set to zero value to hide it
 	}
@@ -241,3 +273,21 @@ func (s *CommentService) fetchPictures(comment *models.Comment) (err error) {
 	}
 	return nil
 }
+
+// notifyPostCreator tells the creator of the post about a new comment on it, unless they wrote it
+func (s *CommentService) notifyPostCreator(ctx context.Context, comment *models.Comment) {
+	post, err := s.posts.GetPost(ctx, comment.PostID)
+	if err != nil {
+		s.logger.Println("Error loading commented post:", err)
+		return
+	}
+	if post.CreatorId == comment.UserID {
+		return
+	}
+
+	s.notifier.Notify(ctx, post.CreatorId, models.NotifyComment, map[string]any{
+		"post_id":    post.ID.Hex(),
+		"comment_id": comment.ID.Hex(),
+		"user_id":    comment.UserID.Hex(),
+	})
+}
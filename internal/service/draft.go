@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxDrafts = 100 // see dto.ErrTooManyDrafts
+
+	draftLease        = 2 * time.Minute
+	draftPollInterval = 15 * time.Second
+	draftRetryDelay   = time.Minute
+	draftMaxAttempts  = 5
+)
+
+// DraftService keeps the drafts of creators and publishes them when they ask to
+type DraftService struct {
+	drafts       *storage.DraftStorage
+	posts        repos.IPostService
+	file_service repos.IFIleStoreService
+	logger       *log.Logger
+}
+
+// NewDraftService initializes DraftService
+func NewDraftService(drafts *storage.DraftStorage, posts repos.IPostService, file_service repos.IFIleStoreService, logger *log.Logger) repos.IDraftService {
+	return &DraftService{drafts: drafts, posts: posts, file_service: file_service, logger: logger}
+}
+
+// CreateDraft stores a new draft of the user, scheduled if it has a publish time
+func (s *DraftService) CreateDraft(ctx context.Context, userID primitive.ObjectID, request *dto.DraftRequest) (*models.Draft, error) {
+	if err := request.Validate(s.posts.MaxTTLHours()); err != nil {
+		return nil, err
+	}
+
+	count, err := s.drafts.CountDrafts(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error counting drafts of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+	if count >= maxDrafts {
+		return nil, dto.ErrTooManyDrafts
+	}
+
+	draft := request.ToDraft()
+	draft.CreatorId = userID
+	if err := s.file_service.CheckOwner(ctx, userID, draft.Pictures...); err != nil {
+		return nil, err
+	}
+	if err := s.drafts.CreateDraft(ctx, draft); err != nil {
+		s.logger.Printf("Error creating draft of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return draft, nil
+}
+
+// GetDrafts returns the user's drafts, newest first
+func (s *DraftService) GetDrafts(ctx context.Context, userID primitive.ObjectID) ([]*models.Draft, error) {
+	drafts, err := s.drafts.GetDraftsByUser(ctx, userID, maxDrafts)
+	if err != nil {
+		s.logger.Printf("Error fetching drafts of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return drafts, nil
+}
+
+// GetDraft returns a draft of the user
+func (s *DraftService) GetDraft(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Draft, error) {
+	draft, err := s.drafts.GetDraft(ctx, userID, draftID)
+	if err != nil {
+		if !errors.Is(err, dto.ErrDraftNotFound) {
+			s.logger.Printf("Error fetching draft %s: %v\n", draftID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	return draft, nil
+}
+
+// UpdateDraft replaces the content and schedule of a draft of the user. Without a publish time a
+// scheduled draft goes back to being a plain draft.
+func (s *DraftService) UpdateDraft(ctx context.Context, userID, draftID primitive.ObjectID, request *dto.DraftRequest) (*models.Draft, error) {
+	if err := request.Validate(s.posts.MaxTTLHours()); err != nil {
+		return nil, err
+	}
+
+	draft := request.ToDraft()
+	draft.ID = draftID
+	draft.CreatorId = userID
+	if err := s.file_service.CheckOwner(ctx, userID, draft.Pictures...); err != nil {
+		return nil, err
+	}
+	if err := s.drafts.UpdateDraft(ctx, draft); err != nil {
+		if !errors.Is(err, dto.ErrDraftNotFound) && !errors.Is(err, dto.ErrDraftPublishing) {
+			s.logger.Printf("Error updating draft %s: %v\n", draftID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	return s.GetDraft(ctx, userID, draftID)
+}
+
+// CancelSchedule keeps a scheduled draft from being published, it stays a draft
+func (s *DraftService) CancelSchedule(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Draft, error) {
+	if err := s.drafts.UnscheduleDraft(ctx, userID, draftID); err != nil {
+		if !errors.Is(err, dto.ErrDraftNotFound) && !errors.Is(err, dto.ErrDraftPublishing) && !errors.Is(err, dto.ErrDraftNotScheduled) {
+			s.logger.Printf("Error unscheduling draft %s: %v\n", draftID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	return s.GetDraft(ctx, userID, draftID)
+}
+
+// PublishDraft publishes a draft of the user right away, its lifetime starts counting now
+func (s *DraftService) PublishDraft(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Post, error) {
+	draft, err := s.GetDraft(ctx, userID, draftID)
+	if err != nil {
+		return nil, err
+	}
+	if draft.Description == "" {
+		return nil, dto.ErrDescriptionRequired
+	}
+
+	draft, err = s.drafts.ClaimDraft(ctx, userID, draftID, draftLease)
+	if err != nil {
+		if !errors.Is(err, dto.ErrDraftNotFound) && !errors.Is(err, dto.ErrDraftPublishing) {
+			s.logger.Printf("Error claiming draft %s: %v\n", draftID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	post, err := publishDraft(ctx, s.drafts, s.posts, draft)
+	if err != nil {
+		s.logger.Printf("Error publishing draft %s: %v\n", draftID.Hex(), err)
+		// release it right away, the creator can try again
+		if releaseErr := s.drafts.RetryDraft(ctx, draftID, err, time.Now()); releaseErr != nil {
+			s.logger.Printf("Error releasing draft %s: %v\n", draftID.Hex(), releaseErr)
+		}
+		return nil, err
+	}
+
+	s.logger.Printf("User %s published draft %s\n", userID.Hex(), draftID.Hex())
+	return post, nil
+}
+
+// DeleteDraft discards a draft of the user with its pictures
+func (s *DraftService) DeleteDraft(ctx context.Context, userID, draftID primitive.ObjectID) error {
+	draft, err := s.GetDraft(ctx, userID, draftID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.drafts.DeleteDraft(ctx, userID, draftID); err != nil {
+		if !errors.Is(err, dto.ErrDraftNotFound) && !errors.Is(err, dto.ErrDraftPublishing) {
+			s.logger.Printf("Error deleting draft %s: %v\n", draftID.Hex(), err)
+		}
+		return err
+	}
+
+	// the draft is gone either way, a picture left behind only takes space
+	for _, picture := range draft.Pictures {
+		if err := s.file_service.DeleteOwnedFile(ctx, userID, picture); err != nil {
+			s.logger.Printf("Error deleting picture %s of draft %s: %v\n", picture, draftID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// publishDraft creates the post of a claimed draft and removes the draft. The post keeps the
+// draft's ID, so when an earlier attempt created it but died before removing the draft, the
+// duplicate key tells it is published already.
+func publishDraft(ctx context.Context, drafts *storage.DraftStorage, posts repos.IPostService, draft *models.Draft) (*models.Post, error) {
+	post := draft.ToPost()
+	// the longest lifetime may have been lowered since the draft was saved
+	deleteAfter := min(draft.DeleteAfter, posts.MaxTTLHours())
+
+	if err := posts.CreatePost(ctx, post, deleteAfter); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to create post: %v", err)
+		}
+		if post, err = posts.GetPost(ctx, draft.ID); err != nil {
+			return nil, fmt.Errorf("failed to load published post: %v", err)
+		}
+	}
+
+	if err := drafts.DeletePublishedDraft(ctx, draft.ID); err != nil {
+		return nil, fmt.Errorf("failed to remove published draft: %v", err)
+	}
+
+	return post, nil
+}
+
+// DraftScheduler publishes scheduled drafts once they are due. Any number of app instances can
+// run one: a draft is leased while it is published, and a lease left by a crashed instance runs
+// out so another one picks the draft up again.
+type DraftScheduler struct {
+	drafts   *storage.DraftStorage
+	posts    repos.IPostService
+	notifier *Notifier
+	logger   *log.Logger
+}
+
+// NewDraftScheduler initializes DraftScheduler
+func NewDraftScheduler(drafts *storage.DraftStorage, posts repos.IPostService, notifier *Notifier, logger *log.Logger) *DraftScheduler {
+	return &DraftScheduler{drafts: drafts, posts: posts, notifier: notifier, logger: logger}
+}
+
+// Run publishes due drafts until ctx is cancelled
+func (s *DraftScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(draftPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Publish everything due before waiting for the next tick
+		for s.publishNext(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishNext claims and publishes a single due draft, reporting whether there was one
+func (s *DraftScheduler) publishNext(ctx context.Context) bool {
+	draft, err := s.drafts.ClaimDueDraft(ctx, draftLease)
+	if err != nil {
+		if !errors.Is(err, dto.ErrDraftNotFound) {
+			s.logger.Println("Failed to claim scheduled draft:", err)
+		}
+		return false
+	}
+
+	post, err := publishDraft(ctx, s.drafts, s.posts, draft)
+	if err != nil {
+		s.logger.Printf("Failed to publish scheduled draft %s (attempt %d): %v\n", draft.ID.Hex(), draft.Attempts, err)
+
+		if draft.Attempts >= draftMaxAttempts {
+			err = s.drafts.FailDraft(ctx, draft.ID, err)
+		} else {
+			err = s.drafts.RetryDraft(ctx, draft.ID, err, time.Now().Add(draftRetryDelay))
+		}
+		if err != nil {
+			s.logger.Printf("Failed to record error of draft %s: %v\n", draft.ID.Hex(), err)
+		}
+		return true
+	}
+
+	s.notify(ctx, draft.CreatorId, post)
+	s.logger.Printf("Published scheduled draft %s\n", draft.ID.Hex())
+	return true
+}
+
+// notify tells the creator their scheduled post is out
+func (s *DraftScheduler) notify(ctx context.Context, userID primitive.ObjectID, post *models.Post) {
+	s.notifier.Notify(ctx, userID, models.NotifyPostPublished, map[string]any{
+		"post_id": post.ID.Hex(),
+		"title":   post.Title,
+	})
+}
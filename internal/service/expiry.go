@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+)
+
+// ExpirySweeper expires posts: it warns their creator and the subscribers of their comments ahead of
+// time, optionally archives them, and deletes them with everything attached to them. A TTL index would
+// delete the post alone and leave its comments, likes, pins and pictures behind.
+type ExpirySweeper struct {
+	posts    *storage.Storage
+	comments *storage.CommentStorage
+	cleanup  *Cleanup
+	archiver repos.PostArchiver // nil to delete without archiving
+	notifier *Notifier
+	redis    *redis.Client
+	logger   *log.Logger
+}
+
+// NewExpirySweeper initializes ExpirySweeper, archiver may be nil
+func NewExpirySweeper(
+	posts *storage.Storage,
+	comments *storage.CommentStorage,
+	cleanup *Cleanup,
+	archiver repos.PostArchiver,
+	notifier *Notifier,
+	redis *redis.Client,
+	logger *log.Logger,
+) *ExpirySweeper {
+	return &ExpirySweeper{
+		posts:    posts,
+		comments: comments,
+		cleanup:  cleanup,
+		archiver: archiver,
+		notifier: notifier,
+		redis:    redis,
+		logger:   logger,
+	}
+}
+
+// Run warns about posts expiring within warning and deletes the expired ones, checking every
+// interval until ctx is cancelled. Several instances may run it: each warning is claimed by one
+// of them, and archiving and deleting are idempotent.
+func (s *ExpirySweeper) Run(ctx context.Context, warning, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.warnExpiring(ctx, warning)
+		s.deleteExpired(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ExpirySweeper) warnExpiring(ctx context.Context, warning time.Duration) {
+	for {
+		posts, err := s.posts.GetPostsToWarn(ctx, time.Now().Add(warning), cleanupBatchSize)
+		if err != nil {
+			s.logger.Println("Failed to look up expiring posts:", err)
+			return
+		}
+
+		for _, post := range posts {
+			claimed, err := s.posts.MarkExpiryNotified(ctx, post)
+			if err != nil {
+				s.logger.Printf("Failed to mark post %s as warned: %v\n", post.ID.Hex(), err)
+				return
+			}
+			if !claimed {
+				continue
+			}
+
+			event := map[string]any{
+				"action":    models.NotifyPostExpiring,
+				"post_id":   post.ID.Hex(),
+				"title":     post.Title,
+				"delete_at": post.DeleteAt,
+				"timestamp": time.Now(),
+			}
+			s.publish(ctx, "comments:"+post.ID.Hex(), event)
+			s.notifier.Notify(ctx, post.CreatorId, models.NotifyPostExpiring, event)
+		}
+
+		if len(posts) < cleanupBatchSize {
+			return
+		}
+	}
+}
+
+func (s *ExpirySweeper) deleteExpired(ctx context.Context) {
+	for {
+		posts, err := s.posts.GetExpiredPosts(ctx, time.Now(), cleanupBatchSize)
+		if err != nil {
+			s.logger.Println("Failed to look up expired posts:", err)
+			return
+		}
+
+		for _, post := range posts {
+			// a post that cannot be archived or deleted now stays and is tried again next sweep,
+			// it would come first in every batch so the sweep stops here
+			if err := s.expire(ctx, post); err != nil {
+				s.logger.Printf("Failed to expire post %s: %v\n", post.ID.Hex(), err)
+				return
+			}
+		}
+
+		if len(posts) < cleanupBatchSize {
+			return
+		}
+	}
+}
+
+// expire archives the post if configured and deletes it with its comments, likes, pins and pictures.
+// An archived post keeps its pictures and the media of its comments.
+func (s *ExpirySweeper) expire(ctx context.Context, post *models.Post) error {
+	if s.archiver != nil {
+		archive := &models.ArchivedPost{Post: post, Comments: []*models.Comment{}, ArchivedAt: time.Now()}
+		err := s.comments.ForEachCommentByPost(ctx, post.ID, func(comment *models.Comment) error {
+			archive.Comments = append(archive.Comments, comment)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to load comments: %v", err)
+		}
+
+		if err := s.archiver.ArchivePost(ctx, archive); err != nil {
+			return fmt.Errorf("failed to archive: %v", err)
+		}
+	}
+
+	purge := s.cleanup.PurgePost
+	if s.archiver != nil {
+		purge = s.cleanup.PurgeArchivedPost
+	}
+	if err := purge(ctx, post); err != nil {
+		return err
+	}
+
+	s.publish(ctx, "comments:"+post.ID.Hex(), map[string]any{
+		"action":    "expired",
+		"post_id":   post.ID.Hex(),
+		"timestamp": time.Now(),
+	})
+
+	s.logger.Printf("Post %s expired\n", post.ID.Hex())
+	return nil
+}
+
+func (s *ExpirySweeper) publish(ctx context.Context, channel string, event map[string]any) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Println("Error marshaling expiry event:", err)
+		return
+	}
+
+	if err := s.redis.Publish(ctx, channel, string(message)).Err(); err != nil {
+		s.logger.Printf("Error publishing expiry event on %s: %v\n", channel, err)
+	}
+}
@@ -0,0 +1,429 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Exporter gathers everything stored about a user into a ZIP archive: JSON files for every
+// collection plus the original media under media/
+type Exporter struct {
+	users         *storage.UserStorage
+	sessions      *storage.SessionStorage
+	posts         *storage.Storage
+	comments      *storage.CommentStorage
+	likes         *storage.LikesStorage
+	pins          *storage.PinnedChat
+	chats         *storage.ChatStorage
+	follows       *storage.FollowStorage
+	blocks        *storage.BlockStorage
+	apiKeys       *storage.APIKeyStorage
+	verifications *storage.VerificationStorage
+	drafts        *storage.DraftStorage
+	revisions     *storage.PostRevisionStorage
+	archiver      repos.PostArchiver // nil when expired posts are not archived
+	files         *storage.FileStorage
+	logger        *log.Logger
+}
+
+// NewExporter initializes Exporter
+func NewExporter(
+	users *storage.UserStorage,
+	sessions *storage.SessionStorage,
+	posts *storage.Storage,
+	comments *storage.CommentStorage,
+	likes *storage.LikesStorage,
+	pins *storage.PinnedChat,
+	chats *storage.ChatStorage,
+	follows *storage.FollowStorage,
+	blocks *storage.BlockStorage,
+	apiKeys *storage.APIKeyStorage,
+	verifications *storage.VerificationStorage,
+	drafts *storage.DraftStorage,
+	revisions *storage.PostRevisionStorage,
+	archiver repos.PostArchiver,
+	files *storage.FileStorage,
+	logger *log.Logger,
+) *Exporter {
+	return &Exporter{
+		users:         users,
+		sessions:      sessions,
+		posts:         posts,
+		comments:      comments,
+		likes:         likes,
+		pins:          pins,
+		chats:         chats,
+		follows:       follows,
+		blocks:        blocks,
+		apiKeys:       apiKeys,
+		verifications: verifications,
+		drafts:        drafts,
+		revisions:     revisions,
+		archiver:      archiver,
+		files:         files,
+		logger:        logger,
+	}
+}
+
+// exportManifest describes the archive, it is written last as manifest.json
+type exportManifest struct {
+	UserID       primitive.ObjectID `json:"user_id"`
+	CreatedAt    time.Time          `json:"created_at"`
+	Files        []string           `json:"files"`
+	MissingMedia []string           `json:"missing_media"` // referenced but no longer in storage
+	OthersMedia  []string           `json:"others_media"`  // referenced but uploaded by someone else, so not copied
+}
+
+// export is the state of a single archive being built
+type export struct {
+	zip      *zip.Writer
+	manifest exportManifest
+	media    map[string]bool // every file referenced so far, to fetch each one once
+	order    []string
+}
+
+// Export builds the archive of the user in a temporary file and uploads it as objectName
+func (e *Exporter) Export(ctx context.Context, userID primitive.ObjectID, objectName string, progress JobProgress) error {
+	user, err := e.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %v", err)
+	}
+
+	tmp, err := os.CreateTemp("", "soand-export-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	ex := &export{
+		zip:      zip.NewWriter(tmp),
+		manifest: exportManifest{UserID: userID, CreatedAt: time.Now(), MissingMedia: []string{}, OthersMedia: []string{}},
+		media:    map[string]bool{},
+	}
+
+	steps := []struct {
+		name string
+		run  func(context.Context, *export) error
+	}{
+		{"user", func(ctx context.Context, ex *export) error { return e.exportUser(ex, user) }},
+		{"sessions", func(ctx context.Context, ex *export) error { return e.exportSessions(ctx, ex, userID) }},
+		{"posts", func(ctx context.Context, ex *export) error { return e.exportPosts(ctx, ex, userID) }},
+		{"post_revisions", func(ctx context.Context, ex *export) error { return e.exportPostRevisions(ctx, ex, userID) }},
+		{"drafts", func(ctx context.Context, ex *export) error { return e.exportDrafts(ctx, ex, userID) }},
+		{"comments", func(ctx context.Context, ex *export) error { return e.exportComments(ctx, ex, userID) }},
+		{"archives", func(ctx context.Context, ex *export) error { return e.exportArchives(ctx, ex, userID) }},
+		{"messages", func(ctx context.Context, ex *export) error { return e.exportMessages(ctx, ex, userID) }},
+		{"likes", func(ctx context.Context, ex *export) error { return e.exportLikes(ctx, ex, userID) }},
+		{"pinned_chats", func(ctx context.Context, ex *export) error { return e.exportPinnedChats(ctx, ex, userID) }},
+		{"follows", func(ctx context.Context, ex *export) error { return e.exportFollows(ctx, ex, userID) }},
+		{"blocks", func(ctx context.Context, ex *export) error { return e.exportBlocks(ctx, ex, userID) }},
+		{"api_keys", func(ctx context.Context, ex *export) error { return e.exportAPIKeys(ctx, ex, userID) }},
+		{"verification_requests", func(ctx context.Context, ex *export) error { return e.exportVerificationRequests(ctx, ex, userID) }},
+		{"media", e.exportMedia},
+	}
+
+	// one more step for the upload
+	total := len(steps) + 1
+	for i, step := range steps {
+		if err := progress(step.name, i, total); err != nil {
+			return fmt.Errorf("failed to report progress: %v", err)
+		}
+
+		if err := step.run(ctx, ex); err != nil {
+			return fmt.Errorf("%s: %v", step.name, err)
+		}
+	}
+
+	if err := ex.writeJSON("manifest.json", ex.manifest); err != nil {
+		return err
+	}
+	if err := ex.zip.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %v", err)
+	}
+
+	if err := progress("upload", len(steps), total); err != nil {
+		return fmt.Errorf("failed to report progress: %v", err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	if err := e.files.UploadNamedFile(ctx, objectName, tmp, size, "application/zip"); err != nil {
+		return err
+	}
+
+	return progress("", total, total)
+}
+
+func (e *Exporter) exportUser(ex *export, user *models.User) error {
+	for _, pic := range user.ProfilePics {
+		ex.addMedia(pic.Url)
+	}
+	ex.addMedia(user.BackgroundPic)
+
+	if err := ex.writeJSON("user.json", user); err != nil {
+		return err
+	}
+	return ex.writeJSON("settings.json", user.GetSettings())
+}
+
+func (e *Exporter) exportSessions(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	sessions, err := e.sessions.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return ex.writeJSON("sessions.json", sessions)
+}
+
+func (e *Exporter) exportPosts(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	return ex.writeJSONArray("posts.json", func(write func(any) error) error {
+		return e.posts.ForEachPostByCreator(ctx, userID, func(post *models.Post) error {
+			ex.addMedia(post.Pictures...)
+			return write(post)
+		})
+	})
+}
+
+func (e *Exporter) exportPostRevisions(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	return ex.writeJSONArray("post_revisions.json", func(write func(any) error) error {
+		return e.revisions.ForEachRevisionByCreator(ctx, userID, func(revision *models.PostRevision) error {
+			ex.addMedia(revision.Pictures...)
+			return write(revision)
+		})
+	})
+}
+
+func (e *Exporter) exportDrafts(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	drafts, err := e.drafts.GetDraftsByUser(ctx, userID, 0)
+	if err != nil {
+		return err
+	}
+	for _, draft := range drafts {
+		ex.addMedia(draft.Pictures...)
+	}
+	return ex.writeJSON("drafts.json", drafts)
+}
+
+func (e *Exporter) exportComments(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	return ex.writeJSONArray("comments.json", func(write func(any) error) error {
+		return e.comments.ForEachCommentByUser(ctx, userID, func(comment *models.Comment) error {
+			ex.addMedia(comment.Pictures...)
+			ex.addMedia(comment.VoiceMessage)
+			return write(comment)
+		})
+	})
+}
+
+// exportArchives writes the user's expired posts and their comments on expired posts, with the media
+// the archives kept
+func (e *Exporter) exportArchives(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	if e.archiver == nil {
+		return nil
+	}
+
+	err := ex.writeJSONArray("archived_posts.json", func(write func(any) error) error {
+		return e.archiver.ForEachPostByCreator(ctx, userID, func(archive *models.ArchivedPost) error {
+			ex.addMedia(archive.Post.Pictures...)
+			return write(archive)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return ex.writeJSONArray("archived_comments.json", func(write func(any) error) error {
+		return e.archiver.ForEachCommentByUser(ctx, userID, func(comment *models.Comment) error {
+			ex.addMedia(comment.Pictures...)
+			ex.addMedia(comment.VoiceMessage)
+			return write(comment)
+		})
+	})
+}
+
+func (e *Exporter) exportMessages(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	return ex.writeJSONArray("messages.json", func(write func(any) error) error {
+		return e.chats.ForEachMessageOfUser(ctx, userID, func(message *models.Message) error {
+			ex.addMedia(message.Pictures...)
+			return write(message)
+		})
+	})
+}
+
+func (e *Exporter) exportLikes(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	postIDs, err := e.likes.GetLikedPostIDs(ctx, userID, 0)
+	if err != nil {
+		return err
+	}
+
+	likes := make([]map[string]primitive.ObjectID, len(postIDs))
+	for i, postID := range postIDs {
+		likes[i] = map[string]primitive.ObjectID{"post_id": postID}
+	}
+	return ex.writeJSON("likes.json", likes)
+}
+
+func (e *Exporter) exportPinnedChats(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	pins, err := e.pins.GetAllPinnedChatsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return ex.writeJSON("pinned_chats.json", pins)
+}
+
+func (e *Exporter) exportFollows(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	follows, err := e.follows.GetFollowsOfUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return ex.writeJSON("follows.json", follows)
+}
+
+func (e *Exporter) exportBlocks(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	blocks, err := e.blocks.GetBlocksByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return ex.writeJSON("blocks.json", blocks)
+}
+
+func (e *Exporter) exportAPIKeys(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	keys, err := e.apiKeys.GetKeysByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return ex.writeJSON("api_keys.json", keys)
+}
+
+func (e *Exporter) exportVerificationRequests(ctx context.Context, ex *export, userID primitive.ObjectID) error {
+	requests, err := e.verifications.GetRequestsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return ex.writeJSON("verification_requests.json", requests)
+}
+
+// exportMedia copies every referenced file the user uploaded into media/, files that are gone or
+// were uploaded by others are listed in the manifest
+func (e *Exporter) exportMedia(ctx context.Context, ex *export) error {
+	for _, filename := range ex.order {
+		owner, err := e.files.FileOwner(ctx, filename)
+		// files without a recorded uploader predate recording them, only the user's documents refer to them
+		if err == nil && !owner.IsZero() && owner != ex.manifest.UserID {
+			ex.manifest.OthersMedia = append(ex.manifest.OthersMedia, filename)
+			continue
+		}
+
+		reader, err := e.files.ReadFile(ctx, filename)
+		if err != nil {
+			e.logger.Printf("Export of user %s: %v\n", ex.manifest.UserID.Hex(), err)
+			ex.manifest.MissingMedia = append(ex.manifest.MissingMedia, filename)
+			continue
+		}
+
+		err = ex.writeFile(path.Join("media", path.Base(filename)), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *export) addMedia(filenames ...string) {
+	for _, filename := range filenames {
+		if filename == "" || ex.media[filename] {
+			continue
+		}
+		ex.media[filename] = true
+		ex.order = append(ex.order, filename)
+	}
+}
+
+func (ex *export) create(name string) (io.Writer, error) {
+	w, err := ex.zip.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s to archive: %v", name, err)
+	}
+	ex.manifest.Files = append(ex.manifest.Files, name)
+	return w, nil
+}
+
+func (ex *export) writeFile(name string, r io.Reader) error {
+	w, err := ex.create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func (ex *export) writeJSON(name string, v any) error {
+	w, err := ex.create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// writeJSONArray writes a JSON array item by item, so large collections never sit in memory at once
+func (ex *export) writeJSONArray(name string, items func(write func(any) error) error) error {
+	w, err := ex.create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err = items(func(item any) error {
+		data, err := json.MarshalIndent(item, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		separator := ",\n  "
+		if first {
+			separator = "\n  "
+			first = false
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
@@ -1,12 +1,17 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log"
 	"mime/multipart"
+	"path"
+	"strings"
 
 	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type (
@@ -23,8 +28,9 @@ func NewFileStoreService(storage *storage.FileStorage, logger *log.Logger) repos
 	}
 }
 
-func (s *FileStoreService) UploadFile(file *multipart.FileHeader) (*dto.FileObject, error) {
-	path, err := s.storage.UploadFile(file)
+// UploadFile stores a file uploaded by ownerID, who alone can attach it to posts, drafts and comments
+func (s *FileStoreService) UploadFile(file *multipart.FileHeader, ownerID primitive.ObjectID) (*dto.FileObject, error) {
+	path, err := s.storage.UploadFile(file, ownerID)
 	if err != nil {
 		s.logger.Println("Error uploading file:", err)
 		return nil, err
@@ -48,14 +54,61 @@ func (s *FileStoreService) UploadFileFromBytes(data []byte, contentType string)
 	return response, nil
 }
 
+// privatePrefixes are where files only the server hands out are kept, GetFile never links to them
+var privatePrefixes = []string{ExportsPrefix, storage.PostArchivesPrefix}
+
+// errPrivateFile is returned by GetFile for a file under one of the privatePrefixes
+var errPrivateFile = errors.New("file not found")
+
 func (s *FileStoreService) GetFile(fileID string) (string, error) {
-	path, err := s.storage.GetFile(fileID)
+	// the bucket drops leading and doubled slashes, so compare the name it would resolve to
+	name := strings.TrimPrefix(path.Clean("/"+fileID), "/")
+	for _, prefix := range privatePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			s.logger.Println("Refused link to private file:", fileID)
+			return "", errPrivateFile
+		}
+	}
+
+	url, err := s.storage.GetFile(fileID)
 	if err != nil {
 		s.logger.Println("Error retrieving file:", err)
 		return "", err
 	}
-	s.logger.Println("File retrieved successfully:", path)
-	return path, nil
+	s.logger.Println("File retrieved successfully:", url)
+	return url, nil
+}
+
+// CheckOwner returns dto.ErrFileNotOwned unless ownerID uploaded every one of the files
+func (s *FileStoreService) CheckOwner(ctx context.Context, ownerID primitive.ObjectID, filenames ...string) error {
+	for _, filename := range filenames {
+		if filename == "" {
+			continue
+		}
+		owner, err := s.storage.FileOwner(ctx, filename)
+		if errors.Is(err, storage.ErrFileNotFound) {
+			return dto.ErrFileNotOwned
+		}
+		if err != nil {
+			s.logger.Println("Error checking file owner:", err)
+			return err
+		}
+		if owner.IsZero() || owner != ownerID {
+			s.logger.Printf("User %s refused to attach file %s they did not upload\n", ownerID.Hex(), filename)
+			return dto.ErrFileNotOwned
+		}
+	}
+	return nil
+}
+
+// DeleteOwnedFile deletes the file if ownerID uploaded it, and leaves the files of others alone. The
+// caller must know the file belongs to a document of ownerID, files without a recorded uploader go too.
+func (s *FileStoreService) DeleteOwnedFile(ctx context.Context, ownerID primitive.ObjectID, filename string) error {
+	if err := s.storage.RemoveOwnedFile(ctx, filename, ownerID); err != nil {
+		s.logger.Println("Error deleting file:", err)
+		return err
+	}
+	return nil
 }
 
 func (s *FileStoreService) DeleteFile(fileID string) error {
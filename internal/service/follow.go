@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FollowService handles the follow graph between users. Following a private account
+// (one with a hidden profile) creates a request its owner has to accept.
+type FollowService struct {
+	follows  *storage.FollowStorage
+	blocks   *storage.BlockStorage
+	users    *storage.UserStorage
+	notifier *Notifier
+	logger   *log.Logger
+}
+
+// NewFollowService initializes FollowService
+func NewFollowService(follows *storage.FollowStorage, blocks *storage.BlockStorage, users *storage.UserStorage, notifier *Notifier, logger *log.Logger) repos.IFollowService {
+	return &FollowService{follows: follows, blocks: blocks, users: users, notifier: notifier, logger: logger}
+}
+
+// Follow follows the user, or requests to if their account is private, and returns the resulting status
+func (s *FollowService) Follow(ctx context.Context, followerID, followeeID primitive.ObjectID) (string, error) {
+	if followerID == followeeID {
+		return "", dto.ErrSelfFollow
+	}
+
+	followee, err := s.getActiveUser(ctx, followeeID)
+	if err != nil {
+		return "", err
+	}
+
+	// users blocked in either direction cannot follow each other
+	blocked, err := s.blocks.IsBlockedEitherWay(ctx, followerID, followeeID)
+	if err != nil {
+		return "", err
+	}
+	if blocked {
+		return "", dto.ErrUserNotFound
+	}
+
+	status := models.FollowAccepted
+	if followee.HiddenProfile {
+		status = models.FollowPending
+	}
+
+	status, created, err := s.follows.Follow(ctx, followerID, followeeID, status)
+	if err != nil {
+		s.logger.Printf("Error following user %s by %s: %v\n", followeeID.Hex(), followerID.Hex(), err)
+		return "", err
+	}
+
+	if created {
+		kind := models.NotifyNewFollower
+		if status == models.FollowPending {
+			kind = models.NotifyFollowRequest
+		}
+		s.notifier.Notify(ctx, followeeID, kind, map[string]any{"user_id": followerID.Hex()})
+	}
+
+	return status, nil
+}
+
+// Unfollow stops following the user, or withdraws a pending request
+func (s *FollowService) Unfollow(ctx context.Context, followerID, followeeID primitive.ObjectID) error {
+	removed, err := s.follows.Unfollow(ctx, followerID, followeeID)
+	if err != nil {
+		s.logger.Printf("Error unfollowing user %s by %s: %v\n", followeeID.Hex(), followerID.Hex(), err)
+		return err
+	}
+	if !removed {
+		return dto.ErrNotFollowing
+	}
+
+	return nil
+}
+
+// GetFollowers lists who follows the user. The lists of a private account are only
+// visible to its owner and accepted followers.
+func (s *FollowService) GetFollowers(ctx context.Context, viewerID, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	if err := s.checkVisible(ctx, viewerID, userID); err != nil {
+		return nil, err
+	}
+
+	users, err := s.follows.GetFollowers(ctx, userID, models.FollowAccepted, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error fetching followers of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// GetFollowing lists who the user follows, with the same visibility rules as GetFollowers
+func (s *FollowService) GetFollowing(ctx context.Context, viewerID, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	if err := s.checkVisible(ctx, viewerID, userID); err != nil {
+		return nil, err
+	}
+
+	users, err := s.follows.GetFollowing(ctx, userID, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error fetching followings of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// GetFollowRequests lists the pending requests to follow the user
+func (s *FollowService) GetFollowRequests(ctx context.Context, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	users, err := s.follows.GetFollowers(ctx, userID, models.FollowPending, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error fetching follow requests of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// AcceptFollowRequest lets the requester follow the user
+func (s *FollowService) AcceptFollowRequest(ctx context.Context, userID, followerID primitive.ObjectID) error {
+	if err := s.follows.AcceptRequest(ctx, userID, followerID); err != nil {
+		s.logger.Printf("Error accepting follow request of %s to %s: %v\n", followerID.Hex(), userID.Hex(), err)
+		return err
+	}
+
+	return nil
+}
+
+// RejectFollowRequest deletes the request; the requester may ask again later
+func (s *FollowService) RejectFollowRequest(ctx context.Context, userID, followerID primitive.ObjectID) error {
+	if err := s.follows.RejectRequest(ctx, userID, followerID); err != nil {
+		s.logger.Printf("Error rejecting follow request of %s to %s: %v\n", followerID.Hex(), userID.Hex(), err)
+		return err
+	}
+
+	return nil
+}
+
+// checkVisible makes sure the viewer may see who the user follows and is followed by.
+// Anonymous viewers have a nil viewerID.
+func (s *FollowService) checkVisible(ctx context.Context, viewerID, userID primitive.ObjectID) error {
+	user, err := s.getActiveUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if viewerID == userID {
+		return nil
+	}
+
+	if !viewerID.IsZero() {
+		// the lists of a user who blocked the viewer look like the user does not exist
+		blocked, err := s.blocks.HasBlocked(ctx, userID, viewerID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return dto.ErrUserNotFound
+		}
+	}
+
+	if !user.HiddenProfile {
+		return nil
+	}
+
+	if viewerID.IsZero() {
+		return dto.ErrPrivateAccount
+	}
+
+	status, err := s.follows.GetStatus(ctx, viewerID, userID)
+	if err != nil {
+		return err
+	}
+	if status != models.FollowAccepted {
+		return dto.ErrPrivateAccount
+	}
+
+	return nil
+}
+
+// getActiveUser fetches a user that is neither deactivated nor being deleted
+func (s *FollowService) getActiveUser(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DeactivatedAt != nil || user.DeletingAt != nil {
+		return nil, dto.ErrUserNotFound
+	}
+
+	return user, nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/storage"
+)
+
+const (
+	jobLease        = 2 * time.Minute
+	jobPollInterval = 5 * time.Second
+	jobMaxAttempts  = 5
+)
+
+// JobProgress reports the step a job is on; it also extends the job's lease
+type JobProgress func(step string, done, total int) error
+
+// JobHandler does the work of one job type. It returns the job's result, if any.
+// Jobs are delivered at least once, so handlers must be safe to run again after a crash.
+type JobHandler func(ctx context.Context, job *models.Job, progress JobProgress) (string, error)
+
+// JobRunner claims queued jobs from Mongo and runs them. Any number of app instances can
+// run one; the lease taken when claiming keeps them from working on the same job.
+type JobRunner struct {
+	storage  *storage.JobStorage
+	handlers map[string]JobHandler
+	logger   *log.Logger
+}
+
+// NewJobRunner initializes JobRunner
+func NewJobRunner(storage *storage.JobStorage, logger *log.Logger) *JobRunner {
+	return &JobRunner{
+		storage:  storage,
+		handlers: make(map[string]JobHandler),
+		logger:   logger,
+	}
+}
+
+// Handle registers the handler of a job type. Call it before Run.
+func (r *JobRunner) Handle(jobType string, handler JobHandler) {
+	r.handlers[jobType] = handler
+}
+
+// Run processes jobs until ctx is cancelled. Jobs left running by a crashed instance
+// are picked up again once their lease runs out.
+func (r *JobRunner) Run(ctx context.Context) {
+	jobTypes := make([]string, 0, len(r.handlers))
+	for jobType := range r.handlers {
+		jobTypes = append(jobTypes, jobType)
+	}
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before waiting for the next tick
+		for r.runNext(ctx, jobTypes) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext claims and runs a single job, reporting whether there was one
+func (r *JobRunner) runNext(ctx context.Context, jobTypes []string) bool {
+	job, err := r.storage.ClaimJob(ctx, jobTypes, jobLease)
+	if err != nil {
+		if !errors.Is(err, dto.ErrJobNotFound) {
+			r.logger.Println("Failed to claim job:", err)
+		}
+		return false
+	}
+
+	r.logger.Printf("Running %s job %s (attempt %d)\n", job.Type, job.ID.Hex(), job.Attempts)
+
+	progress := func(step string, done, total int) error {
+		return r.storage.UpdateProgress(ctx, job.ID, step, done, total, jobLease)
+	}
+
+	result, err := r.run(ctx, job, progress)
+	if err == nil {
+		if err := r.storage.CompleteJob(ctx, job.ID, result); err != nil {
+			r.logger.Printf("Failed to complete job %s: %v\n", job.ID.Hex(), err)
+		}
+		r.logger.Printf("Job %s completed\n", job.ID.Hex())
+		return true
+	}
+
+	r.logger.Printf("Job %s failed: %v\n", job.ID.Hex(), err)
+
+	if job.Attempts >= jobMaxAttempts {
+		if err := r.storage.FailJob(ctx, job.ID, err); err != nil {
+			r.logger.Printf("Failed to mark job %s as failed: %v\n", job.ID.Hex(), err)
+		}
+		return true
+	}
+
+	// Back off exponentially: 30s, 1m, 2m, 4m...
+	retryAt := time.Now().Add(time.Duration(1<<(job.Attempts-1)) * 30 * time.Second)
+	if err := r.storage.RetryJob(ctx, job.ID, err, retryAt); err != nil {
+		r.logger.Printf("Failed to reschedule job %s: %v\n", job.ID.Hex(), err)
+	}
+	return true
+}
+
+// run calls the job's handler, turning a panic into an error so one bad job can not stop the runner
+func (r *JobRunner) run(ctx context.Context, job *models.Job, progress JobProgress) (result string, err error) {
+	handler, ok := r.handlers[job.Type]
+	if !ok {
+		return "", fmt.Errorf("no handler for job type %s", job.Type)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+
+	return handler(ctx, job, progress)
+}
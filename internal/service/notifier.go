@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notifier pushes notifications to users over their NotificationChannel, which the chat
+// WebSocket forwards, honouring the notification settings of each user
+type Notifier struct {
+	users  *storage.UserStorage
+	redis  *redis.Client
+	logger *log.Logger
+}
+
+// NewNotifier initializes Notifier
+func NewNotifier(users *storage.UserStorage, redis *redis.Client, logger *log.Logger) *Notifier {
+	return &Notifier{users: users, redis: redis, logger: logger}
+}
+
+// Notify sends event to the user as a notification of the given kind, one of the models.Notify
+// constants, unless they turned that kind off. A notification that cannot be sent is only logged.
+func (n *Notifier) Notify(ctx context.Context, userID primitive.ObjectID, kind string, event map[string]any) {
+	user, err := n.users.GetUserByID(ctx, userID)
+	if err != nil {
+		n.logger.Printf("Error loading settings of user %s for %s notification: %v\n", userID.Hex(), kind, err)
+		return
+	}
+	if !user.GetSettings().Notifications.Allows(kind) {
+		return
+	}
+
+	event["action"] = kind
+	event["timestamp"] = time.Now()
+	message, err := json.Marshal(event)
+	if err != nil {
+		n.logger.Printf("Error marshaling %s notification: %v\n", kind, err)
+		return
+	}
+
+	if err := n.redis.Publish(ctx, models.NotificationChannel(userID), string(message)).Err(); err != nil {
+		n.logger.Printf("Error publishing %s notification to %s: %v\n", kind, userID.Hex(), err)
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/oidc"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OIDCService logs users in with OpenID Connect providers and links provider accounts to users
+type OIDCService struct {
+	providers map[string]*oidc.Provider
+	names     []string
+	states    *oidc.StateStore
+	users     *storage.UserStorage
+	logger    *log.Logger
+}
+
+// NewOIDCService initializes OIDCService
+func NewOIDCService(providers []*oidc.Provider, states *oidc.StateStore, users *storage.UserStorage, logger *log.Logger) repos.IOIDCService {
+	s := &OIDCService{
+		providers: map[string]*oidc.Provider{},
+		states:    states,
+		users:     users,
+		logger:    logger,
+	}
+	for _, provider := range providers {
+		s.providers[provider.Name()] = provider
+		s.names = append(s.names, provider.Name())
+	}
+	return s
+}
+
+// Providers returns the names of the configured providers
+func (s *OIDCService) Providers() []string {
+	return s.names
+}
+
+// StartLogin returns the URL to send the user to for logging in at provider, and the binding the
+// callback has to be given back by the same browser. With linkTo set, coming back links the provider
+// account to that user instead of logging in.
+func (s *OIDCService) StartLogin(ctx context.Context, provider string, linkTo primitive.ObjectID) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", dto.ErrUnknownProvider
+	}
+
+	pending := &oidc.Pending{Provider: provider}
+	if !linkTo.IsZero() {
+		pending.LinkTo = linkTo.Hex()
+	}
+
+	var state string
+	for _, token := range []*string{&state, &pending.Nonce, &pending.Verifier, &pending.Binding} {
+		var err error
+		if *token, err = oidc.RandomToken(); err != nil {
+			return "", "", err
+		}
+	}
+
+	url, err := p.AuthCodeURL(ctx, state, pending.Nonce, pending.Verifier)
+	if err != nil {
+		s.logger.Printf("Error starting %s login: %v\n", provider, err)
+		return "", "", dto.ErrExternalLoginFailed
+	}
+
+	if err := s.states.Save(ctx, state, pending); err != nil {
+		s.logger.Printf("Error saving %s login state: %v\n", provider, err)
+		return "", "", err
+	}
+
+	return url, pending.Binding, nil
+}
+
+// Callback finishes a login started by StartLogin with the code the provider sent the user back with.
+// binding has to be the one StartLogin returned, so a state cannot be finished in a victim's browser
+// to log them in as, or link them to, someone else's provider account.
+func (s *OIDCService) Callback(ctx context.Context, provider, code, state, binding string, meta *models.SessionMeta) (*models.ExternalLoginResult, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, dto.ErrUnknownProvider
+	}
+
+	pending, err := s.states.Take(ctx, state)
+	if errors.Is(err, oidc.ErrStateNotFound) {
+		return nil, dto.ErrInvalidOIDCState
+	}
+	if err != nil {
+		s.logger.Printf("Error loading %s login state: %v\n", provider, err)
+		return nil, err
+	}
+	if pending.Provider != provider {
+		return nil, dto.ErrInvalidOIDCState
+	}
+	if subtle.ConstantTimeCompare([]byte(pending.Binding), []byte(binding)) != 1 {
+		s.logger.Printf("A %s login state was used from another browser than it was started in\n", provider)
+		return nil, dto.ErrInvalidOIDCState
+	}
+
+	claims, err := p.Exchange(ctx, code, pending.Verifier, pending.Nonce)
+	if err != nil {
+		s.logger.Printf("Error finishing %s login: %v\n", provider, err)
+		return nil, dto.ErrExternalLoginFailed
+	}
+
+	identity := models.ExternalIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	if pending.LinkTo != "" {
+		userID, err := primitive.ObjectIDFromHex(pending.LinkTo)
+		if err != nil {
+			return nil, dto.ErrInvalidOIDCState
+		}
+
+		err = s.users.LinkIdentity(ctx, userID, identity)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, dto.ErrUserNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.logger.Printf("User %s linked their %s account\n", userID.Hex(), provider)
+		return &models.ExternalLoginResult{Linked: &identity}, nil
+	}
+
+	result, created, err := s.users.LoginWithIdentity(ctx, identity, claims.Name, usernameHint(claims), meta)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		s.logger.Printf("Created a user for a new %s account\n", provider)
+	}
+
+	return &models.ExternalLoginResult{LoginResult: result, NewUser: created}, nil
+}
+
+// Unlink removes the user's account at provider
+func (s *OIDCService) Unlink(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	err := s.users.UnlinkIdentity(ctx, userID, provider)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return dto.ErrUserNotFound
+	}
+	return err
+}
+
+// usernameHint picks what the username of a user created by an external login is made from
+func usernameHint(claims *oidc.Claims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+	if local, _, ok := strings.Cut(claims.Email, "@"); ok && local != "" {
+		return local
+	}
+	return claims.Name
+}
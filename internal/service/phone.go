@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/otp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RequestPhoneVerification texts a code to the phone number the user wants to add to their account
+func (s *UserService) RequestPhoneVerification(ctx context.Context, userID primitive.ObjectID, phone string) error {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	taken, err := s.storage.IsPhoneTaken(ctx, phone, userID)
+	if err != nil {
+		s.logger.Printf("Error checking phone availability: %v\n", err)
+		return err
+	}
+	if taken {
+		return dto.ErrPhoneTaken
+	}
+
+	// The number is only attached to the account once the code comes back, so it travels as the payload
+	if err := s.otp.Send(ctx, otp.PurposePhoneVerification, userID.Hex(), phone, phone); err != nil {
+		s.logger.Printf("Error sending phone verification code to user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("Phone verification code sent for user %s\n", userID.Hex())
+	return nil
+}
+
+// ConfirmPhoneVerification checks the code and marks the phone number it was sent to as verified
+func (s *UserService) ConfirmPhoneVerification(ctx context.Context, userID primitive.ObjectID, code string) error {
+	phone, err := s.otp.Verify(ctx, otp.PurposePhoneVerification, userID.Hex(), code)
+	if err != nil {
+		s.logger.Printf("Error verifying phone code for user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	if err := s.storage.SetVerifiedPhone(ctx, userID, phone); err != nil {
+		s.logger.Printf("Error setting verified phone for user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("Phone verified for user %s\n", userID.Hex())
+	return nil
+}
+
+// RequestPasswordReset texts a reset code to the phone if an account has verified it.
+// It behaves the same whether or not such an account exists, so it can not be used
+// to find out which numbers are registered.
+func (s *UserService) RequestPasswordReset(ctx context.Context, phone string) error {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.storage.GetUserByVerifiedPhone(ctx, phone)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.Printf("Error looking up user for password reset: %v\n", err)
+			return err
+		}
+
+		s.logger.Println("Password reset requested for an unknown phone number")
+		return s.otp.Throttle(ctx, otp.PurposePasswordReset, phone)
+	}
+
+	if err := s.otp.Send(ctx, otp.PurposePasswordReset, phone, phone, user.ID.Hex()); err != nil {
+		s.logger.Printf("Error sending password reset code to user %s: %v\n", user.ID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("Password reset code sent to user %s\n", user.ID.Hex())
+	return nil
+}
+
+// ResetPassword sets a new password once the reset code is verified, logging the user out everywhere
+func (s *UserService) ResetPassword(ctx context.Context, phone, code, newPassword string) error {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	// a password the policy refuses must not use up the code
+	if err := s.storage.CheckPasswordPolicy(newPassword); err != nil {
+		return err
+	}
+
+	userHex, err := s.otp.Verify(ctx, otp.PurposePasswordReset, phone, code)
+	if err != nil {
+		s.logger.Printf("Error verifying password reset code: %v\n", err)
+		return err
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userHex)
+	if err != nil {
+		return dto.ErrCodeExpired
+	}
+
+	if err := s.storage.ResetPassword(ctx, userID, newPassword); err != nil {
+		s.logger.Printf("Error resetting password for user %s: %v\n", userID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("Password reset for user %s\n", userID.Hex())
+	return nil
+}
+
+// normalizePhone strips formatting from a phone number and requires international
+// format, e.g. "+998 90 123-45-67" becomes "+998901234567"
+func normalizePhone(phone string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return "", fmt.Errorf("invalid phone number")
+		}
+	}
+
+	normalized := b.String()
+	if !strings.HasPrefix(normalized, "+") || len(normalized) < 9 || len(normalized) > 16 {
+		return "", fmt.Errorf("phone number must be in international format, e.g. +998901234567")
+	}
+
+	return normalized, nil
+}
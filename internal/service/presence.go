@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/presence"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PresenceService tracks users while they hold a WebSocket open, stores when they were last
+// seen and pushes presence changes to the direct message chats opened with them
+type PresenceService struct {
+	tracker   *presence.Tracker
+	users     *storage.UserStorage
+	blocks    *storage.BlockStorage
+	redis     *redis.Client
+	heartbeat time.Duration
+	logger    *log.Logger
+}
+
+// NewPresenceService initializes PresenceService, tracked connections are refreshed every heartbeat
+func NewPresenceService(
+	tracker *presence.Tracker,
+	users *storage.UserStorage,
+	blocks *storage.BlockStorage,
+	redis *redis.Client,
+	heartbeat time.Duration,
+	logger *log.Logger,
+) repos.IPresenceService {
+	return &PresenceService{
+		tracker:   tracker,
+		users:     users,
+		blocks:    blocks,
+		redis:     redis,
+		heartbeat: heartbeat,
+		logger:    logger,
+	}
+}
+
+// Track marks the user online for as long as ctx lives, sending heartbeats in the background.
+// The returned function ends tracking; call it when the connection closes.
+func (s *PresenceService) Track(ctx context.Context, userID primitive.ObjectID) func() {
+	connID, cameOnline, err := s.tracker.Connect(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Failed to track presence of user %s: %v\n", userID.Hex(), err)
+		return func() {}
+	}
+	if cameOnline {
+		s.publish(ctx, userID, true, nil)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(s.heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.tracker.Heartbeat(ctx, userID, connID); err != nil {
+					s.logger.Printf("Failed to refresh presence of user %s: %v\n", userID.Hex(), err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+
+		// the connection is gone, ctx may already be too
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		wentOffline, err := s.tracker.Disconnect(ctx, userID, connID)
+		if err != nil {
+			s.logger.Printf("Failed to untrack presence of user %s: %v\n", userID.Hex(), err)
+			return
+		}
+		if !wentOffline {
+			return
+		}
+
+		lastSeen := time.Now()
+		if err := s.users.SetLastSeen(ctx, userID, lastSeen); err != nil {
+			s.logger.Printf("Failed to store last seen of user %s: %v\n", userID.Hex(), err)
+		}
+		s.publish(ctx, userID, false, &lastSeen)
+	}
+}
+
+// GetPresence returns the presence of the users as the viewer may see it, in the order asked.
+// Users that do not exist or are deactivated are left out.
+func (s *PresenceService) GetPresence(ctx context.Context, viewerID primitive.ObjectID, userIDs []primitive.ObjectID) ([]*models.Presence, error) {
+	users, err := s.users.GetPresenceFields(ctx, userIDs)
+	if err != nil {
+		s.logger.Printf("Error loading users for presence: %v\n", err)
+		return nil, err
+	}
+
+	online, err := s.tracker.Online(ctx, userIDs)
+	if err != nil {
+		s.logger.Printf("Error reading presence: %v\n", err)
+		return nil, err
+	}
+
+	hidden, err := s.blocks.GetHiddenUserIDs(ctx, viewerID)
+	if err != nil {
+		s.logger.Printf("Error fetching blocked users of %s: %v\n", viewerID.Hex(), err)
+		return nil, err
+	}
+	blocked := make(map[primitive.ObjectID]bool, len(hidden))
+	for _, id := range hidden {
+		blocked[id] = true
+	}
+
+	byID := make(map[primitive.ObjectID]*models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	result := make([]*models.Presence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		user, ok := byID[userID]
+		if !ok || user.DeactivatedAt != nil || user.DeletingAt != nil {
+			continue
+		}
+
+		if blocked[userID] || (user.GetSettings().HideLastSeen && userID != viewerID) {
+			result = append(result, &models.Presence{UserID: userID, Hidden: true})
+			continue
+		}
+
+		result = append(result, &models.Presence{
+			UserID:   userID,
+			Online:   online[userID],
+			LastSeen: user.LastSeen,
+		})
+	}
+
+	return result, nil
+}
+
+// Channels returns the Redis channels the viewer may follow the presence of the user on,
+// none if the two have blocked each other
+func (s *PresenceService) Channels(ctx context.Context, viewerID, userID primitive.ObjectID) ([]string, error) {
+	blocked, err := s.blocks.IsBlockedEitherWay(ctx, viewerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, nil
+	}
+
+	return []string{presence.Channel(userID)}, nil
+}
+
+// publish sends a presence change to the chats open with the user, unless they hide their last seen
+func (s *PresenceService) publish(ctx context.Context, userID primitive.ObjectID, online bool, lastSeen *time.Time) {
+	users, err := s.users.GetPresenceFields(ctx, []primitive.ObjectID{userID})
+	if err != nil {
+		s.logger.Printf("Failed to load user %s for presence: %v\n", userID.Hex(), err)
+		return
+	}
+	if len(users) == 0 || users[0].GetSettings().HideLastSeen {
+		return
+	}
+
+	event, err := json.Marshal(models.PresenceEvent{Type: "presence", UserID: userID, Online: online, LastSeen: lastSeen})
+	if err != nil {
+		s.logger.Printf("Failed to encode presence event: %v\n", err)
+		return
+	}
+
+	if err := s.redis.Publish(ctx, presence.Channel(userID), event).Err(); err != nil {
+		s.logger.Printf("Failed to publish presence of user %s: %v\n", userID.Hex(), err)
+	}
+}
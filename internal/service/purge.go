@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/storage"
+)
+
+// PurgeSweeper deletes accounts that were deactivated and never reactivated
+type PurgeSweeper struct {
+	users  *storage.UserStorage
+	jobs   *storage.JobStorage
+	logger *log.Logger
+}
+
+// NewPurgeSweeper initializes PurgeSweeper
+func NewPurgeSweeper(users *storage.UserStorage, jobs *storage.JobStorage, logger *log.Logger) *PurgeSweeper {
+	return &PurgeSweeper{users: users, jobs: jobs, logger: logger}
+}
+
+// Run queues the deletion of accounts that stayed deactivated for longer than
+// gracePeriod, checking every interval until ctx is cancelled. Several instances may run it;
+// only the one that marks an account for deletion queues its job.
+func (s *PurgeSweeper) Run(ctx context.Context, gracePeriod, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeDeactivated(ctx, gracePeriod)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *PurgeSweeper) purgeDeactivated(ctx context.Context, gracePeriod time.Duration) {
+	cutoff := time.Now().Add(-gracePeriod)
+	users, err := s.users.GetDeactivatedBefore(ctx, cutoff, cleanupBatchSize)
+	if err != nil {
+		s.logger.Println("Failed to look up deactivated accounts:", err)
+		return
+	}
+
+	for _, userID := range users {
+		// Marking checks the deactivation again, so a user who reactivated since the lookup is kept
+		marked, err := s.users.MarkDeactivatedDeleting(ctx, userID, cutoff)
+		if err != nil {
+			s.logger.Printf("Failed to mark deactivated user %s for deletion: %v\n", userID.Hex(), err)
+			continue
+		}
+		if !marked {
+			continue
+		}
+
+		job, err := s.jobs.CreateJob(ctx, models.JobAccountDeletion, userID)
+		if err != nil {
+			s.logger.Printf("Failed to queue purge of deactivated user %s: %v\n", userID.Hex(), err)
+			// unmarked, the next sweep tries again
+			if err := s.users.UnmarkDeleting(ctx, userID); err != nil {
+				s.logger.Printf("Failed to unmark deactivated user %s: %v\n", userID.Hex(), err)
+			}
+			continue
+		}
+
+		s.logger.Printf("Queued purge of deactivated user %s as job %s\n", userID.Hex(), job.ID.Hex())
+	}
+}
@@ -2,12 +2,19 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"time"
 
+	"github.com/redis/go-redis/v9"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/models"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"github.com/ruziba3vich/soand/internal/storage"
+	"github.com/ruziba3vich/soand/pkg/config"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,23 +24,51 @@ import (
 type PostService struct {
 	storage       *storage.Storage
 	likes_storage *storage.LikesStorage
+	user_storage  *storage.UserStorage
+	revisions     *storage.PostRevisionStorage
+	cleanup       *Cleanup
 	logger        *log.Logger
 	file_service  repos.IFIleStoreService
+	redis         *redis.Client
+	limits        config.PostConfig
 }
 
 // NewPostService initializes a new PostService with storage and logger
-func NewPostService(storage *storage.Storage, likes_storage *storage.LikesStorage, file_service repos.IFIleStoreService, logger *log.Logger) repos.IPostService {
+func NewPostService(storage *storage.Storage, likes_storage *storage.LikesStorage, user_storage *storage.UserStorage, revisions *storage.PostRevisionStorage, cleanup *Cleanup, file_service repos.IFIleStoreService, redis *redis.Client, limits config.PostConfig, logger *log.Logger) repos.IPostService {
 	// Create a logger
 	return &PostService{
 		storage:       storage,
 		likes_storage: likes_storage,
+		user_storage:  user_storage,
+		revisions:     revisions,
+		cleanup:       cleanup,
 		logger:        logger,
 		file_service:  file_service,
+		redis:         redis,
+		limits:        limits,
 	}
 }
 
-// CreatePost inserts a new post into the database
+// CreatePost inserts a new post into the database. Without deleteAfter the post lives as
+// long as the creator's default post lifetime setting says.
 func (s *PostService) CreatePost(ctx context.Context, post *models.Post, deleteAfter int) error {
+	if deleteAfter <= 0 {
+		creator, err := s.user_storage.GetUserByID(ctx, post.CreatorId)
+		if err != nil {
+			s.logger.Println(logrus.Fields{
+				"creator": post.CreatorId.Hex(),
+				"error":   err.Error(),
+			})
+			return err
+		}
+		// the setting may allow more than this server does
+		deleteAfter = min(creator.GetSettings().DefaultPostTTL, s.MaxTTLHours())
+	}
+
+	if err := s.file_service.CheckOwner(ctx, post.CreatorId, post.Pictures...); err != nil {
+		return err
+	}
+
 	// for _, file := range files {
 	// 	filename, err := s.file_service.UploadFile(file)
 	// 	if err != nil {
@@ -67,10 +102,20 @@ func (s *PostService) CreatePost(ctx context.Context, post *models.Post, deleteA
 	return nil
 }
 
-// DeletePost removes a post by ID
-func (s *PostService) DeletePost(ctx context.Context, id primitive.ObjectID) error {
-	err := s.storage.DeletePost(ctx, id)
+// DeletePost deletes a post with its comments, likes, pins, edit history and pictures. Only its
+// creator and moderators may delete it, role is the one of the user deleting.
+func (s *PostService) DeletePost(ctx context.Context, id, userID primitive.ObjectID, role string) error {
+	post, err := s.storage.GetPost(ctx, id)
 	if err != nil {
+		return err
+	}
+
+	byModerator := post.CreatorId != userID
+	if byModerator && !models.HasRole(role, models.RoleModerator) {
+		return dto.ErrPostDeleteForbidden
+	}
+
+	if err := s.cleanup.PurgePost(ctx, post); err != nil {
 		s.logger.Println(logrus.Fields{
 			"id":    id.Hex(),
 			"error": err.Error(),
@@ -78,19 +123,43 @@ func (s *PostService) DeletePost(ctx context.Context, id primitive.ObjectID) err
 		return err
 	}
 
-	s.logger.Println("id", id.Hex())
+	s.publishDeleted(ctx, post, byModerator)
+
+	s.logger.Println(logrus.Fields{
+		"id":           id.Hex(),
+		"deleted_by":   userID.Hex(),
+		"by_moderator": byModerator,
+	})
 	return nil
 }
 
-// EnsureTTLIndex ensures TTL index is set on the collection
-func (s *PostService) EnsureTTLIndex(ctx context.Context) error {
-	err := s.storage.EnsureTTLIndex(ctx)
+// publishDeleted tells the subscribers of the post's comments it is gone, so they can close
+func (s *PostService) publishDeleted(ctx context.Context, post *models.Post, byModerator bool) {
+	message, err := json.Marshal(map[string]any{
+		"action":       "post_deleted",
+		"post_id":      post.ID.Hex(),
+		"by_moderator": byModerator,
+		"timestamp":    time.Now(),
+	})
+	if err != nil {
+		s.logger.Println("Error marshaling post deletion:", err)
+		return
+	}
+
+	if err := s.redis.Publish(ctx, "comments:"+post.ID.Hex(), string(message)).Err(); err != nil {
+		s.logger.Println("Error publishing post deletion:", err)
+	}
+}
+
+// EnsureExpiryIndex ensures the index the expiry sweeper looks posts up with is set on the collection
+func (s *PostService) EnsureExpiryIndex(ctx context.Context) error {
+	err := s.storage.EnsureExpiryIndex(ctx)
 	if err != nil {
 		s.logger.Println("error", err.Error())
 		return err
 	}
 
-	s.logger.Println("TTL index ensured successfully")
+	s.logger.Println("Expiry index ensured successfully")
 	return nil
 }
 
@@ -138,25 +207,197 @@ func (s *PostService) GetPost(ctx context.Context, id primitive.ObjectID) (*mode
 	return post, nil
 }
 
-// UpdatePost updates a post by ID
-func (s *PostService) UpdatePost(ctx context.Context, id primitive.ObjectID, updaterID primitive.ObjectID, update bson.M) error {
-	err := s.storage.UpdatePost(ctx, id, updaterID, update)
+// MaxTTLHours is the longest a post can be set to live, in hours
+func (s *PostService) MaxTTLHours() int {
+	return int(s.limits.MaxTTL / time.Hour)
+}
+
+// ChangeLifetime extends, renews or makes permanent a post of the user, and tells the subscribers of
+// the post's comments when it expires now. Extending and renewing cannot make a post live longer
+// than the configured maximum from now on.
+func (s *PostService) ChangeLifetime(ctx context.Context, postID, userID primitive.ObjectID, request *dto.PostLifetimeRequest) (*models.PostLifetime, error) {
+	if err := request.Validate(s.MaxTTLHours()); err != nil {
+		return nil, err
+	}
+
+	post, err := s.storage.GetPost(ctx, postID)
 	if err != nil {
+		return nil, err
+	}
+	if post.CreatorId != userID {
+		return nil, dto.ErrNotPostCreator
+	}
+
+	now := time.Now()
+	if !post.Permanent && !post.DeleteAt.After(now) {
+		// the TTL monitor has not removed it yet
+		return nil, dto.ErrPostExpired
+	}
+
+	var deleteAt *time.Time
+	switch request.Action {
+	case dto.LifetimeExtend:
+		if post.Permanent {
+			return nil, dto.ErrPostPermanent
+		}
+		extended := post.DeleteAt.Add(time.Duration(request.Hours) * time.Hour)
+		if extended.Sub(now) > s.limits.MaxTTL {
+			return nil, fmt.Errorf("%w, a post can live at most %d hours from now", dto.ErrInvalidPostLifetime, s.MaxTTLHours())
+		}
+		deleteAt = &extended
+	case dto.LifetimeRenew:
+		renewed := now.Add(time.Duration(request.Hours) * time.Hour)
+		deleteAt = &renewed
+	case dto.LifetimePermanent:
+		if !s.limits.AllowPermanent {
+			return nil, dto.ErrPermanentPostsOff
+		}
+	}
+
+	if err := s.storage.SetLifetime(ctx, post, deleteAt); err != nil {
 		s.logger.Println(logrus.Fields{
-			"id":        id.Hex(),
-			"updaterID": updaterID.Hex(),
-			"update":    update,
-			"error":     err.Error(),
+			"id":     postID.Hex(),
+			"action": request.Action,
+			"error":  err.Error(),
 		})
-		return err
+		return nil, err
 	}
 
+	lifetime := &models.PostLifetime{PostID: postID, DeleteAt: deleteAt, Permanent: deleteAt == nil}
+	s.publishLifetime(ctx, lifetime)
+
 	s.logger.Println(logrus.Fields{
-		"id":        id.Hex(),
-		"updaterID": updaterID.Hex(),
-		"update":    update,
+		"id":        postID.Hex(),
+		"action":    request.Action,
+		"delete_at": deleteAt,
 	})
-	return nil
+	return lifetime, nil
+}
+
+// publishLifetime sends the new expiry to the subscribers of the post's comments, in the
+// format of the comment handler's broadcasts
+func (s *PostService) publishLifetime(ctx context.Context, lifetime *models.PostLifetime) {
+	message, err := json.Marshal(map[string]any{
+		"action":    "lifetime",
+		"post_id":   lifetime.PostID.Hex(),
+		"delete_at": lifetime.DeleteAt,
+		"permanent": lifetime.Permanent,
+		"timestamp": time.Now(),
+	})
+	if err != nil {
+		s.logger.Println("Error marshaling lifetime update:", err)
+		return
+	}
+
+	if err := s.redis.Publish(ctx, "comments:"+lifetime.PostID.Hex(), string(message)).Err(); err != nil {
+		s.logger.Println("Error publishing lifetime update:", err)
+	}
+}
+
+// EditPost changes the content of a post of the editor. The content it had is kept as a revision
+// first, so the history is complete even if the edit then fails.
+func (s *PostService) EditPost(ctx context.Context, id, editorID primitive.ObjectID, request *dto.PostUpdateRequest) (*models.Post, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	post, err := s.storage.GetPost(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if post.CreatorId != editorID {
+		return nil, dto.ErrNotPostCreator
+	}
+	if post.Version != *request.Version {
+		return nil, dto.ErrPostVersionConflict
+	}
+
+	set := bson.M{}
+	if request.Title != nil {
+		set["title"] = *request.Title
+	}
+	if request.Description != nil {
+		set["description"] = *request.Description
+	}
+	if request.Tags != nil {
+		set["tags"] = nonNil(*request.Tags)
+	}
+	if request.Pics != nil {
+		// pictures the post has already may predate recording uploaders, only new ones are checked
+		var added []string
+		for _, picture := range *request.Pics {
+			if !slices.Contains(post.Pictures, picture) {
+				added = append(added, picture)
+			}
+		}
+		if err := s.file_service.CheckOwner(ctx, editorID, added...); err != nil {
+			return nil, err
+		}
+		set["pictures"] = nonNil(*request.Pics)
+	}
+
+	if err := s.revisions.SaveRevision(ctx, post); err != nil {
+		s.logger.Println(logrus.Fields{
+			"id":    id.Hex(),
+			"error": err.Error(),
+		})
+		return nil, err
+	}
+
+	edited, err := s.storage.EditPost(ctx, post, set)
+	if err != nil {
+		if !errors.Is(err, dto.ErrPostVersionConflict) {
+			s.logger.Println(logrus.Fields{
+				"id":    id.Hex(),
+				"error": err.Error(),
+			})
+		}
+		return nil, err
+	}
+
+	if err := s.changeFiles(edited); err != nil {
+		return nil, err
+	}
+
+	s.logger.Println(logrus.Fields{
+		"id":      id.Hex(),
+		"version": edited.Version,
+	})
+	return edited, nil
+}
+
+// GetRevisions returns a page of the earlier versions of a post, newest first
+func (s *PostService) GetRevisions(ctx context.Context, id primitive.ObjectID, page, pageSize int64) ([]*models.PostRevision, error) {
+	post, err := s.storage.GetPost(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.revisions.GetRevisions(ctx, id, post.Version, page, pageSize)
+	if err != nil {
+		s.logger.Println(logrus.Fields{
+			"id":    id.Hex(),
+			"error": err.Error(),
+		})
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		for i := range revision.Pictures {
+			if revision.Pictures[i], err = s.file_service.GetFile(revision.Pictures[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return revisions, nil
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
 }
 
 func (s *PostService) SearchPostsByTitle(ctx context.Context, query string, page, pageSize int64) ([]models.Post, error) {
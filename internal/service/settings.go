@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// audienceAllows reports whether the viewer belongs to the audience the owner chose in their
+// settings. Owners always belong to their own audiences, anonymous viewers only to everyone.
+func audienceAllows(ctx context.Context, follows *storage.FollowStorage, viewerID, ownerID primitive.ObjectID, audience string) (bool, error) {
+	if viewerID == ownerID {
+		return true, nil
+	}
+
+	switch audience {
+	case models.AudienceEveryone, "":
+		return true, nil
+	case models.AudienceFollowers:
+		if viewerID.IsZero() {
+			return false, nil
+		}
+		status, err := follows.GetStatus(ctx, viewerID, ownerID)
+		if err != nil {
+			return false, err
+		}
+		return status == models.FollowAccepted, nil
+	default:
+		return false, nil
+	}
+}
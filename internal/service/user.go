@@ -2,71 +2,267 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/loginguard"
 	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/otp"
 	"github.com/ruziba3vich/soand/internal/repos"
 	"github.com/ruziba3vich/soand/internal/storage"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // UserService handles business logic for users
 type UserService struct {
 	storage *storage.UserStorage
+	follows *storage.FollowStorage
+	blocks  *storage.BlockStorage
+	otp     *otp.Service
+	guard   *loginguard.Guard
+	audit   *storage.LoginAuditStorage
 	logger  *log.Logger
 }
 
 // NewUserService initializes UserService
-func NewUserService(storage *storage.UserStorage, logger *log.Logger) repos.UserRepo {
-	return &UserService{storage: storage, logger: logger}
+func NewUserService(
+	storage *storage.UserStorage,
+	follows *storage.FollowStorage,
+	blocks *storage.BlockStorage,
+	otp *otp.Service,
+	guard *loginguard.Guard,
+	audit *storage.LoginAuditStorage,
+	logger *log.Logger,
+) repos.UserRepo {
+	return &UserService{
+		storage: storage,
+		follows: follows,
+		blocks:  blocks,
+		otp:     otp,
+		guard:   guard,
+		audit:   audit,
+		logger:  logger,
+	}
 }
 
-// CreateUser creates a new user and returns a JWT token
-func (s *UserService) CreateUser(ctx context.Context, user *models.User) (string, error) {
+// CreateUser creates a new user and returns its first token pair
+func (s *UserService) CreateUser(ctx context.Context, user *models.User, meta *models.SessionMeta) (*models.AuthTokens, error) {
 	s.logger.Println("Creating new user...")
 
-	token, err := s.storage.CreateUser(ctx, user)
+	tokens, err := s.storage.CreateUser(ctx, user, meta)
 	if err != nil {
 		s.logger.Printf("Error creating user: %v\n", err)
-		return "", err
+		return nil, err
 	}
 
 	s.logger.Printf("User created successfully, ID: %s\n", user.ID.Hex())
-	return token, nil
+	return tokens, nil
 }
 
-// CreateUser creates a new user and returns a JWT token
-func (s *UserService) LoginUser(ctx context.Context, username, password string) (string, error) {
-
-	token, err := s.storage.Login(ctx, username, password)
+// LoginUser checks the credentials and either opens a new session or starts a 2FA challenge
+func (s *UserService) LoginUser(ctx context.Context, username, password string, meta *models.SessionMeta) (*models.LoginResult, error) {
+	result, err := s.guardLogin(ctx, username, meta, func() (*models.LoginResult, error) {
+		return s.storage.Login(ctx, username, password, meta)
+	})
 	if err != nil {
-		s.logger.Printf("Error creating user: %v\n", err)
-		return "", err
+		s.logger.Printf("Error logging in user: %v\n", err)
+		return nil, err
+	}
+
+	if result.TwoFactorRequired {
+		s.logger.Printf("Password accepted, waiting for the second factor of: %s\n", username)
+		return result, nil
 	}
 
 	s.logger.Printf("User logged in successfully, ID: %s\n", username)
-	return token, nil
+	return result, nil
+}
+
+// ReactivateUser reactivates a deactivated account and logs the user in
+func (s *UserService) ReactivateUser(ctx context.Context, username, password string, meta *models.SessionMeta) (*models.LoginResult, error) {
+	result, err := s.guardLogin(ctx, username, meta, func() (*models.LoginResult, error) {
+		return s.storage.Reactivate(ctx, username, password, meta)
+	})
+	if err != nil {
+		s.logger.Printf("Error reactivating user: %v\n", err)
+		return nil, err
+	}
+
+	s.logger.Printf("User reactivated: %s\n", username)
+	return result, nil
+}
+
+// guardLogin runs a password login unless the username or IP is locked out after too many
+// failures, and counts and audits the login if the credentials were wrong
+func (s *UserService) guardLogin(ctx context.Context, username string, meta *models.SessionMeta, login func() (*models.LoginResult, error)) (*models.LoginResult, error) {
+	retryAfter, err := s.guard.Check(ctx, username, meta.IP)
+	if err != nil {
+		s.logger.Printf("Error checking login lockout: %v\n", err)
+		return nil, err
+	}
+	if retryAfter > 0 {
+		s.auditFailure(ctx, username, meta, models.LoginFailedLockedOut)
+		return nil, &dto.LoginLockedError{RetryAfter: retryAfter}
+	}
+
+	result, err := login()
+	if errors.Is(err, dto.ErrInvalidCredentials) {
+		if _, err := s.guard.Fail(ctx, username, meta.IP); err != nil {
+			s.logger.Printf("Error counting failed login: %v\n", err)
+		}
+		s.auditFailure(ctx, username, meta, models.LoginFailedCredentials)
+		return nil, dto.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.guard.Succeed(ctx, username); err != nil {
+		s.logger.Printf("Error resetting failed logins: %v\n", err)
+	}
+
+	return result, nil
+}
+
+func (s *UserService) auditFailure(ctx context.Context, username string, meta *models.SessionMeta, reason string) {
+	err := s.audit.Record(ctx, &models.LoginAudit{
+		Username:  username,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		Reason:    reason,
+	})
+	if err != nil {
+		s.logger.Printf("Error recording failed login: %v\n", err)
+	}
+}
+
+// LoginTwoFactor completes a 2FA login with the challenge token and a TOTP or recovery code
+func (s *UserService) LoginTwoFactor(ctx context.Context, challengeToken, code string, meta *models.SessionMeta) (*models.AuthTokens, error) {
+	tokens, err := s.storage.LoginTwoFactor(ctx, challengeToken, code, meta)
+	if err != nil {
+		s.logger.Printf("Error completing two-factor login: %v\n", err)
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// EnrollTwoFactor generates a pending TOTP secret for the user
+func (s *UserService) EnrollTwoFactor(ctx context.Context, userID primitive.ObjectID) (*models.TwoFactorEnrollment, error) {
+	s.logger.Printf("Starting two-factor enrolment for user %s\n", userID.Hex())
+
+	enrollment, err := s.storage.EnrollTwoFactor(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error starting two-factor enrolment: %v\n", err)
+		return nil, err
+	}
+
+	return enrollment, nil
+}
+
+// ConfirmTwoFactor enables 2FA and returns the recovery codes
+func (s *UserService) ConfirmTwoFactor(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	codes, err := s.storage.ConfirmTwoFactor(ctx, userID, code)
+	if err != nil {
+		s.logger.Printf("Error confirming two-factor enrolment: %v\n", err)
+		return nil, err
+	}
+
+	s.logger.Printf("Two-factor authentication enabled for user %s\n", userID.Hex())
+	return codes, nil
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a code
+func (s *UserService) DisableTwoFactor(ctx context.Context, userID primitive.ObjectID, password, code string) error {
+	if err := s.storage.DisableTwoFactor(ctx, userID, password, code); err != nil {
+		s.logger.Printf("Error disabling two-factor authentication: %v\n", err)
+		return err
+	}
+
+	s.logger.Printf("Two-factor authentication disabled for user %s\n", userID.Hex())
+	return nil
+}
+
+// RefreshTokens rotates a refresh token and returns a new token pair
+func (s *UserService) RefreshTokens(ctx context.Context, refreshToken string, meta *models.SessionMeta) (*models.AuthTokens, error) {
+	tokens, err := s.storage.RefreshTokens(ctx, refreshToken, meta)
+	if err != nil {
+		s.logger.Printf("Error refreshing tokens: %v\n", err)
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Logout revokes a single session of the user
+func (s *UserService) Logout(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	s.logger.Printf("Logging out session %s of user %s\n", sessionID.Hex(), userID.Hex())
+
+	if err := s.storage.Logout(ctx, userID, sessionID); err != nil {
+		s.logger.Printf("Error logging out: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// LogoutAll revokes every session of the user
+func (s *UserService) LogoutAll(ctx context.Context, userID primitive.ObjectID) error {
+	s.logger.Printf("Logging out user %s from all devices\n", userID.Hex())
+
+	revoked, err := s.storage.LogoutAll(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error logging out from all devices: %v\n", err)
+		return err
+	}
+
+	s.logger.Printf("Revoked %d sessions of user %s\n", revoked, userID.Hex())
+	return nil
+}
+
+// GetSessions lists the devices the user is currently logged in on
+func (s *UserService) GetSessions(ctx context.Context, userID, currentSessionID primitive.ObjectID) ([]*models.Session, error) {
+	sessions, err := s.storage.GetSessions(ctx, userID, currentSessionID)
+	if err != nil {
+		s.logger.Printf("Error fetching sessions of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return sessions, nil
 }
 
-// DeleteUser removes a user from the database
-func (s *UserService) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
-	s.logger.Printf("Deleting user with ID: %s\n", userID.Hex())
+// RevokeSession logs the user out of one of their devices
+func (s *UserService) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	s.logger.Printf("Revoking session %s of user %s\n", sessionID.Hex(), userID.Hex())
 
-	err := s.storage.DeleteUser(ctx, userID)
+	if err := s.storage.Logout(ctx, userID, sessionID); err != nil {
+		s.logger.Printf("Error revoking session: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions logs the user out of every device except the current one
+func (s *UserService) RevokeOtherSessions(ctx context.Context, userID, currentSessionID primitive.ObjectID) error {
+	revoked, err := s.storage.RevokeOtherSessions(ctx, userID, currentSessionID)
 	if err != nil {
-		s.logger.Printf("Error deleting user: %v\n", err)
+		s.logger.Printf("Error revoking other sessions of user %s: %v\n", userID.Hex(), err)
 		return err
 	}
 
-	s.logger.Println("User deleted successfully")
+	s.logger.Printf("Revoked %d other sessions of user %s\n", revoked, userID.Hex())
 	return nil
 }
 
-// GetUserByID retrieves a user by their ID
-func (s *UserService) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+// GetUserByID retrieves a user by their ID as seen by the viewer, anonymous viewers have a nil viewerID
+func (s *UserService) GetUserByID(ctx context.Context, viewerID, userID primitive.ObjectID) (*models.User, error) {
 	s.logger.Printf("Fetching user by ID: %s\n", userID.Hex())
 
 	user, err := s.storage.GetUserByID(ctx, userID)
@@ -75,22 +271,154 @@ func (s *UserService) GetUserByID(ctx context.Context, userID primitive.ObjectID
 		return nil, err
 	}
 
+	if err := s.checkViewable(ctx, viewerID, user); err != nil {
+		return nil, err
+	}
+
+	if user.Followers, err = s.follows.CountFollowers(ctx, userID); err != nil {
+		s.logger.Printf("Error counting followers: %v\n", err)
+		return nil, err
+	}
+	if user.Following, err = s.follows.CountFollowing(ctx, userID); err != nil {
+		s.logger.Printf("Error counting followings: %v\n", err)
+		return nil, err
+	}
+
 	return user, nil
 }
 
-// GetUserByUsername retrieves a user by their username
-func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+// GetUserByUsername retrieves a user by their username as seen by the viewer. A username given
+// up recently still finds its last owner, with RenamedFrom set so clients can redirect.
+func (s *UserService) GetUserByUsername(ctx context.Context, viewerID primitive.ObjectID, username string) (*models.User, error) {
 	s.logger.Printf("Fetching user by username: %s\n", username)
 
 	user, err := s.storage.GetUserByUsername(ctx, username)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		user, err = s.storage.GetUserByPreviousUsername(ctx, username)
+		if err == nil {
+			user.RenamedFrom = username
+		}
+	}
 	if err != nil {
 		s.logger.Printf("Error fetching user: %v\n", err)
 		return nil, err
 	}
 
+	if err := s.checkViewable(ctx, viewerID, user); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
+// SearchUsers finds users by username and full name, best matches first. Users blocked
+// in either direction are left out for signed-in viewers.
+func (s *UserService) SearchUsers(ctx context.Context, viewerID primitive.ObjectID, query string, page, pageSize int64) ([]*models.UserSummary, error) {
+	if strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(query), "@")) == "" {
+		return nil, dto.ErrEmptySearchQuery
+	}
+
+	var excluded []primitive.ObjectID
+	if !viewerID.IsZero() {
+		var err error
+		excluded, err = s.blocks.GetHiddenUserIDs(ctx, viewerID)
+		if err != nil {
+			s.logger.Printf("Error fetching blocked users of %s: %v\n", viewerID.Hex(), err)
+			return nil, err
+		}
+	}
+
+	users, err := s.storage.SearchUsers(ctx, query, excluded, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error searching users: %v\n", err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// GetSettings returns the settings of the user
+func (s *UserService) GetSettings(ctx context.Context, userID primitive.ObjectID) (*models.UserSettings, error) {
+	user, err := s.storage.GetUserByID(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching user: %v\n", err)
+		return nil, err
+	}
+
+	settings := user.GetSettings()
+	return &settings, nil
+}
+
+// UpdateSettings changes the settings in update and returns the resulting settings
+func (s *UserService) UpdateSettings(ctx context.Context, userID primitive.ObjectID, update *models.SettingsUpdate) (*models.UserSettings, error) {
+	if update.DirectMessages != nil && !models.IsValidAudience(*update.DirectMessages) {
+		return nil, dto.ErrInvalidAudience
+	}
+	if update.ProfilePictures != nil && !models.IsValidAudience(*update.ProfilePictures) {
+		return nil, dto.ErrInvalidAudience
+	}
+	if update.DefaultPostTTL != nil && (*update.DefaultPostTTL < 1 || *update.DefaultPostTTL > models.MaxDefaultPostTTL) {
+		return nil, dto.ErrInvalidPostTTL
+	}
+
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings.Apply(update)
+
+	if err := s.storage.UpdateSettings(ctx, userID, settings); err != nil {
+		s.logger.Printf("Error updating settings of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Printf("Settings of user %s updated\n", userID.Hex())
+	return settings, nil
+}
+
+// checkViewable makes deactivated and deleted accounts, and accounts that blocked the viewer, look like they do not exist.
+// It also clears what the user's settings keep from the viewer: last seen and profile pictures.
+func (s *UserService) checkViewable(ctx context.Context, viewerID primitive.ObjectID, user *models.User) error {
+	if user.DeactivatedAt != nil || user.DeletingAt != nil {
+		return dto.ErrUserNotFound
+	}
+
+	if viewerID == user.ID {
+		return nil
+	}
+
+	// previous usernames and linked accounts are only shown to the user themselves
+	user.UsernameHistory = nil
+	user.Identities = nil
+
+	if !viewerID.IsZero() {
+		blocked, err := s.blocks.HasBlocked(ctx, user.ID, viewerID)
+		if err != nil {
+			s.logger.Printf("Error checking blocks of user %s: %v\n", user.ID.Hex(), err)
+			return err
+		}
+		if blocked {
+			return dto.ErrUserNotFound
+		}
+	}
+
+	settings := user.GetSettings()
+	if settings.HideLastSeen {
+		user.LastSeen = nil
+	}
+
+	allowed, err := audienceAllows(ctx, s.follows, viewerID, user.ID, settings.ProfilePictures)
+	if err != nil {
+		s.logger.Printf("Error checking profile picture audience of user %s: %v\n", user.ID.Hex(), err)
+		return err
+	}
+	if !allowed {
+		user.ProfilePics = []models.ProfilePic{}
+	}
+
+	return nil
+}
+
 // // UpdateFullname updates a user's full name
 // func (s *UserService) UpdateFullname(ctx context.Context, userID primitive.ObjectID, newFullname string) error {
 // 	s.logger.Printf("Updating fullname for user ID: %s\n", userID.Hex())
@@ -123,6 +451,10 @@ func (s *UserService) UpdatePassword(ctx context.Context, userID primitive.Objec
 func (s *UserService) UpdateUsername(ctx context.Context, userID primitive.ObjectID, newUsername string) error {
 	s.logger.Printf("Updating username for user ID: %s\n", userID.Hex())
 
+	if strings.TrimSpace(newUsername) == "" {
+		return dto.ErrInvalidUsername
+	}
+
 	err := s.storage.UpdateUsername(ctx, userID, newUsername)
 	if err != nil {
 		s.logger.Printf("Error updating username: %v\n", err)
@@ -151,21 +483,29 @@ func (s *UserService) UpdateUser(ctx context.Context, userID primitive.ObjectID,
 	if len(updateFields) == 0 {
 		return fmt.Errorf("no fields provided for update")
 	}
-	return s.storage.UpdateUser(ctx, userID, updateFields)
+	if err := s.storage.UpdateUser(ctx, userID, updateFields); err != nil {
+		return err
+	}
+
+	// A public account has no one to approve followers, so waiting requests go through
+	if updates.ProfileHidden != nil && !*updates.ProfileHidden {
+		return s.follows.AcceptAllRequests(ctx, userID)
+	}
+	return nil
 }
 
-// ValidateJWT validates a JWT token and returns the user ID
-func (s *UserService) ValidateJWT(tokenString string) (string, error) {
+// ValidateJWT validates an access token and returns its claims
+func (s *UserService) ValidateJWT(tokenString string) (*models.TokenClaims, error) {
 	s.logger.Println("Validating JWT token...")
 
-	userID, err := s.storage.ValidateJWT(tokenString)
+	claims, err := s.storage.ValidateJWT(tokenString)
 	if err != nil {
 		s.logger.Printf("Invalid JWT token: %v\n", err)
-		return "", err
+		return nil, err
 	}
 
-	s.logger.Printf("JWT token validated successfully, user ID: %s\n", userID)
-	return userID, nil
+	s.logger.Printf("JWT token validated successfully, user ID: %s\n", claims.UserID)
+	return claims, nil
 }
 
 // func (s *UserService) ChangeProfileVisibility(ctx context.Context, userID primitive.ObjectID, hidden bool) error {
@@ -223,6 +563,20 @@ func (s *UserService) DeleteProfilePicture(ctx context.Context, userID primitive
 	return nil
 }
 
-func (s *UserService) GetProfilePictures(ctx context.Context, userID primitive.ObjectID) ([]models.ProfilePic, error) {
+// GetProfilePictures returns the profile pictures of the user, none if their settings keep them from the viewer
+func (s *UserService) GetProfilePictures(ctx context.Context, viewerID, userID primitive.ObjectID) ([]models.ProfilePic, error) {
+	user, err := s.storage.GetUserByID(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching user: %v\n", err)
+		return nil, err
+	}
+
+	if err := s.checkViewable(ctx, viewerID, user); err != nil {
+		return nil, err
+	}
+	if len(user.ProfilePics) == 0 {
+		return []models.ProfilePic{}, nil
+	}
+
 	return s.storage.GetProfilePictures(ctx, userID)
 }
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"github.com/ruziba3vich/soand/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxVerificationNote = 1000 // see dto.ErrInvalidVerificationNote
+	// verificationCooldown is how long a user whose request was rejected waits before asking again
+	verificationCooldown = 7 * 24 * time.Hour
+)
+
+// VerificationService runs the verified badge workflow: users request the badge and moderators review the requests
+type VerificationService struct {
+	requests *storage.VerificationStorage
+	users    *storage.UserStorage
+	logger   *log.Logger
+}
+
+// NewVerificationService initializes VerificationService
+func NewVerificationService(requests *storage.VerificationStorage, users *storage.UserStorage, logger *log.Logger) repos.IVerificationService {
+	return &VerificationService{requests: requests, users: users, logger: logger}
+}
+
+// RequestVerification queues a request for the verified badge for moderators to review
+func (s *VerificationService) RequestVerification(ctx context.Context, userID primitive.ObjectID, note string) (*models.VerificationRequest, error) {
+	note = strings.TrimSpace(note)
+	if note == "" || utf8.RuneCountInString(note) > maxVerificationNote {
+		return nil, dto.ErrInvalidVerificationNote
+	}
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrUserNotFound
+	}
+	if err != nil {
+		s.logger.Printf("Error fetching user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+	if user.Verified {
+		return nil, dto.ErrAlreadyVerified
+	}
+
+	last, err := s.requests.GetLatestRequest(ctx, userID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		s.logger.Printf("Error fetching verification requests of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+	if last != nil {
+		if last.Status == models.VerificationPending {
+			return nil, dto.ErrVerificationPending
+		}
+		if last.Status == models.VerificationRejected && last.ReviewedAt != nil && time.Since(*last.ReviewedAt) < verificationCooldown {
+			return nil, dto.ErrVerificationTooSoon
+		}
+	}
+
+	request := &models.VerificationRequest{UserID: userID, Note: note}
+	if err := s.requests.CreateRequest(ctx, request); err != nil {
+		if !errors.Is(err, dto.ErrVerificationPending) {
+			s.logger.Printf("Error creating verification request of user %s: %v\n", userID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	s.logger.Printf("User %s requested verification\n", userID.Hex())
+	return request, nil
+}
+
+// GetRequests returns the user's own requests, newest first
+func (s *VerificationService) GetRequests(ctx context.Context, userID primitive.ObjectID) ([]*models.VerificationRequest, error) {
+	requests, err := s.requests.GetRequestsByUser(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching verification requests of user %s: %v\n", userID.Hex(), err)
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// GetPendingRequests returns the moderation queue, oldest first, with the requesting users
+func (s *VerificationService) GetPendingRequests(ctx context.Context, page, pageSize int64) ([]*models.VerificationRequest, error) {
+	requests, err := s.requests.GetPendingRequests(ctx, page, pageSize)
+	if err != nil {
+		s.logger.Printf("Error fetching pending verification requests: %v\n", err)
+		return nil, err
+	}
+
+	for _, request := range requests {
+		user, err := s.users.GetUserByID(ctx, request.UserID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			s.logger.Printf("Error fetching user %s: %v\n", request.UserID.Hex(), err)
+			return nil, err
+		}
+
+		request.User = &models.UserSummary{
+			ID:         user.ID,
+			Fullname:   user.Fullname,
+			Username:   user.Username,
+			ProfilePic: user.PublicProfilePic(),
+			Verified:   user.Verified,
+		}
+	}
+
+	return requests, nil
+}
+
+// Approve grants the verified badge to the user who made the request
+func (s *VerificationService) Approve(ctx context.Context, moderatorID, requestID primitive.ObjectID) error {
+	request, err := s.review(ctx, moderatorID, requestID, models.VerificationApproved, "")
+	if err != nil {
+		return err
+	}
+
+	if err := s.users.SetVerified(ctx, request.UserID, true); err != nil {
+		s.logger.Printf("Error verifying user %s: %v\n", request.UserID.Hex(), err)
+		return err
+	}
+
+	s.logger.Printf("Moderator %s verified user %s\n", moderatorID.Hex(), request.UserID.Hex())
+	return nil
+}
+
+// Reject turns the request down, the reason is shown to the user
+func (s *VerificationService) Reject(ctx context.Context, moderatorID, requestID primitive.ObjectID, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return dto.ErrRejectionReasonRequired
+	}
+
+	request, err := s.review(ctx, moderatorID, requestID, models.VerificationRejected, reason)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Printf("Moderator %s rejected the verification of user %s\n", moderatorID.Hex(), request.UserID.Hex())
+	return nil
+}
+
+// Revoke takes the verified badge away from a user, e.g. after their account changed hands
+func (s *VerificationService) Revoke(ctx context.Context, moderatorID, userID primitive.ObjectID) error {
+	if err := s.users.SetVerified(ctx, userID, false); err != nil {
+		if !errors.Is(err, dto.ErrUserNotFound) {
+			s.logger.Printf("Error revoking verification of user %s: %v\n", userID.Hex(), err)
+		}
+		return err
+	}
+
+	s.logger.Printf("Moderator %s revoked the verification of user %s\n", moderatorID.Hex(), userID.Hex())
+	return nil
+}
+
+func (s *VerificationService) review(ctx context.Context, moderatorID, requestID primitive.ObjectID, status, reason string) (*models.VerificationRequest, error) {
+	request, err := s.requests.GetRequestByID(ctx, requestID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrVerificationNotFound
+	}
+	if err != nil {
+		s.logger.Printf("Error fetching verification request %s: %v\n", requestID.Hex(), err)
+		return nil, err
+	}
+	if request.UserID == moderatorID {
+		return nil, dto.ErrOwnVerification
+	}
+
+	err = s.requests.ReviewRequest(ctx, requestID, moderatorID, status, reason)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrVerificationNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, dto.ErrVerificationReviewed) {
+			s.logger.Printf("Error reviewing verification request %s: %v\n", requestID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	return request, nil
+}
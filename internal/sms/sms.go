@@ -0,0 +1,56 @@
+// Package sms has SMSSender implementations that do not talk to a real provider,
+// for local development and testing.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/repos"
+)
+
+// LogSender writes every message to the application log
+type LogSender struct {
+	logger *log.Logger
+}
+
+// NewLogSender creates a sender that logs messages instead of sending them
+func NewLogSender(logger *log.Logger) repos.SMSSender {
+	return &LogSender{logger: logger}
+}
+
+// Send logs the message
+func (s *LogSender) Send(ctx context.Context, phone, message string) error {
+	s.logger.Printf("SMS to %s: %s\n", phone, message)
+	return nil
+}
+
+// FileSender appends every message to a file, one line per message
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSender creates a sender that appends messages to the file at path
+func NewFileSender(path string) repos.SMSSender {
+	return &FileSender{path: path}
+}
+
+// Send appends the message to the outbox file
+func (s *FileSender) Send(ctx context.Context, phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS outbox: %v", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), phone, message)
+	return err
+}
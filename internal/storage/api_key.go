@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lastUsedPrecision bounds how often a key's last use is written, a busy bot would otherwise write on every request
+const lastUsedPrecision = time.Minute
+
+type APIKeyStorage struct {
+	db *mongo.Collection
+}
+
+// NewAPIKeyStorage initializes APIKeyStorage
+func NewAPIKeyStorage(db *mongo.Collection) *APIKeyStorage {
+	return &APIKeyStorage{db: db}
+}
+
+// EnsureIndexes makes key hashes unique and indexes the keys of each user
+func (s *APIKeyStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+// CreateKey stores a new key
+func (s *APIKeyStorage) CreateKey(ctx context.Context, key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	key.CreatedAt = time.Now()
+	key.ID = primitive.NewObjectIDFromTimestamp(key.CreatedAt)
+
+	_, err := s.db.InsertOne(ctx, key)
+	return err
+}
+
+// GetKeyByHash fetches the key with the given hash
+func (s *APIKeyStorage) GetKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	if err := s.db.FindOne(ctx, bson.M{"hash": hash}).Decode(&key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// GetKeysByUser returns every key of the user, newest first
+func (s *APIKeyStorage) GetKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.db.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []*models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// CountKeysByUser returns how many keys the user has
+func (s *APIKeyStorage) CountKeysByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.db.CountDocuments(ctx, bson.M{"user_id": userID})
+}
+
+// DeleteKey revokes a key of the user and reports whether there was one
+func (s *APIKeyStorage) DeleteKey(ctx context.Context, userID, keyID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.DeleteOne(ctx, bson.M{"_id": keyID, "user_id": userID})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount > 0, nil
+}
+
+// TouchKey records that the key was used, unless that was already recorded within lastUsedPrecision
+func (s *APIKeyStorage) TouchKey(ctx context.Context, keyID primitive.ObjectID, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx,
+		bson.M{
+			"_id": keyID,
+			"$or": []bson.M{
+				{"last_used_at": bson.M{"$exists": false}},
+				{"last_used_at": bson.M{"$lt": now.Add(-lastUsedPrecision)}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	)
+	return err
+}
+
+// DeleteByUser removes every key of the user
+func (s *APIKeyStorage) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
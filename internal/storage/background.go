@@ -59,7 +59,7 @@ func (bs *BackgroundStorage) GetBackgroundByID(id string) (string, error) {
 
 // CreateBackground uploads a new background photo
 func (bs *BackgroundStorage) CreateBackground(file *multipart.FileHeader) (string, error) {
-	filename, err := bs.storage.UploadFile(file)
+	filename, err := bs.storage.UploadFile(file, primitive.NilObjectID)
 	if err != nil {
 		return "", err
 	}
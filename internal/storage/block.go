@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type BlockStorage struct {
+	db    *mongo.Collection
+	users *mongo.Collection
+}
+
+// NewBlockStorage initializes BlockStorage. The users collection is joined to list blocked users.
+func NewBlockStorage(db *mongo.Collection, users *mongo.Collection) *BlockStorage {
+	return &BlockStorage{
+		db:    db,
+		users: users,
+	}
+}
+
+// EnsureIndexes makes every pair of users block at most once and indexes lookups from both sides
+func (s *BlockStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "blocker_id", Value: 1}, {Key: "blocked_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "blocked_id", Value: 1}}},
+	})
+	return err
+}
+
+// Block makes blocker block blocked; blocking again changes nothing
+func (s *BlockStorage) Block(ctx context.Context, blockerID, blockedID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.db.UpdateOne(ctx,
+		bson.M{"blocker_id": blockerID, "blocked_id": blockedID},
+		bson.M{"$setOnInsert": bson.M{"_id": primitive.NewObjectIDFromTimestamp(now), "created_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil // a concurrent request blocked first
+	}
+	return err
+}
+
+// Unblock removes the block and reports whether there was one
+func (s *BlockStorage) Unblock(ctx context.Context, blockerID, blockedID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.DeleteOne(ctx, bson.M{"blocker_id": blockerID, "blocked_id": blockedID})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount > 0, nil
+}
+
+// HasBlocked reports whether blocker has blocked blocked
+func (s *BlockStorage) HasBlocked(ctx context.Context, blockerID, blockedID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := s.db.CountDocuments(ctx, bson.M{"blocker_id": blockerID, "blocked_id": blockedID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// IsBlockedEitherWay reports whether either of the two users has blocked the other
+func (s *BlockStorage) IsBlockedEitherWay(ctx context.Context, a, b primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{
+		{"blocker_id": a, "blocked_id": b},
+		{"blocker_id": b, "blocked_id": a},
+	}}
+	count, err := s.db.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetHiddenUserIDs returns the users the user blocked together with the users who blocked them
+func (s *BlockStorage) GetHiddenUserIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{{"blocker_id": userID}, {"blocked_id": userID}}}
+	cursor, err := s.db.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var blocks []models.Block
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(blocks))
+	for i, block := range blocks {
+		if block.BlockerID == userID {
+			ids[i] = block.BlockedID
+		} else {
+			ids[i] = block.BlockerID
+		}
+	}
+
+	return ids, nil
+}
+
+// GetBlockedUsers returns a page of the users the user has blocked, newest first
+func (s *BlockStorage) GetBlockedUsers(ctx context.Context, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	return listUserSummaries(ctx, s.db, s.users, bson.M{"blocker_id": userID}, "blocked_id", page, pageSize)
+}
+
+// GetBlocksByUser returns every block the user has made
+func (s *BlockStorage) GetBlocksByUser(ctx context.Context, userID primitive.ObjectID) ([]*models.Block, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.db.Find(ctx, bson.M{"blocker_id": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	blocks := []*models.Block{}
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// DeleteByUser removes every block the user made or is the target of
+func (s *BlockStorage) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"$or": []bson.M{{"blocker_id": userID}, {"blocked_id": userID}}})
+	return err
+}
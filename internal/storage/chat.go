@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ruziba3vich/soand/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -107,3 +108,56 @@ func (s *ChatStorage) GetMessageByID(ctx context.Context, messageID primitive.Ob
 	}
 	return &message, nil
 }
+
+// GetMessagesOfUser returns up to limit messages the user has sent or received
+func (s *ChatStorage) GetMessagesOfUser(ctx context.Context, userID primitive.ObjectID, limit int64) ([]*models.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{{"sender_id": userID}, {"recipient_id": userID}}}
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "pictures": 1}).SetLimit(limit)
+
+	cursor, err := s.db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// DeleteMessagesByIDs removes the given messages
+func (s *ChatStorage) DeleteMessagesByIDs(ctx context.Context, ids []primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// ForEachMessageOfUser calls fn with every message the user sent or received, oldest first
+func (s *ChatStorage) ForEachMessageOfUser(ctx context.Context, userID primitive.ObjectID, fn func(*models.Message) error) error {
+	filter := bson.M{"$or": []bson.M{{"sender_id": userID}, {"recipient_id": userID}}}
+	cursor, err := s.db.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+		if err := fn(&message); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -109,7 +109,8 @@ func (s *CommentStorage) GetParentComment(ctx context.Context, comment *models.C
 	return s.db.FindOne(ctx, bson.M{"_id": comment.ReplyTo, "post_id": comment.PostID}).Decode(&parentComment)
 }
 
-func (s *CommentStorage) GetCommentsByPostID(ctx context.Context, postID primitive.ObjectID, page, pageSize int64) ([]*models.Comment, error) {
+// GetCommentsByPostID returns a page of the comments of a post, newest first, leaving out the comments of excludedUsers
+func (s *CommentStorage) GetCommentsByPostID(ctx context.Context, postID primitive.ObjectID, excludedUsers []primitive.ObjectID, page, pageSize int64) ([]*models.Comment, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -124,7 +125,12 @@ func (s *CommentStorage) GetCommentsByPostID(ctx context.Context, postID primiti
 		SetSkip(skip).
 		SetSort(bson.M{"created_at": -1})
 
-	cursor, err := s.db.Find(ctx, bson.M{"post_id": postID}, opts)
+	filter := bson.M{"post_id": postID}
+	if len(excludedUsers) > 0 {
+		filter["user_id"] = bson.M{"$nin": excludedUsers}
+	}
+
+	cursor, err := s.db.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -200,3 +206,130 @@ func (s *CommentStorage) GetCommentByID(ctx context.Context, commentID primitive
 // 	}
 // 	return comments, nil
 // }
+
+// getCommentsForCleanup returns up to limit comments matching filter with only the fields needed
+// to delete them and their media
+func (s *CommentStorage) getCommentsForCleanup(ctx context.Context, filter bson.M, limit int64) ([]*models.Comment, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "pictures": 1, "voice_message": 1}).
+		SetLimit(limit)
+
+	cursor, err := s.db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var comments []*models.Comment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// GetCommentsByPost returns up to limit comments of a post, for deleting them with the post
+func (s *CommentStorage) GetCommentsByPost(ctx context.Context, postID primitive.ObjectID, limit int64) ([]*models.Comment, error) {
+	return s.getCommentsForCleanup(ctx, bson.M{"post_id": postID}, limit)
+}
+
+// GetCommentsByUser returns up to limit comments written by the user
+func (s *CommentStorage) GetCommentsByUser(ctx context.Context, userID primitive.ObjectID, limit int64) ([]*models.Comment, error) {
+	return s.getCommentsForCleanup(ctx, bson.M{"user_id": userID}, limit)
+}
+
+// DeleteCommentsByIDs removes the given comments
+func (s *CommentStorage) DeleteCommentsByIDs(ctx context.Context, ids []primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// RemoveUserReactions removes the user from every reaction list of every comment,
+// dropping reactions nobody is left on
+func (s *CommentStorage) RemoveUserReactions(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// Reaction names are map keys, so the lists can only be reached through $objectToArray
+	reactions := bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$reactions", bson.M{}}}}
+
+	filter := bson.M{"$expr": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+		"input": reactions,
+		"as":    "reaction",
+		"in":    bson.M{"$in": bson.A{userID, "$$reaction.v"}},
+	}}}}}
+
+	withoutUser := bson.M{"$map": bson.M{
+		"input": reactions,
+		"as":    "reaction",
+		"in": bson.M{
+			"k": "$$reaction.k",
+			"v": bson.M{"$filter": bson.M{
+				"input": "$$reaction.v",
+				"as":    "user",
+				"cond":  bson.M{"$ne": bson.A{"$$user", userID}},
+			}},
+		},
+	}}
+
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"reactions": bson.M{"$arrayToObject": bson.M{"$filter": bson.M{
+		"input": withoutUser,
+		"as":    "reaction",
+		"cond":  bson.M{"$gt": bson.A{bson.M{"$size": "$$reaction.v"}, 0}},
+	}}}}}}}
+
+	result, err := s.db.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// ForEachCommentByUser calls fn with every comment the user wrote, oldest first
+func (s *CommentStorage) ForEachCommentByUser(ctx context.Context, userID primitive.ObjectID, fn func(*models.Comment) error) error {
+	cursor, err := s.db.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var comment models.Comment
+		if err := cursor.Decode(&comment); err != nil {
+			return err
+		}
+		if err := fn(&comment); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// ForEachCommentByPost calls fn with every comment of the post, oldest first
+func (s *CommentStorage) ForEachCommentByPost(ctx context.Context, postID primitive.ObjectID, fn func(*models.Comment) error) error {
+	cursor, err := s.db.Find(ctx, bson.M{"post_id": postID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var comment models.Comment
+		if err := cursor.Decode(&comment); err != nil {
+			return err
+		}
+		if err := fn(&comment); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
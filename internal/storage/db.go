@@ -50,18 +50,24 @@ func ConnectMongoDB(ctx context.Context, cfg *config.Config, collectionName stri
 	return client.Database(cfg.MongoDB.Database).Collection(collectionName), nil
 }
 
-func (s *Storage) EnsureTTLIndex(ctx context.Context) error {
-	indexModel := mongo.IndexModel{
-		Keys: bson.M{"delete_at": 1}, // Create an index on delete_at field
-		Options: options.Index().
-			SetExpireAfterSeconds(0), // TTL index, MongoDB auto-deletes expired documents
-	}
+// EnsureExpiryIndex indexes delete_at for the expiry sweeper. Posts used to expire through a TTL
+// index on it, which left their comments, likes, pins and pictures behind, so that index is dropped.
+func (s *Storage) EnsureExpiryIndex(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	// Create the index
-	_, err := s.db.Indexes().CreateOne(ctx, indexModel)
+	specs, err := s.db.Indexes().ListSpecifications(ctx)
 	if err != nil {
 		return err
 	}
+	for _, spec := range specs {
+		if spec.Name == "delete_at_1" && spec.ExpireAfterSeconds != nil {
+			if _, err := s.db.Indexes().DropOne(ctx, spec.Name); err != nil {
+				return err
+			}
+		}
+	}
 
-	return nil
+	_, err = s.db.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "delete_at", Value: 1}}})
+	return err
 }
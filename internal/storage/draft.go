@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DraftStorage struct {
+	db *mongo.Collection
+}
+
+// NewDraftStorage initializes DraftStorage
+func NewDraftStorage(db *mongo.Collection) *DraftStorage {
+	return &DraftStorage{db: db}
+}
+
+// EnsureIndexes creates the indexes used to list drafts per user and to find the due ones
+func (s *DraftStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "creator_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}}},
+	})
+	return err
+}
+
+// notLeased matches drafts nobody is publishing right now
+func notLeased(now time.Time) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"lease_until": bson.M{"$exists": false}},
+		{"lease_until": bson.M{"$lt": now}},
+	}}
+}
+
+// CreateDraft stores a new draft
+func (s *DraftStorage) CreateDraft(ctx context.Context, draft *models.Draft) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	draft.CreatedAt = time.Now()
+	draft.UpdatedAt = draft.CreatedAt
+	draft.ID = primitive.NewObjectIDFromTimestamp(draft.CreatedAt)
+
+	_, err := s.db.InsertOne(ctx, draft)
+	return err
+}
+
+// CountDrafts returns how many drafts the user has
+func (s *DraftStorage) CountDrafts(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.db.CountDocuments(ctx, bson.M{"creator_id": userID})
+}
+
+// GetDraft fetches a draft of the user, dto.ErrDraftNotFound if there is none
+func (s *DraftStorage) GetDraft(ctx context.Context, userID, draftID primitive.ObjectID) (*models.Draft, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var draft models.Draft
+	err := s.db.FindOne(ctx, bson.M{"_id": draftID, "creator_id": userID}).Decode(&draft)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &draft, nil
+}
+
+// GetDraftsByUser returns up to limit drafts of the user, newest first, all of them with a limit of 0
+func (s *DraftStorage) GetDraftsByUser(ctx context.Context, userID primitive.ObjectID, limit int64) ([]*models.Draft, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit)
+	cursor, err := s.db.Find(ctx, bson.M{"creator_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	drafts := []*models.Draft{}
+	if err := cursor.All(ctx, &drafts); err != nil {
+		return nil, err
+	}
+
+	return drafts, nil
+}
+
+// UpdateDraft replaces the content and schedule of a draft of the user, clearing the
+// errors of earlier publication attempts
+func (s *DraftStorage) UpdateDraft(ctx context.Context, draft *models.Draft) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	draft.UpdatedAt = now
+	set := bson.M{
+		"title":        draft.Title,
+		"description":  draft.Description,
+		"tags":         draft.Tags,
+		"pictures":     draft.Pictures,
+		"delete_after": draft.DeleteAfter,
+		"status":       draft.Status,
+		"updated_at":   now,
+	}
+	unset := bson.M{"error": "", "attempts": ""}
+	if draft.PublishAt != nil {
+		set["publish_at"] = draft.PublishAt
+	} else {
+		unset["publish_at"] = ""
+	}
+
+	err := s.updateUnleased(ctx, draft.CreatorId, draft.ID, bson.M{}, bson.M{"$set": set, "$unset": unset})
+	if errors.Is(err, errDraftNotMatched) {
+		return dto.ErrDraftPublishing // its lease ran out just now
+	}
+	return err
+}
+
+// UnscheduleDraft turns a scheduled or failed draft of the user back into a plain draft
+func (s *DraftStorage) UnscheduleDraft(ctx context.Context, userID, draftID primitive.ObjectID) error {
+	filter := bson.M{"status": bson.M{"$in": []string{models.DraftScheduled, models.DraftFailed}}}
+	update := bson.M{
+		"$set":   bson.M{"status": models.DraftUnscheduled, "updated_at": time.Now()},
+		"$unset": bson.M{"publish_at": "", "error": "", "attempts": ""},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := s.updateUnleased(ctx, userID, draftID, filter, update)
+	if errors.Is(err, errDraftNotMatched) {
+		return dto.ErrDraftNotScheduled
+	}
+	return err
+}
+
+// DeleteDraft deletes a draft of the user unless it is being published
+func (s *DraftStorage) DeleteDraft(ctx context.Context, userID, draftID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := notLeased(time.Now())
+	filter["_id"] = draftID
+	filter["creator_id"] = userID
+
+	result, err := s.db.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		if err := s.whyNotMatched(ctx, userID, draftID); err != nil {
+			return err
+		}
+		return dto.ErrDraftPublishing // its lease ran out just now
+	}
+
+	return nil
+}
+
+// errDraftNotMatched is returned by updateUnleased when the draft exists and is not being
+// published, but did not match the rest of the filter
+var errDraftNotMatched = errors.New("draft did not match")
+
+// updateUnleased applies update to a draft of the user matching filter, unless it is being published
+func (s *DraftStorage) updateUnleased(ctx context.Context, userID, draftID primitive.ObjectID, filter, update bson.M) error {
+	filter["$and"] = []bson.M{notLeased(time.Now())}
+	filter["_id"] = draftID
+	filter["creator_id"] = userID
+
+	result, err := s.db.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if err := s.whyNotMatched(ctx, userID, draftID); err != nil {
+			return err
+		}
+		return errDraftNotMatched
+	}
+
+	return nil
+}
+
+// whyNotMatched tells why a draft of the user was not matched: dto.ErrDraftNotFound if it does
+// not exist, dto.ErrDraftPublishing if it is leased. nil means it did not match for another reason.
+func (s *DraftStorage) whyNotMatched(ctx context.Context, userID, draftID primitive.ObjectID) error {
+	draft, err := s.GetDraft(ctx, userID, draftID)
+	if err != nil {
+		return err
+	}
+	if draft.LeaseUntil != nil && draft.LeaseUntil.After(time.Now()) {
+		return dto.ErrDraftPublishing
+	}
+	return nil
+}
+
+// ClaimDueDraft atomically takes the scheduled draft that was due first and nobody is publishing,
+// leasing it until now+lease. It returns dto.ErrDraftNotFound when no draft is due.
+func (s *DraftStorage) ClaimDueDraft(ctx context.Context, lease time.Duration) (*models.Draft, error) {
+	now := time.Now()
+	filter := notLeased(now)
+	filter["status"] = models.DraftScheduled
+	filter["publish_at"] = bson.M{"$lte": now}
+
+	return s.claim(ctx, filter, lease)
+}
+
+// ClaimDraft leases a draft of the user to publish it now, dto.ErrDraftPublishing if it is
+// being published already
+func (s *DraftStorage) ClaimDraft(ctx context.Context, userID, draftID primitive.ObjectID, lease time.Duration) (*models.Draft, error) {
+	filter := notLeased(time.Now())
+	filter["_id"] = draftID
+	filter["creator_id"] = userID
+
+	draft, err := s.claim(ctx, filter, lease)
+	if errors.Is(err, dto.ErrDraftNotFound) {
+		if err := s.whyNotMatched(ctx, userID, draftID); err != nil {
+			return nil, err
+		}
+		return nil, dto.ErrDraftPublishing // its lease ran out just now
+	}
+	return draft, err
+}
+
+func (s *DraftStorage) claim(ctx context.Context, filter bson.M, lease time.Duration) (*models.Draft, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{"lease_until": time.Now().Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "publish_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var draft models.Draft
+	err := s.db.FindOneAndUpdate(ctx, filter, update, opts).Decode(&draft)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &draft, nil
+}
+
+// RetryDraft records why publishing failed and keeps the draft leased until retryAt, when it
+// can be claimed again
+func (s *DraftStorage) RetryDraft(ctx context.Context, draftID primitive.ObjectID, publishErr error, retryAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": draftID}, bson.M{"$set": bson.M{
+		"error":       publishErr.Error(),
+		"lease_until": retryAt,
+	}})
+	return err
+}
+
+// FailDraft gives up publishing a scheduled draft, the creator has to edit or publish it
+func (s *DraftStorage) FailDraft(ctx context.Context, draftID primitive.ObjectID, publishErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": draftID}, bson.M{
+		"$set":   bson.M{"status": models.DraftFailed, "error": publishErr.Error(), "updated_at": time.Now()},
+		"$unset": bson.M{"lease_until": ""},
+	})
+	return err
+}
+
+// DeletePublishedDraft removes a draft once its post is published
+func (s *DraftStorage) DeletePublishedDraft(ctx context.Context, draftID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteOne(ctx, bson.M{"_id": draftID})
+	return err
+}
+
+// DeleteDraftsByIDs removes the given drafts
+func (s *DraftStorage) DeleteDraftsByIDs(ctx context.Context, ids []primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
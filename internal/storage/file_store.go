@@ -3,12 +3,19 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
 	"github.com/ruziba3vich/soand/pkg/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type (
@@ -25,7 +32,15 @@ func NewFileStorage(cfg *config.Config, minio_client *minio.Client) *FileStorage
 	}
 }
 
-func (s *FileStorage) UploadFile(file *multipart.FileHeader) (string, error) {
+// ErrFileNotFound is returned by FileOwner for a file that is not in the bucket
+var ErrFileNotFound = errors.New("file not found")
+
+// ownerMetadata is the object metadata the uploader of a file is recorded in
+const ownerMetadata = "Owner"
+
+// UploadFile stores the file under a random name, recording ownerID as its uploader unless it is
+// zero, for files of the server itself like backgrounds
+func (s *FileStorage) UploadFile(file *multipart.FileHeader, ownerID primitive.ObjectID) (string, error) {
 
 	// Open file
 	f, err := file.Open()
@@ -34,8 +49,17 @@ func (s *FileStorage) UploadFile(file *multipart.FileHeader) (string, error) {
 	}
 	defer f.Close()
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%d", time.Now().UnixMilli())
+	// Generate unique filename, random so nobody can overwrite or guess the files of others
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to name file: %s", err.Error())
+	}
+	filename := hex.EncodeToString(random)
+
+	opts := minio.PutObjectOptions{ContentType: file.Header.Get("Content-Type")}
+	if !ownerID.IsZero() {
+		opts.UserMetadata = map[string]string{ownerMetadata: ownerID.Hex()}
+	}
 
 	// Upload file to MinIO
 	_, err = s.minio_client.PutObject(
@@ -44,7 +68,7 @@ func (s *FileStorage) UploadFile(file *multipart.FileHeader) (string, error) {
 		filename,
 		f,
 		file.Size,
-		minio.PutObjectOptions{ContentType: file.Header.Get("Content-Type")},
+		opts,
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file: %s", err.Error())
@@ -106,3 +130,142 @@ func (s *FileStorage) DeleteFile(filename string) error {
 
 	return nil
 }
+
+// FileOwner returns the user who uploaded the file, a zero ID if no user did
+func (s *FileStorage) FileOwner(ctx context.Context, filename string) (primitive.ObjectID, error) {
+	info, err := s.minio_client.StatObject(ctx, s.cfg.MinIO.Bucket, filename, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return primitive.NilObjectID, ErrFileNotFound
+	}
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("failed to stat file %s: %s", filename, err.Error())
+	}
+
+	owner, err := primitive.ObjectIDFromHex(info.UserMetadata[ownerMetadata])
+	if err != nil {
+		return primitive.NilObjectID, nil
+	}
+	return owner, nil
+}
+
+// RemoveOwnedFile deletes the file if ownerID uploaded it, or if nobody is recorded as its uploader:
+// those were uploaded before uploaders were recorded, and attaching them is refused since, so only
+// the documents of their uploader still refer to them. Files of others and missing files are left
+// alone without an error, so a document referring to them can still be cleaned up.
+func (s *FileStorage) RemoveOwnedFile(ctx context.Context, filename string, ownerID primitive.ObjectID) error {
+	owner, err := s.FileOwner(ctx, filename)
+	if errors.Is(err, ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !owner.IsZero() && owner != ownerID {
+		return nil
+	}
+
+	return s.RemoveFile(ctx, filename)
+}
+
+// RemoveFile deletes a file without checking it exists first. Removing a missing file is not
+// an error, which makes it safe to retry, so cleanup jobs use it instead of DeleteFile.
+func (s *FileStorage) RemoveFile(ctx context.Context, filename string) error {
+	err := s.minio_client.RemoveObject(ctx, s.cfg.MinIO.Bucket, filename, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete file %s: %s", filename, err.Error())
+	}
+	return nil
+}
+
+// ReadFile opens a stored file for reading. The caller must close it.
+func (s *FileStorage) ReadFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	object, err := s.minio_client.GetObject(ctx, s.cfg.MinIO.Bucket, filename, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %s", filename, err.Error())
+	}
+
+	// GetObject is lazy, Stat surfaces a missing file before anything is read
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to read file %s: %s", filename, err.Error())
+	}
+
+	return object, nil
+}
+
+// UploadNamedFile stores a file under the given name, e.g. "exports/<user>/<job>.zip"
+func (s *FileStorage) UploadNamedFile(ctx context.Context, filename string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.minio_client.PutObject(ctx, s.cfg.MinIO.Bucket, filename, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to upload file %s: %s", filename, err.Error())
+	}
+	return nil
+}
+
+// GetFileWithExpiry returns a pre-signed download link that stops working after expiry
+func (s *FileStorage) GetFileWithExpiry(ctx context.Context, filename string, expiry time.Duration) (string, error) {
+	url, err := s.minio_client.PresignedGetObject(ctx, s.cfg.MinIO.Bucket, filename, expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get file: %s", err.Error())
+	}
+	return url.String(), nil
+}
+
+// RemoveFilesWithPrefix deletes every file whose name starts with prefix
+func (s *FileStorage) RemoveFilesWithPrefix(ctx context.Context, prefix string) error {
+	return s.ForEachFileWithPrefix(ctx, prefix, func(filename string) error {
+		return s.RemoveFile(ctx, filename)
+	})
+}
+
+// ForEachFileWithPrefix calls fn with the name of every file whose name starts with prefix
+func (s *FileStorage) ForEachFileWithPrefix(ctx context.Context, prefix string, fn func(string) error) error {
+	ctx, cancel := context.WithCancel(ctx) // stops the listing when fn fails
+	defer cancel()
+
+	objects := s.minio_client.ListObjects(ctx, s.cfg.MinIO.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list files under %s: %s", prefix, object.Err.Error())
+		}
+		if err := fn(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireFilesWithPrefix makes MinIO delete files under prefix once they are older than days. The
+// other lifecycle rules of the bucket are kept.
+func (s *FileStorage) ExpireFilesWithPrefix(ctx context.Context, prefix string, days int) error {
+	config, err := s.minio_client.GetBucketLifecycle(ctx, s.cfg.MinIO.Bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("failed to get lifecycle of bucket: %s", err.Error())
+		}
+		config = lifecycle.NewConfiguration()
+	}
+
+	rule := lifecycle.Rule{
+		ID:         "expire-" + strings.Trim(prefix, "/"),
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: prefix},
+		Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(days)},
+	}
+
+	replaced := false
+	for i := range config.Rules {
+		if config.Rules[i].ID == rule.ID {
+			config.Rules[i] = rule
+			replaced = true
+		}
+	}
+	if !replaced {
+		config.Rules = append(config.Rules, rule)
+	}
+
+	if err := s.minio_client.SetBucketLifecycle(ctx, s.cfg.MinIO.Bucket, config); err != nil {
+		return fmt.Errorf("failed to set expiry of %s: %s", prefix, err.Error())
+	}
+	return nil
+}
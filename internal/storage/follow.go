@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FollowStorage struct {
+	db    *mongo.Collection
+	users *mongo.Collection
+}
+
+// NewFollowStorage initializes FollowStorage. The users collection is joined to list followers.
+func NewFollowStorage(db *mongo.Collection, users *mongo.Collection) *FollowStorage {
+	return &FollowStorage{
+		db:    db,
+		users: users,
+	}
+}
+
+// EnsureIndexes makes every pair of users follow at most once and indexes both directions for listing
+func (s *FollowStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "follower_id", Value: 1}, {Key: "followee_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "followee_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "follower_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+// Follow makes follower follow followee with the given status and returns the status of the follow,
+// and whether this call created it. Following again does not change an existing follow, so a
+// pending request stays pending.
+func (s *FollowStorage) Follow(ctx context.Context, followerID, followeeID primitive.ObjectID, status string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	id := primitive.NewObjectIDFromTimestamp(now)
+	follow := bson.M{
+		"_id":        id,
+		"status":     status,
+		"created_at": now,
+	}
+	if status == models.FollowAccepted {
+		follow["accepted_at"] = now
+	}
+
+	filter := bson.M{"follower_id": followerID, "followee_id": followeeID}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var result models.Follow
+	err := s.db.FindOneAndUpdate(ctx, filter, bson.M{"$setOnInsert": follow}, opts).Decode(&result)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent request inserted the same follow first
+		err = s.db.FindOne(ctx, filter).Decode(&result)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return result.Status, result.ID == id, nil
+}
+
+// Unfollow removes the follow, or withdraws the request, and reports whether there was one
+func (s *FollowStorage) Unfollow(ctx context.Context, followerID, followeeID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.DeleteOne(ctx, bson.M{"follower_id": followerID, "followee_id": followeeID})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount > 0, nil
+}
+
+// GetStatus returns the status of follower following followee, or "" if they do not
+func (s *FollowStorage) GetStatus(ctx context.Context, followerID, followeeID primitive.ObjectID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var follow models.Follow
+	err := s.db.FindOne(ctx, bson.M{"follower_id": followerID, "followee_id": followeeID}).Decode(&follow)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return follow.Status, nil
+}
+
+// AcceptRequest turns the pending request of follower into a follow
+func (s *FollowStorage) AcceptRequest(ctx context.Context, followeeID, followerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"follower_id": followerID, "followee_id": followeeID, "status": models.FollowPending},
+		bson.M{"$set": bson.M{"status": models.FollowAccepted, "accepted_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return dto.ErrFollowRequestAbsent
+	}
+
+	return nil
+}
+
+// RejectRequest deletes the pending request of follower
+func (s *FollowStorage) RejectRequest(ctx context.Context, followeeID, followerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.DeleteOne(ctx,
+		bson.M{"follower_id": followerID, "followee_id": followeeID, "status": models.FollowPending},
+	)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return dto.ErrFollowRequestAbsent
+	}
+
+	return nil
+}
+
+// AcceptAllRequests accepts every pending request to the user, e.g. when their account becomes public
+func (s *FollowStorage) AcceptAllRequests(ctx context.Context, followeeID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateMany(ctx,
+		bson.M{"followee_id": followeeID, "status": models.FollowPending},
+		bson.M{"$set": bson.M{"status": models.FollowAccepted, "accepted_at": time.Now()}},
+	)
+	return err
+}
+
+// CountFollowers returns how many users follow the user
+func (s *FollowStorage) CountFollowers(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.db.CountDocuments(ctx, bson.M{"followee_id": userID, "status": models.FollowAccepted})
+}
+
+// CountFollowing returns how many users the user follows
+func (s *FollowStorage) CountFollowing(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.db.CountDocuments(ctx, bson.M{"follower_id": userID, "status": models.FollowAccepted})
+}
+
+// GetFollowers returns a page of the users following the user with the given status, newest first.
+// Pending followers are the user's incoming follow requests.
+func (s *FollowStorage) GetFollowers(ctx context.Context, userID primitive.ObjectID, status string, page, pageSize int64) ([]*models.UserSummary, error) {
+	return listUserSummaries(ctx, s.db, s.users, bson.M{"followee_id": userID, "status": status}, "follower_id", page, pageSize)
+}
+
+// GetFollowing returns a page of the users the user follows, newest first
+func (s *FollowStorage) GetFollowing(ctx context.Context, userID primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	return listUserSummaries(ctx, s.db, s.users, bson.M{"follower_id": userID, "status": models.FollowAccepted}, "followee_id", page, pageSize)
+}
+
+// listUserSummaries pages through the documents of db matching filter, newest first, and joins the
+// user in userField of each. Deactivated and deleted users are left out after paging, so a page can come back short.
+func listUserSummaries(ctx context.Context, db, users *mongo.Collection, filter bson.M, userField string, page, pageSize int64) ([]*models.UserSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+		{{Key: "$skip", Value: (page - 1) * pageSize}},
+		{{Key: "$limit", Value: pageSize}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         users.Name(),
+			"localField":   userField,
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$unwind", Value: "$user"}},
+		{{Key: "$match", Value: bson.M{
+			"user.deactivated_at": bson.M{"$exists": false},
+			"user.deleting_at":    bson.M{"$exists": false},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         "$user._id",
+			"full_name":   "$user.full_name",
+			"username":    "$user.username",
+			"profile_pic": publicProfilePic("$user."),
+			"verified":    "$user.verified",
+			"since":       "$created_at",
+		}}},
+	}
+
+	cursor, err := db.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	summaries := []*models.UserSummary{}
+	if err := cursor.All(ctx, &summaries); err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+// publicProfilePic projects the first profile picture of the user document whose fields start with
+// prefix, or nothing if the user shows their pictures to fewer people than everyone.
+// It mirrors models.User.PublicProfilePic.
+func publicProfilePic(prefix string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$in": bson.A{prefix + "settings.profile_pictures", bson.A{models.AudienceFollowers, models.AudienceNobody}}},
+		"$$REMOVE",
+		bson.M{"$arrayElemAt": bson.A{prefix + "profile_pics.url", 0}},
+	}}
+}
+
+// GetFollowsOfUser returns every follow and request the user is part of, in either direction
+func (s *FollowStorage) GetFollowsOfUser(ctx context.Context, userID primitive.ObjectID) ([]*models.Follow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{{"follower_id": userID}, {"followee_id": userID}}}
+	cursor, err := s.db.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	follows := []*models.Follow{}
+	if err := cursor.All(ctx, &follows); err != nil {
+		return nil, err
+	}
+
+	return follows, nil
+}
+
+// DeleteByUser removes every follow and request the user is part of, in either direction
+func (s *FollowStorage) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"$or": []bson.M{{"follower_id": userID}, {"followee_id": userID}}})
+	return err
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type JobStorage struct {
+	db *mongo.Collection
+}
+
+// NewJobStorage initializes JobStorage
+func NewJobStorage(db *mongo.Collection) *JobStorage {
+	return &JobStorage{db: db}
+}
+
+// EnsureIndexes creates the indexes used to claim jobs and look them up per user
+func (s *JobStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_after", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}}},
+	})
+	return err
+}
+
+// CreateJob queues a new job
+func (s *JobStorage) CreateJob(ctx context.Context, jobType string, userID primitive.ObjectID) (*models.Job, error) {
+	now := time.Now()
+	job := &models.Job{
+		ID:        primitive.NewObjectIDFromTimestamp(now),
+		Type:      jobType,
+		UserID:    userID,
+		Status:    models.JobPending,
+		RunAfter:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := s.db.InsertOne(ctx, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// GetJob fetches a job of the user
+func (s *JobStorage) GetJob(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var job models.Job
+	err := s.db.FindOne(ctx, bson.M{"_id": jobID, "user_id": userID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// FindUnfinishedJob returns the user's pending or running job of the given type, if any
+func (s *JobStorage) FindUnfinishedJob(ctx context.Context, userID primitive.ObjectID, jobType string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id": userID,
+		"type":    jobType,
+		"status":  bson.M{"$in": []string{models.JobPending, models.JobRunning}},
+	}
+
+	var job models.Job
+	err := s.db.FindOne(ctx, filter).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ClaimJob atomically takes the oldest job of the given types that is pending or whose lease
+// has run out, leasing it until now+lease. It returns dto.ErrJobNotFound when there is nothing to do.
+func (s *JobStorage) ClaimJob(ctx context.Context, jobTypes []string, lease time.Duration) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"type": bson.M{"$in": jobTypes},
+		"$or": []bson.M{
+			{"status": models.JobPending, "run_after": bson.M{"$lte": now}},
+			{"status": models.JobRunning, "lease_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": models.JobRunning, "lease_until": now.Add(lease), "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.Job
+	err := s.db.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// UpdateProgress records the step the job is on and extends its lease
+func (s *JobStorage) UpdateProgress(ctx context.Context, jobID primitive.ObjectID, step string, done, total int, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": bson.M{
+		"step":        step,
+		"steps_done":  done,
+		"steps_total": total,
+		"lease_until": now.Add(lease),
+		"updated_at":  now,
+	}})
+	return err
+}
+
+// CompleteJob marks a job as done, storing its result if it has one
+func (s *JobStorage) CompleteJob(ctx context.Context, jobID primitive.ObjectID, result string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{
+		"$set": bson.M{
+			"status":       models.JobCompleted,
+			"step":         "",
+			"result":       result,
+			"updated_at":   now,
+			"completed_at": now,
+		},
+		"$unset": bson.M{"lease_until": "", "error": ""},
+	})
+	return err
+}
+
+// RetryJob records an error and releases the job to be claimed again after retryAt
+func (s *JobStorage) RetryJob(ctx context.Context, jobID primitive.ObjectID, jobErr error, retryAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{
+		"$set": bson.M{
+			"status":     models.JobPending,
+			"error":      jobErr.Error(),
+			"run_after":  retryAt,
+			"updated_at": time.Now(),
+		},
+		"$unset": bson.M{"lease_until": ""},
+	})
+	return err
+}
+
+// FailJob gives up on a job, recording the error that stopped it
+func (s *JobStorage) FailJob(ctx context.Context, jobID primitive.ObjectID, jobErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{
+		"$set": bson.M{
+			"status":     models.JobFailed,
+			"error":      jobErr.Error(),
+			"updated_at": time.Now(),
+		},
+		"$unset": bson.M{"lease_until": ""},
+	})
+	return err
+}
@@ -3,10 +3,12 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type LikesStorage struct {
@@ -50,3 +52,53 @@ func (s *LikesStorage) HasUserLiked(ctx context.Context, userID, postID primitiv
 	}
 	return true, nil // user has liked the post
 }
+
+// GetLikedPostIDs returns up to limit ids of posts the user has liked
+func (s *LikesStorage) GetLikedPostIDs(ctx context.Context, userID primitive.ObjectID, limit int64) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"post_id": 1}).SetLimit(limit)
+	cursor, err := s.db.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var likes []struct {
+		PostID primitive.ObjectID `bson:"post_id"`
+	}
+	if err := cursor.All(ctx, &likes); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(likes))
+	for i, like := range likes {
+		ids[i] = like.PostID
+	}
+
+	return ids, nil
+}
+
+// RemoveLike deletes a like and reports whether it existed, so callers only adjust
+// the post's like count once even when retried
+func (s *LikesStorage) RemoveLike(ctx context.Context, userID, postID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.DeleteOne(ctx, bson.M{"user_id": userID, "post_id": postID})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount == 1, nil
+}
+
+// DeleteLikesByPost removes every like of a post
+func (s *LikesStorage) DeleteLikesByPost(ctx context.Context, postID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"post_id": postID})
+	return err
+}
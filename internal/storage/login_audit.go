@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginAuditStorage struct {
+	db        *mongo.Collection
+	retention time.Duration
+}
+
+// NewLoginAuditStorage initializes LoginAuditStorage. Entries are removed by MongoDB after retention.
+func NewLoginAuditStorage(db *mongo.Collection, retention time.Duration) *LoginAuditStorage {
+	return &LoginAuditStorage{
+		db:        db,
+		retention: retention,
+	}
+}
+
+// EnsureIndexes expires old entries and indexes lookups by username and IP
+func (s *LoginAuditStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(s.retention.Seconds())),
+		},
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Record stores a failed login
+func (s *LoginAuditStorage) Record(ctx context.Context, entry *models.LoginAudit) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	entry.CreatedAt = time.Now()
+	entry.ID = primitive.NewObjectIDFromTimestamp(entry.CreatedAt)
+
+	_, err := s.db.InsertOne(ctx, entry)
+	return err
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"time"
 
+	"github.com/ruziba3vich/soand/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -52,3 +54,48 @@ func (p *PinnedChat) GetPinnedChatsByUser(ctx context.Context, userID primitive.
 
 	return results, nil
 }
+
+// DeleteByUser removes every pin of the user
+func (p *PinnedChat) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := p.db.DeleteMany(ctx, bson.M{"user_id": userID, "chat_id": bson.M{"$exists": true}})
+	return err
+}
+
+// DeleteByChat removes every user's pin of a chat, e.g. when its post is deleted
+func (p *PinnedChat) DeleteByChat(ctx context.Context, chatID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := p.db.DeleteMany(ctx, bson.M{"chat_id": chatID})
+	return err
+}
+
+// GetAllPinnedChatsByUser returns every chat the user has pinned
+func (p *PinnedChat) GetAllPinnedChatsByUser(ctx context.Context, userID primitive.ObjectID) ([]models.PinnedChat, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := p.db.Find(ctx, bson.M{"user_id": userID, "chat_id": bson.M{"$exists": true}, "pinned": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var pins []struct {
+		ChatID primitive.ObjectID `bson:"chat_id"`
+		Pinned bool               `bson:"pinned"`
+	}
+	if err := cursor.All(ctx, &pins); err != nil {
+		return nil, err
+	}
+
+	chats := make([]models.PinnedChat, len(pins))
+	for i, pin := range pins {
+		chats[i] = models.PinnedChat{ChatId: pin.ChatID.Hex(), Pinned: pin.Pinned}
+	}
+
+	return chats, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	dto "github.com/ruziba3vich/soand/internal/dtos"
 	"github.com/ruziba3vich/soand/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,6 +14,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var ErrPostNotFound = errors.New("post not found")
+
 type Storage struct {
 	db            *mongo.Collection
 	users_storage *UserStorage
@@ -29,9 +32,12 @@ func NewStorage(
 	}
 }
 
-// CreatePost inserts a new post into the database
+// CreatePost inserts a new post into the database. A post published from a draft keeps the
+// draft's ID, so publishing it twice fails with a duplicate key error.
 func (s *Storage) CreatePost(ctx context.Context, post *models.Post, deleteAfter int) error {
-	post.ID = primitive.NewObjectID()
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
 	post.CreatedAt = time.Now()
 	post.DeleteAt = post.CreatedAt.Add(time.Duration(deleteAfter) * time.Hour)
 	if post.Pictures == nil {
@@ -52,7 +58,7 @@ func (s *Storage) GetPost(ctx context.Context, id primitive.ObjectID) (*models.P
 	var post models.Post
 	err := s.db.FindOne(ctx, bson.M{"_id": id}).Decode(&post)
 	if err == mongo.ErrNoDocuments {
-		return nil, errors.New("post not found")
+		return nil, ErrPostNotFound
 	} else if err != nil {
 		return nil, err
 	}
@@ -60,28 +66,113 @@ func (s *Storage) GetPost(ctx context.Context, id primitive.ObjectID) (*models.P
 	return &post, nil
 }
 
-func (s *Storage) UpdatePost(ctx context.Context, id, updaterID primitive.ObjectID, update bson.M) error {
-	post, err := s.GetPost(ctx, id)
+// EditPost sets the given content fields of post and moves it to the next version. It only applies
+// if the post is still at the version it was read at, dto.ErrPostVersionConflict tells it was edited meanwhile.
+func (s *Storage) EditPost(ctx context.Context, post *models.Post, set bson.M) (*models.Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": post.ID, "version": post.Version}
+	if post.Version == 0 {
+		// posts from before versioning have no version field
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	set["edited_at"] = time.Now()
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+
+	var edited models.Post
+	err := s.db.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&edited)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, dto.ErrPostVersionConflict
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &edited, nil
+}
+
+// SetLifetime makes post expire at deleteAt, or never with a nil deleteAt. It only applies if the
+// post still expires when it did when it was read, dto.ErrPostChanged tells it was changed meanwhile.
+// A post that expired meanwhile is left to the expiry sweeper.
+func (s *Storage) SetLifetime(ctx context.Context, post *models.Post, deleteAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": post.ID}
+	if post.Permanent {
+		filter["permanent"] = true
+	} else {
+		filter["delete_at"] = bson.M{"$eq": post.DeleteAt, "$gt": time.Now()}
 	}
-	// Check if the updater is the creator
-	if post.CreatorId != updaterID {
-		return errors.New("only the creator can update this post")
+
+	// the expiry warning goes out again for the new delete_at
+	update := bson.M{"$set": bson.M{"delete_at": deleteAt, "permanent": false}, "$unset": bson.M{"expiry_notified": ""}}
+	if deleteAt == nil {
+		update = bson.M{"$set": bson.M{"permanent": true}, "$unset": bson.M{"delete_at": "", "expiry_notified": ""}}
 	}
 
-	// Perform the update
-	result, err := s.db.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": update})
+	result, err := s.db.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	if result.MatchedCount == 0 {
-		return errors.New("failed to update post")
+		return dto.ErrPostChanged
 	}
 
 	return nil
 }
 
+// GetPostsToWarn returns up to limit posts expiring by warnAt whose creator was not warned yet
+func (s *Storage) GetPostsToWarn(ctx context.Context, warnAt time.Time, limit int64) ([]*models.Post, error) {
+	return s.getExpiringPosts(ctx, bson.M{
+		"delete_at":       bson.M{"$gt": time.Now(), "$lte": warnAt},
+		"expiry_notified": bson.M{"$ne": true},
+	}, limit)
+}
+
+// GetExpiredPosts returns up to limit posts that expired by now
+func (s *Storage) GetExpiredPosts(ctx context.Context, now time.Time, limit int64) ([]*models.Post, error) {
+	return s.getExpiringPosts(ctx, bson.M{"delete_at": bson.M{"$lte": now}}, limit)
+}
+
+func (s *Storage) getExpiringPosts(ctx context.Context, filter bson.M, limit int64) ([]*models.Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.db.Find(ctx, filter, options.Find().SetSort(bson.M{"delete_at": 1}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var posts []*models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// MarkExpiryNotified records that the creator was warned about the post expiring. It reports false
+// if the warning was claimed already or the lifetime changed since the post was read, so only one
+// instance sends each warning.
+func (s *Storage) MarkExpiryNotified(ctx context.Context, post *models.Post) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": post.ID, "delete_at": post.DeleteAt, "expiry_notified": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"expiry_notified": true}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
 // DeletePost permanently removes a post from the database
 func (s *Storage) DeletePost(ctx context.Context, id primitive.ObjectID) error {
 	result, err := s.db.DeleteOne(ctx, bson.M{"_id": id})
@@ -89,7 +180,7 @@ func (s *Storage) DeletePost(ctx context.Context, id primitive.ObjectID) error {
 		return err
 	}
 	if result.DeletedCount == 0 {
-		return errors.New("post not found")
+		return ErrPostNotFound
 	}
 	return nil
 }
@@ -139,15 +230,14 @@ func (s *Storage) GetAllPosts(ctx context.Context, page, pageSize int64) ([]mode
 			}
 		}
 
-		// Check if the owner's profile is hidden
-		if owner.HiddenProfile {
+		// Check if the owner's profile is hidden or deactivated
+		if owner.IsHidden() {
 			post.OwnerFullname = "Anonim user"
 			post.CreatorId = primitive.NilObjectID // Set to "00000" equivalent
 		} else {
 			post.OwnerFullname = owner.Fullname
-			if len(owner.ProfilePics) > 0 {
-				post.OwnerProfilePic = owner.ProfilePics[0].Url
-			}
+			post.OwnerProfilePic = owner.PublicProfilePic()
+			post.OwnerVerified = owner.Verified
 		}
 		posts = append(posts, post)
 	}
@@ -283,3 +373,44 @@ func (s *Storage) LikeOrDislikePost(ctx context.Context, userId primitive.Object
 
 // 	return nil
 // }
+
+// GetPostsByCreator returns up to limit posts of the user, oldest first
+func (s *Storage) GetPostsByCreator(ctx context.Context, creatorID primitive.ObjectID, limit int64) ([]*models.Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit)
+	cursor, err := s.db.Find(ctx, bson.M{"creator_id": creatorID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var posts []*models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// ForEachPostByCreator calls fn with every post of the user, oldest first
+func (s *Storage) ForEachPostByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.Post) error) error {
+	cursor, err := s.db.Find(ctx, bson.M{"creator_id": creatorID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var post models.Post
+		if err := cursor.Decode(&post); err != nil {
+			return err
+		}
+		if err := fn(&post); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PostArchivesPrefix is the bucket prefix under which FilePostArchive stores expired posts
+const PostArchivesPrefix = "archives/posts/"
+
+// archiveBatchSize is how many archived posts are deleted at a time
+const archiveBatchSize = 100
+
+// PostArchiveStorage archives expired posts to cold collections, the posts with an archived_at
+// field and their comments as they were
+type PostArchiveStorage struct {
+	posts    *mongo.Collection
+	comments *mongo.Collection
+}
+
+// NewPostArchiveStorage initializes PostArchiveStorage
+func NewPostArchiveStorage(posts, comments *mongo.Collection) *PostArchiveStorage {
+	return &PostArchiveStorage{posts: posts, comments: comments}
+}
+
+// EnsureIndexes creates the indexes the account deletion and export look archives up by
+func (s *PostArchiveStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := s.posts.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "creator_id", Value: 1}}}); err != nil {
+		return err
+	}
+	_, err := s.comments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "post_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	return err
+}
+
+// ArchivePost stores the post and its comments, replacing an earlier copy
+func (s *PostArchiveStorage) ArchivePost(ctx context.Context, archive *models.ArchivedPost) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if len(archive.Comments) > 0 {
+		writes := make([]mongo.WriteModel, len(archive.Comments))
+		for i, comment := range archive.Comments {
+			writes[i] = mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": comment.ID}).
+				SetReplacement(comment).
+				SetUpsert(true)
+		}
+		if _, err := s.comments.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+
+	// the post goes last, an archived post always has its whole thread
+	post, err := bson.Marshal(archive.Post)
+	if err != nil {
+		return err
+	}
+	document := bson.D{}
+	if err := bson.Unmarshal(post, &document); err != nil {
+		return err
+	}
+	document = append(document, bson.E{Key: "archived_at", Value: archive.ArchivedAt})
+
+	_, err = s.posts.ReplaceOne(ctx, bson.M{"_id": archive.Post.ID}, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+// archivedPostDocument is an archived post as it is stored in the cold collection
+type archivedPostDocument struct {
+	models.Post `bson:",inline"`
+	ArchivedAt  time.Time `bson:"archived_at"`
+}
+
+// ForEachPostByCreator calls fn with every archived post of the user, oldest first, without the comments
+func (s *PostArchiveStorage) ForEachPostByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.ArchivedPost) error) error {
+	cursor, err := s.posts.Find(ctx, bson.M{"creator_id": creatorID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document archivedPostDocument
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		if err := fn(&models.ArchivedPost{Post: &document.Post, ArchivedAt: document.ArchivedAt}); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// ForEachCommentOnPostsByCreator calls fn with every comment on the archived posts of the user, a
+// post at a time
+func (s *PostArchiveStorage) ForEachCommentOnPostsByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.Comment) error) error {
+	cursor, err := s.posts.Find(ctx, bson.M{"creator_id": creatorID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var post struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&post); err != nil {
+			return err
+		}
+		if err := s.forEachComment(ctx, bson.M{"post_id": post.ID}, fn); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// ForEachCommentByUser calls fn with every archived comment the user wrote, oldest first
+func (s *PostArchiveStorage) ForEachCommentByUser(ctx context.Context, userID primitive.ObjectID, fn func(*models.Comment) error) error {
+	return s.forEachComment(ctx, bson.M{"user_id": userID}, fn)
+}
+
+func (s *PostArchiveStorage) forEachComment(ctx context.Context, filter bson.M, fn func(*models.Comment) error) error {
+	cursor, err := s.comments.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var comment models.Comment
+		if err := cursor.Decode(&comment); err != nil {
+			return err
+		}
+		if err := fn(&comment); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// DeleteByUser removes the archived posts of the user with their threads, then the comments the
+// user wrote in other archived posts. The comments of a post go before it, so a retry finds them.
+func (s *PostArchiveStorage) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(archiveBatchSize)
+	for {
+		var posts []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		cursor, err := s.posts.Find(ctx, bson.M{"creator_id": userID}, opts)
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(ctx, &posts); err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			break
+		}
+
+		ids := make([]primitive.ObjectID, len(posts))
+		for i, post := range posts {
+			ids[i] = post.ID
+		}
+		if _, err := s.comments.DeleteMany(ctx, bson.M{"post_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		if _, err := s.posts.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.comments.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// FilePostArchive archives every expired post as a JSON file in the bucket, under PostArchivesPrefix
+// and the ID of its creator. Finding the comments of a user means reading every archive.
+type FilePostArchive struct {
+	files *FileStorage
+}
+
+// NewFilePostArchive initializes FilePostArchive
+func NewFilePostArchive(files *FileStorage) repos.PostArchiver {
+	return &FilePostArchive{files: files}
+}
+
+// ArchivePost uploads the post and its comments as archives/posts/<creator id>/<post id>.json
+func (s *FilePostArchive) ArchivePost(ctx context.Context, archive *models.ArchivedPost) error {
+	filename := postArchivePrefix(archive.Post.CreatorId) + archive.Post.ID.Hex() + ".json"
+	return s.write(ctx, filename, archive)
+}
+
+// ForEachPostByCreator calls fn with every archived post of the user, without the comments
+func (s *FilePostArchive) ForEachPostByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.ArchivedPost) error) error {
+	return s.files.ForEachFileWithPrefix(ctx, postArchivePrefix(creatorID), func(filename string) error {
+		archive, err := s.read(ctx, filename)
+		if err != nil {
+			return err
+		}
+		archive.Comments = nil
+		return fn(archive)
+	})
+}
+
+// ForEachCommentOnPostsByCreator calls fn with every comment on the archived posts of the user
+func (s *FilePostArchive) ForEachCommentOnPostsByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.Comment) error) error {
+	return s.files.ForEachFileWithPrefix(ctx, postArchivePrefix(creatorID), func(filename string) error {
+		archive, err := s.read(ctx, filename)
+		if err != nil {
+			return err
+		}
+		for _, comment := range archive.Comments {
+			if err := fn(comment); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForEachCommentByUser calls fn with every archived comment the user wrote, reading every archive
+func (s *FilePostArchive) ForEachCommentByUser(ctx context.Context, userID primitive.ObjectID, fn func(*models.Comment) error) error {
+	return s.files.ForEachFileWithPrefix(ctx, PostArchivesPrefix, func(filename string) error {
+		archive, err := s.read(ctx, filename)
+		if err != nil {
+			return err
+		}
+		for _, comment := range archive.Comments {
+			if comment.UserID != userID {
+				continue
+			}
+			if err := fn(comment); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteByUser removes the archives of the user's posts, then rewrites every other archive that
+// holds comments of the user without them
+func (s *FilePostArchive) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := s.files.RemoveFilesWithPrefix(ctx, postArchivePrefix(userID)); err != nil {
+		return err
+	}
+
+	return s.files.ForEachFileWithPrefix(ctx, PostArchivesPrefix, func(filename string) error {
+		archive, err := s.read(ctx, filename)
+		if err != nil {
+			return err
+		}
+
+		comments := make([]*models.Comment, 0, len(archive.Comments))
+		for _, comment := range archive.Comments {
+			if comment.UserID != userID {
+				comments = append(comments, comment)
+			}
+		}
+		if len(comments) == len(archive.Comments) {
+			return nil
+		}
+
+		archive.Comments = comments
+		return s.write(ctx, filename, archive)
+	})
+}
+
+func (s *FilePostArchive) read(ctx context.Context, filename string) (*models.ArchivedPost, error) {
+	reader, err := s.files.ReadFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var archive models.ArchivedPost
+	if err := json.NewDecoder(reader).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("failed to decode archive %s: %v", filename, err)
+	}
+	return &archive, nil
+}
+
+func (s *FilePostArchive) write(ctx context.Context, filename string, archive *models.ArchivedPost) error {
+	data, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+
+	return s.files.UploadNamedFile(ctx, filename, bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+// postArchivePrefix is where the archives of the posts of a user are kept in the bucket
+func postArchivePrefix(creatorID primitive.ObjectID) string {
+	return PostArchivesPrefix + creatorID.Hex() + "/"
+}
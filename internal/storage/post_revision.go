@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PostRevisionStorage keeps the earlier versions of edited posts
+type PostRevisionStorage struct {
+	db *mongo.Collection
+}
+
+// NewPostRevisionStorage initializes PostRevisionStorage
+func NewPostRevisionStorage(db *mongo.Collection) *PostRevisionStorage {
+	return &PostRevisionStorage{db: db}
+}
+
+// EnsureIndexes keeps one revision per version of a post and indexes them per creator
+func (s *PostRevisionStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "post_id", Value: 1}, {Key: "version", Value: -1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "creator_id", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	return err
+}
+
+// SaveRevision keeps the content post has at its current version. Saving a version again keeps
+// the first copy, which is the same content as long as the post was not edited in between.
+func (s *PostRevisionStorage) SaveRevision(ctx context.Context, post *models.Post) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	createdAt := post.CreatedAt
+	if post.EditedAt != nil {
+		createdAt = *post.EditedAt
+	}
+
+	revision := &models.PostRevision{
+		ID:          primitive.NewObjectID(),
+		PostID:      post.ID,
+		CreatorID:   post.CreatorId,
+		Version:     post.Version,
+		Title:       post.Title,
+		Description: post.Description,
+		Tags:        post.Tags,
+		Pictures:    post.Pictures,
+		CreatedAt:   createdAt,
+		ReplacedAt:  time.Now(),
+	}
+
+	_, err := s.db.UpdateOne(ctx,
+		bson.M{"post_id": post.ID, "version": post.Version},
+		bson.M{"$setOnInsert": revision},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetRevisions returns a page of the revisions of a post older than version, newest first
+func (s *PostRevisionStorage) GetRevisions(ctx context.Context, postID primitive.ObjectID, version int, page, pageSize int64) ([]*models.PostRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.M{"version": -1}).
+		SetSkip((page - 1) * pageSize).
+		SetLimit(pageSize)
+
+	cursor, err := s.db.Find(ctx, bson.M{"post_id": postID, "version": bson.M{"$lt": version}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []*models.PostRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// GetRevisionPictures returns the pictures of every revision of a post, for deleting them with it
+func (s *PostRevisionStorage) GetRevisionPictures(ctx context.Context, postID primitive.ObjectID) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	pictures, err := s.db.Distinct(ctx, "pictures", bson.M{"post_id": postID})
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0, len(pictures))
+	for _, picture := range pictures {
+		if filename, ok := picture.(string); ok {
+			filenames = append(filenames, filename)
+		}
+	}
+
+	return filenames, nil
+}
+
+// DeleteByPost removes every revision of a post
+func (s *PostRevisionStorage) DeleteByPost(ctx context.Context, postID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"post_id": postID})
+	return err
+}
+
+// ForEachRevisionByCreator calls fn with every revision of the user's posts, oldest first
+func (s *PostRevisionStorage) ForEachRevisionByCreator(ctx context.Context, creatorID primitive.ObjectID, fn func(*models.PostRevision) error) error {
+	cursor, err := s.db.Find(ctx, bson.M{"creator_id": creatorID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var revision models.PostRevision
+		if err := cursor.Decode(&revision); err != nil {
+			return err
+		}
+		if err := fn(&revision); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
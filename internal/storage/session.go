@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSessionRevoked = errors.New("session has been revoked or has expired")
+
+type SessionStorage struct {
+	db *mongo.Collection
+}
+
+// NewSessionStorage initializes SessionStorage
+func NewSessionStorage(db *mongo.Collection) *SessionStorage {
+	return &SessionStorage{
+		db: db,
+	}
+}
+
+// EnsureIndexes creates the user lookup index and lets MongoDB drop sessions once they expire
+func (s *SessionStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.M{"user_id": 1},
+		},
+		{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateSession stores a new session
+func (s *SessionStorage) CreateSession(ctx context.Context, session *models.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.InsertOne(ctx, session)
+	return err
+}
+
+// GetActiveSession returns the session if it exists, is not revoked and has not expired
+func (s *SessionStorage) GetActiveSession(ctx context.Context, sessionID primitive.ObjectID) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := s.db.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+
+	return &session, nil
+}
+
+// ListActiveSessions returns the user's sessions that are neither revoked nor expired, most recently used first
+func (s *SessionStorage) ListActiveSessions(ctx context.Context, userID primitive.ObjectID) ([]*models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.M{"last_seen_at": -1})
+
+	cursor, err := s.db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []*models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that the session has just been used
+func (s *SessionStorage) TouchSession(ctx context.Context, sessionID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": sessionID}, bson.M{"$set": bson.M{"last_seen_at": time.Now()}})
+	return err
+}
+
+// RotateRefreshToken swaps the stored refresh token hash only if it still equals oldHash,
+// so two concurrent refreshes with the same token cannot both succeed
+func (s *SessionStorage) RotateRefreshToken(ctx context.Context, sessionID primitive.ObjectID, oldHash, newHash string, expiresAt time.Time, meta *models.SessionMeta) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":                sessionID,
+		"refresh_token_hash": oldHash,
+		"revoked_at":         bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{
+		"refresh_token_hash": newHash,
+		"expires_at":         expiresAt,
+		"last_seen_at":       time.Now(),
+		"user_agent":         meta.UserAgent,
+		"ip":                 meta.IP,
+	}}
+
+	result, err := s.db.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionRevoked
+	}
+
+	return nil
+}
+
+// RevokeSession revokes a single session owned by the given user
+func (s *SessionStorage) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":        sessionID,
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}
+	result, err := s.db.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// RevokeAllSessions revokes every active session of the user except the ones listed in keep
+// and returns how many were revoked
+func (s *SessionStorage) RevokeAllSessions(ctx context.Context, userID primitive.ObjectID, keep ...primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}
+	if len(keep) > 0 {
+		filter["_id"] = bson.M{"$nin": keep}
+	}
+	result, err := s.db.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// DeleteAllSessions removes every session of the user, revoked or not
+func (s *SessionStorage) DeleteAllSessions(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
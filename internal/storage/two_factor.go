@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/pkg/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	accessTokenType       = "access"
+	twoFactorTokenType    = "2fa_challenge"
+	twoFactorChallengeTTL = 5 * time.Minute
+	totpIssuer            = "soand"
+	recoveryCodeCount     = 10
+	maxFailedCodes        = 5
+	twoFactorLockout      = 15 * time.Minute
+)
+
+// EnrollTwoFactor starts enabling 2FA by generating a new secret. The secret stays pending,
+// and login keeps working without a code, until ConfirmTwoFactor receives a valid first code.
+func (s *UserStorage) EnrollTwoFactor(ctx context.Context, userID primitive.ObjectID) (*models.TwoFactorEnrollment, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TwoFactor.Enabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"two_factor.pending_secret": secret}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store pending secret: %v", err)
+	}
+
+	account := userID.Hex()
+	if user.Username != nil {
+		account = *user.Username
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, account, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor enables 2FA once the user proves their app generates valid codes.
+// It returns the recovery codes in plain text; only their hashes are stored.
+func (s *UserStorage) ConfirmTwoFactor(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TwoFactor.Enabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	if user.TwoFactor.PendingSecret == "" {
+		return nil, fmt.Errorf("two-factor enrolment has not been started")
+	}
+
+	step, ok := totp.Validate(user.TwoFactor.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, dto.ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Matching on the pending secret keeps a concurrent re-enrolment from being confirmed with this code
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": userID, "two_factor.pending_secret": user.TwoFactor.PendingSecret},
+		bson.M{"$set": bson.M{"two_factor": models.TwoFactor{
+			Enabled:       true,
+			Secret:        user.TwoFactor.PendingSecret,
+			RecoveryCodes: hashes,
+			LastUsedStep:  step,
+		}}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("two-factor enrolment has changed, start again")
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor turns 2FA off. It needs both the password and a current code
+// (or a recovery code) so a stolen access token alone can not remove it. Users without
+// a password, who only sign in through an identity provider, give the code alone.
+func (s *UserStorage) DisableTwoFactor(ctx context.Context, userID primitive.ObjectID, password, code string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !user.TwoFactor.Enabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	if user.Password != "" && !CheckPassword(user.Password, password) {
+		return errors.New("incorrect password")
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"two_factor": models.TwoFactor{}}})
+	return err
+}
+
+// LoginTwoFactor finishes a login by exchanging the challenge token from Login and a
+// TOTP or recovery code for a new session
+func (s *UserStorage) LoginTwoFactor(ctx context.Context, challengeToken, code string, meta *models.SessionMeta) (*models.AuthTokens, error) {
+	claims, err := s.parseToken(challengeToken, twoFactorTokenType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired challenge token")
+	}
+
+	subject, _ := claims["user_id"].(string)
+	userID, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired challenge token")
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TwoFactor.Enabled {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	// deletion may have been requested since the challenge was issued
+	if user.DeletingAt != nil {
+		return nil, errors.New("this account is being deleted")
+	}
+
+	if user.DeactivatedAt != nil {
+		return nil, dto.ErrAccountDeactivated
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	return s.openSession(ctx, user, meta)
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code. Failures are
+// counted on the user and lock 2FA for a while, since a 6 digit code is easy to guess otherwise.
+func (s *UserStorage) verifySecondFactor(ctx context.Context, user *models.User, code string) error {
+	if time.Now().Before(user.TwoFactor.LockedUntil) {
+		return dto.ErrTwoFactorLocked
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+
+	if step, ok := totp.Validate(user.TwoFactor.Secret, code, time.Now()); ok {
+		// Only move forward in time, so the same code can not be replayed within its window
+		result, err := s.db.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "two_factor.last_used_step": bson.M{"$not": bson.M{"$gte": step}}},
+			bson.M{"$set": bson.M{"two_factor.last_used_step": step, "two_factor.failed_codes": 0}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 1 {
+			return nil
+		}
+	} else if len(code) > totp.Digits {
+		result, err := s.db.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "two_factor.recovery_codes": hashRecoveryCode(code)},
+			bson.M{
+				"$pull": bson.M{"two_factor.recovery_codes": hashRecoveryCode(code)},
+				"$set":  bson.M{"two_factor.failed_codes": 0},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 1 {
+			return nil
+		}
+	}
+
+	if user.TwoFactor.FailedCodes+1 >= maxFailedCodes {
+		_, err := s.db.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+			"two_factor.failed_codes": 0,
+			"two_factor.locked_until": time.Now().Add(twoFactorLockout),
+		}})
+		if err != nil {
+			return err
+		}
+		return dto.ErrTwoFactorLocked
+	}
+
+	if _, err := s.db.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$inc": bson.M{"two_factor.failed_codes": 1}}); err != nil {
+		return err
+	}
+
+	return dto.ErrInvalidTwoFactorCode
+}
+
+// generateChallengeToken signs the short-lived token returned by the password step of a 2FA login
+func (s *UserStorage) generateChallengeToken(userID primitive.ObjectID) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":     s.issuer,
+		"typ":     twoFactorTokenType,
+		"user_id": userID.Hex(),
+		"exp":     time.Now().Add(twoFactorChallengeTTL).Unix(),
+		"iat":     time.Now().Unix(),
+	}
+
+	return s.keys.Sign(claims)
+}
+
+// generateRecoveryCodes returns recovery codes formatted as "xxxxx-xxxxx" with their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery codes: %v", err)
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalises a recovery code the way users tend to type it and hashes it.
+// Recovery codes are random, so a fast hash is enough, like for refresh tokens.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	return hashRefreshToken(code)
+}
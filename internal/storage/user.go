@@ -2,47 +2,123 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/jwtkeys"
 	"github.com/ruziba3vich/soand/internal/models"
+	"github.com/ruziba3vich/soand/internal/passwords"
 	"github.com/ruziba3vich/soand/pkg/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// maxUsernameHistory is how many previous usernames are kept per user
+const maxUsernameHistory = 50
+
 type UserStorage struct {
 	db                 *mongo.Collection
-	secret             string
+	keys               *jwtkeys.KeySet
+	issuer             string
+	accessTokenTTL     time.Duration
+	refreshTokenTTL    time.Duration
 	background_storage *BackgroundStorage
+	session_storage    *SessionStorage
+	username           config.UsernameConfig
+	hasher             *passwords.Hasher
+	policy             passwords.Policy
+	dummyHash          string // checked against when the user does not exist, so both cases take as long
 }
 
 // NewUserStorage initializes UserStorage
-func NewUserStorage(db *mongo.Collection, config *config.Config, background_storage *BackgroundStorage) *UserStorage {
-	return &UserStorage{
+func NewUserStorage(db *mongo.Collection, config *config.Config, keys *jwtkeys.KeySet, background_storage *BackgroundStorage, session_storage *SessionStorage) *UserStorage {
+	s := &UserStorage{
 		db:                 db,
-		secret:             config.JwtSecret,
+		keys:               keys,
+		issuer:             config.Auth.Issuer,
+		accessTokenTTL:     config.Auth.AccessTokenTTL,
+		refreshTokenTTL:    config.Auth.RefreshTokenTTL,
 		background_storage: background_storage,
+		session_storage:    session_storage,
+		username:           config.Username,
+		hasher: passwords.NewHasher(passwords.Params{
+			Memory:      uint32(config.Password.Memory),
+			Iterations:  uint32(config.Password.Iterations),
+			Parallelism: uint8(config.Password.Parallelism),
+		}),
+		policy: passwords.Policy{
+			MinLength: config.Password.MinLength,
+			MaxLength: config.Password.MaxLength,
+		},
 	}
+	s.dummyHash, _ = s.hasher.Hash(primitive.NewObjectID().Hex())
+	return s
 }
 
-// CreateUser inserts a new user into the database and returns a JWT token
-func (s *UserStorage) CreateUser(ctx context.Context, user *models.User) (string, error) {
-	if len(user.Password) < 8 {
-		return "", fmt.Errorf("user password must be at least 8 characters long")
+// EnsureIndexes creates the indexes the user queries rely on
+func (s *UserStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// A verified phone number belongs to one account only, it is what password resets are sent to
+			Keys: bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"phone_verified": true}),
+		},
+		{
+			// Lets the purge sweeper find expired deactivations without scanning every user
+			Keys:    bson.D{{Key: "deactivated_at", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			// Finds who released a username, for reservations and old-handle redirects
+			Keys: bson.D{{Key: "username_history.username", Value: 1}},
+		},
+		{
+			// Prefix and trigram index behind user search
+			Keys: bson.D{{Key: "search_grams", Value: 1}},
+		},
+		identityIndex,
+	})
+	return err
+}
+
+// CreateUser inserts a new user into the database and opens the first session for it
+func (s *UserStorage) CreateUser(ctx context.Context, user *models.User, meta *models.SessionMeta) (*models.AuthTokens, error) {
+	if err := s.policy.Check(user.Password); err != nil {
+		return nil, err
 	}
 
-	hashedPassword, err := HashPassword(user.Password)
+	hashedPassword, err := s.hasher.Hash(user.Password)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	user.Password = hashedPassword
 	user.ID = primitive.NewObjectIDFromTimestamp(time.Now())
+	user.TwoFactor = models.TwoFactor{} // 2FA is only ever enabled through enrolment
+	user.PhoneVerified = false          // phones are only verified through an SMS code
+	user.Role = models.RoleUser         // roles are only ever granted by an admin
+	user.Verified = false               // the badge is only ever granted by a moderator
+	user.UsernameHistory = nil          // history only records renames, it would reserve usernames
+	user.Identities = nil               // accounts at providers are only ever linked through their login
+	user.LastSeen = nil
+	user.ProfilePics = nil
+	user.SearchGrams = searchGrams(user.Username, user.Fullname)
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -50,82 +126,366 @@ func (s *UserStorage) CreateUser(ctx context.Context, user *models.User) (string
 	if user.Username != nil {
 		exists, err := s.isUsernameTaken(ctx, *user.Username, user.ID)
 		if err != nil {
-			return "", fmt.Errorf("failed to check username availability: %s", err.Error())
+			return nil, fmt.Errorf("failed to check username availability: %s", err.Error())
 		}
 
 		if exists {
-			return "", fmt.Errorf("this username is already taken")
+			return nil, fmt.Errorf("this username is already taken")
 		}
 	}
 
 	_, err = s.db.InsertOne(ctx, user)
 	if err != nil {
-		return "", err
-	}
-
-	// Generate JWT token
-	token, err := GenerateJWT(user.ID.Hex(), s.secret)
-	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return token, nil
+	return s.openSession(ctx, user, meta)
 }
 
-// GenerateJWT generates a JWT token for a user
-func GenerateJWT(userID string, secret string) (string, error) {
+// GenerateJWT generates a short-lived access token bound to a session, signed with the active key
+func GenerateJWT(keys *jwtkeys.KeySet, issuer, userID, sessionID, role string, ttl time.Duration) (string, error) {
 	claims := jwt.MapClaims{
+		"iss":     issuer,
+		"typ":     accessTokenType,
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 45).Unix(), // Expires in 45 days
+		"sid":     sessionID,
+		"role":    role,
+		"exp":     time.Now().Add(ttl).Unix(),
 		"iat":     time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return keys.Sign(claims)
 }
 
-// ValidateJWT validates a JWT token and returns the user ID
-func (s *UserStorage) ValidateJWT(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.secret), nil
-	})
+// ValidateJWT validates an access token and makes sure its session has not been revoked
+func (s *UserStorage) ValidateJWT(tokenString string) (*models.TokenClaims, error) {
+	claims, err := s.parseToken(tokenString, accessTokenType)
+	if err != nil {
+		return nil, err
+	}
 
+	userID, _ := claims["user_id"].(string)
+	sessionID, _ := claims["sid"].(string)
+	role, _ := claims["role"].(string)
+	if userID == "" || sessionID == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	sid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	session, err := s.session_storage.GetActiveSession(context.Background(), sid)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if session.UserID.Hex() != userID {
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	// Extract claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userID, exists := claims["user_id"].(string); exists {
-			return userID, nil
+	// Only write last-seen once a minute instead of on every request
+	if time.Since(session.LastSeenAt) > time.Minute {
+		if err := s.session_storage.TouchSession(context.Background(), sid); err != nil {
+			return nil, err
 		}
 	}
 
-	return "", fmt.Errorf("invalid token")
+	return &models.TokenClaims{UserID: userID, SessionID: sessionID, Role: role}, nil
 }
 
-// Login checks user credentials and returns a JWT token
-func (s *UserStorage) Login(ctx context.Context, username, password string) (string, error) {
+// parseToken verifies the signature, expiry and issuer of a token and checks it is of the expected type,
+// so a 2FA challenge token can never be used as an access token and vice versa
+func (s *UserStorage) parseToken(tokenString, tokenType string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, s.keys.Keyfunc, jwt.WithValidMethods([]string{
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || !claims.VerifyIssuer(s.issuer, true) {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if typ, _ := claims["typ"].(string); typ != tokenType {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+// Login checks user credentials and opens a new session. Users with 2FA enabled get a
+// challenge token instead, which LoginTwoFactor exchanges for the session's tokens.
+func (s *UserStorage) Login(ctx context.Context, username, password string, meta *models.SessionMeta) (*models.LoginResult, error) {
+	user, err := s.checkCredentials(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DeletingAt != nil {
+		return nil, errors.New("this account is being deleted")
+	}
+
+	if user.DeactivatedAt != nil {
+		return nil, dto.ErrAccountDeactivated
+	}
+
+	return s.startLogin(ctx, user, meta)
+}
+
+// Reactivate logs a deactivated user back in, which cancels the pending purge of their account.
+// With 2FA enabled the account is reactivated but the login still needs the second factor.
+func (s *UserStorage) Reactivate(ctx context.Context, username, password string, meta *models.SessionMeta) (*models.LoginResult, error) {
+	user, err := s.checkCredentials(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DeletingAt != nil {
+		return nil, errors.New("this account is being deleted")
+	}
+
+	if user.DeactivatedAt == nil {
+		return nil, dto.ErrNotDeactivated
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// deleting_at is checked again so a purge queued in the meantime is not undone halfway
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "deleting_at": bson.M{"$exists": false}},
+		bson.M{"$unset": bson.M{"deactivated_at": ""}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reactivate account: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("this account is being deleted")
+	}
+	user.DeactivatedAt = nil
+
+	return s.startLogin(ctx, user, meta)
+}
+
+// checkCredentials returns the user with username if password is theirs. Unknown usernames and
+// wrong passwords fail the same way, in about the same time, so logins do not reveal who has an account.
+func (s *UserStorage) checkCredentials(ctx context.Context, username, password string) (*models.User, error) {
 	user, err := s.GetUserByUsername(ctx, username)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		CheckPassword(s.dummyHash, password)
+		return nil, dto.ErrInvalidCredentials
+	}
 	if err != nil {
-		return "", fmt.Errorf("user not found")
+		return nil, err
 	}
 
-	// Check password
 	if !CheckPassword(user.Password, password) {
-		return "", errors.New("invalid username or password")
+		return nil, dto.ErrInvalidCredentials
+	}
+	s.rehashPassword(ctx, user, password)
+
+	return user, nil
+}
+
+// startLogin opens a session for a user whose password was checked, or starts a 2FA challenge
+func (s *UserStorage) startLogin(ctx context.Context, user *models.User, meta *models.SessionMeta) (*models.LoginResult, error) {
+	if user.TwoFactor.Enabled {
+		challenge, err := s.generateChallengeToken(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &models.LoginResult{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ChallengeExpires:  int64(twoFactorChallengeTTL.Seconds()),
+		}, nil
 	}
 
-	// Generate JWT token
-	token, err := GenerateJWT(user.ID.Hex(), s.secret)
+	tokens, err := s.openSession(ctx, user, meta)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return token, nil
+	return &models.LoginResult{Tokens: tokens}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. The refresh token is rotated,
+// and presenting an already rotated token revokes the whole session since it was likely stolen.
+func (s *UserStorage) RefreshTokens(ctx context.Context, refreshToken string, meta *models.SessionMeta) (*models.AuthTokens, error) {
+	sessionHex, _, found := strings.Cut(refreshToken, ".")
+	if !found {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(sessionHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	session, err := s.session_storage.GetActiveSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	presentedHash := hashRefreshToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.RefreshTokenHash)) != 1 {
+		if err := s.session_storage.RevokeSession(ctx, session.UserID, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("refresh token reuse detected, session revoked")
+	}
+
+	// The role is read again on every refresh, so a changed role reaches the token within one access token lifetime
+	user, err := s.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil || user.DeletingAt != nil {
+		return nil, fmt.Errorf("account is no longer active")
+	}
+
+	newRefreshToken, err := generateRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.session_storage.RotateRefreshToken(
+		ctx,
+		session.ID,
+		presentedHash,
+		hashRefreshToken(newRefreshToken),
+		time.Now().Add(s.refreshTokenTTL),
+		meta,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.signTokens(user, session.ID, newRefreshToken)
+}
+
+// Logout revokes the session the access token was issued for
+func (s *UserStorage) Logout(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	return s.session_storage.RevokeSession(ctx, userID, sessionID)
+}
+
+// LogoutAll revokes every session of the user, logging them out of all devices
+func (s *UserStorage) LogoutAll(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.session_storage.RevokeAllSessions(ctx, userID)
+}
+
+// GetSessions lists the active sessions of the user and flags the one making the request
+func (s *UserStorage) GetSessions(ctx context.Context, userID, currentSessionID primitive.ObjectID) ([]*models.Session, error) {
+	sessions, err := s.session_storage.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+// RevokeOtherSessions revokes every session of the user except the current one
+func (s *UserStorage) RevokeOtherSessions(ctx context.Context, userID, currentSessionID primitive.ObjectID) (int64, error) {
+	return s.session_storage.RevokeAllSessions(ctx, userID, currentSessionID)
+}
+
+// recentLoginWindow is how long after logging in a user without a password can confirm sensitive
+// requests with the session alone
+const recentLoginWindow = 10 * time.Minute
+
+// ConfirmIdentity checks the password before a sensitive request. Users who only sign in through an
+// identity provider have none, they confirm with a second factor or a session opened just now.
+func (s *UserStorage) ConfirmIdentity(ctx context.Context, user *models.User, sessionID primitive.ObjectID, password, code string) error {
+	if user.Password != "" {
+		if !CheckPassword(user.Password, password) {
+			return errors.New("incorrect password")
+		}
+		return nil
+	}
+
+	if code != "" && user.TwoFactor.Enabled {
+		return s.verifySecondFactor(ctx, user, code)
+	}
+
+	session, err := s.session_storage.GetActiveSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != user.ID || time.Since(session.CreatedAt) > recentLoginWindow {
+		return dto.ErrRecentLoginNeeded
+	}
+	return nil
+}
+
+// openSession creates a session for the user and returns its first token pair
+func (s *UserStorage) openSession(ctx context.Context, user *models.User, meta *models.SessionMeta) (*models.AuthTokens, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:         primitive.NewObjectIDFromTimestamp(now),
+		UserID:     user.ID,
+		DeviceName: meta.DeviceName,
+		UserAgent:  meta.UserAgent,
+		IP:         meta.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.refreshTokenTTL),
+	}
+
+	refreshToken, err := generateRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenHash = hashRefreshToken(refreshToken)
+
+	if err := s.session_storage.CreateSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return s.signTokens(user, session.ID, refreshToken)
+}
+
+func (s *UserStorage) signTokens(user *models.User, sessionID primitive.ObjectID, refreshToken string) (*models.AuthTokens, error) {
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	accessToken, err := GenerateJWT(s.keys, s.issuer, user.ID.Hex(), sessionID.Hex(), role, s.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// generateRefreshToken returns an opaque "<session id>.<random secret>" token
+func generateRefreshToken(sessionID primitive.ObjectID) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	return sessionID.Hex() + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashRefreshToken hashes a refresh token before it is stored; the token itself is never persisted
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // GetUserByID fetches a user by their ID
@@ -158,6 +518,32 @@ func (s *UserStorage) GetUserByUsername(ctx context.Context, username string) (*
 	return &user, nil
 }
 
+// GetUserByPreviousUsername fetches the user who gave up the username within the reservation period.
+// Nobody else can take a reserved username, so at most one user matches.
+func (s *UserStorage) GetUserByPreviousUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := s.db.FindOne(ctx, bson.M{"username_history": s.releasedSince(username)}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// releasedSince matches history entries of the username that are still reserved. Entries dated
+// in the future were not written by a rename and never reserve anything.
+func (s *UserStorage) releasedSince(username string) bson.M {
+	now := time.Now()
+	return bson.M{"$elemMatch": bson.M{
+		"username":   username,
+		"changed_at": bson.M{"$gt": now.Add(-s.username.ReserveFor), "$lte": now},
+	}}
+}
+
 // DeleteUser removes a user from the database
 func (s *UserStorage) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -167,48 +553,198 @@ func (s *UserStorage) DeleteUser(ctx context.Context, userID primitive.ObjectID)
 	return err
 }
 
-// UpdateUsername updates a user's username after checking if it's available
+// MarkDeleting flags the user as being deleted, which blocks new logins. It fails if
+// deletion was already requested, so only one deletion job is ever queued per user.
+func (s *UserStorage) MarkDeleting(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": userID, "deleting_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deleting_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found or already being deleted")
+	}
+
+	return nil
+}
+
+// MarkDeactivatedDeleting flags a user deactivated since cutoff or earlier as being deleted. It reports
+// false if the user reactivated, reactivated and deactivated again since, or is being deleted already.
+func (s *UserStorage) MarkDeactivatedDeleting(ctx context.Context, userID primitive.ObjectID, cutoff time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": userID, "deactivated_at": bson.M{"$lte": cutoff}, "deleting_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deleting_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// UnmarkDeleting takes back MarkDeleting when the deletion could not be queued
+func (s *UserStorage) UnmarkDeleting(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$unset": bson.M{"deleting_at": ""}})
+	return err
+}
+
+// Deactivate flags the user as deactivated, which hides them and blocks normal logins
+func (s *UserStorage) Deactivate(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": userID, "deactivated_at": bson.M{"$exists": false}, "deleting_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deactivated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found, already deactivated or being deleted")
+	}
+
+	return nil
+}
+
+// GetDeactivatedBefore returns the ids of up to limit users deactivated before cutoff
+// whose deletion has not been queued yet, longest deactivated first
+func (s *UserStorage) GetDeactivatedBefore(ctx context.Context, cutoff time.Time, limit int64) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"deactivated_at": bson.M{"$lte": cutoff},
+		"deleting_at":    bson.M{"$exists": false},
+	}
+	opts := options.Find().
+		SetSort(bson.M{"deactivated_at": 1}).
+		SetLimit(limit).
+		SetProjection(bson.M{"_id": 1})
+
+	cursor, err := s.db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+
+	return ids, nil
+}
+
+// UpdateUsername updates a user's username after checking if it's available. The old username
+// goes to the user's history, where it stays reserved for them and redirects to them for a while.
+// Users may only change their username a limited number of times per window. A verified user loses
+// the badge, a moderator has to verify them under the new name again.
 func (s *UserStorage) UpdateUsername(ctx context.Context, userID primitive.ObjectID, newUsername string) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Check if the username is already taken by another user
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var oldUsername string
+	if user.Username != nil {
+		oldUsername = *user.Username
+	}
+	if oldUsername == newUsername {
+		return nil
+	}
+
+	now := time.Now()
+	changes := 0
+	for _, change := range user.UsernameHistory {
+		if change.ChangedAt.After(now.Add(-s.username.ChangeWindow)) {
+			changes++
+		}
+	}
+	if changes >= s.username.ChangeLimit {
+		return dto.ErrUsernameChangeLimit
+	}
+
+	// Check if the username is already taken or reserved by another user
 	isTaken, err := s.isUsernameTaken(ctx, newUsername, userID)
 	if err != nil {
 		return fmt.Errorf("username check failed: %v", err)
 	}
 	if isTaken {
-		return fmt.Errorf("username '%s' is already taken by another user", newUsername)
+		return dto.ErrUsernameTaken
+	}
+
+	update := bson.M{"$set": bson.M{"username": newUsername, "verified": false}}
+	if oldUsername != "" {
+		update["$push"] = bson.M{"username_history": bson.M{
+			"$each":  bson.A{models.UsernameChange{Username: oldUsername, ChangedAt: now}},
+			"$slice": -maxUsernameHistory,
+		}}
 	}
 
-	// Update the username in the database
-	_, err = s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"username": newUsername}})
+	// Filtering on the old username makes concurrent changes count against the limit one at a time
+	result, err := s.db.UpdateOne(ctx, bson.M{"_id": userID, "username": user.Username}, update)
 	if err != nil {
 		return fmt.Errorf("failed to update username: %v", err)
 	}
+	if result.MatchedCount == 0 {
+		return errors.New("username was changed concurrently, try again")
+	}
 
-	return nil
+	return s.refreshSearchGrams(ctx, userID)
 }
 
-// UpdateUser updates Fullname, Bio, and HiddenProfile fields for a user
+// UpdateUser updates Fullname, Bio, and HiddenProfile fields for a user. Changing the full name
+// takes the verified badge away, like changing the username.
 func (s *UserStorage) UpdateUser(ctx context.Context, userID primitive.ObjectID, updateFields bson.M) error {
 
-	// Perform the update
+	// Perform the update, keeping the user as it was to tell whether the name changed
 	filter := bson.M{"_id": userID}
 	update := bson.M{"$set": updateFields}
-	result, err := s.db.UpdateOne(ctx, filter, update)
+	var before models.User
+	err := s.db.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("user not found")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update user: %v", err)
 	}
 
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("user not found")
+	fullname, ok := updateFields["full_name"]
+	if !ok || fullname == before.Fullname {
+		return nil
 	}
-
-	return nil
+	if before.Verified {
+		if _, err := s.db.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"verified": false}}); err != nil {
+			return fmt.Errorf("failed to remove verified badge: %v", err)
+		}
+	}
+	return s.refreshSearchGrams(ctx, userID)
 }
 
-// UpdatePassword updates a user's password after verifying the old password
+// UpdatePassword updates a user's password after verifying the old password. Users created by an
+// external login have none yet and set their first one without.
 func (s *UserStorage) UpdatePassword(ctx context.Context, userID primitive.ObjectID, oldPassword, newPassword string) error {
 	user, err := s.GetUserByID(ctx, userID)
 	if err != nil {
@@ -216,11 +752,15 @@ func (s *UserStorage) UpdatePassword(ctx context.Context, userID primitive.Objec
 	}
 
 	// Verify old password
-	if !CheckPassword(user.Password, oldPassword) {
+	if user.Password != "" && !CheckPassword(user.Password, oldPassword) {
 		return errors.New("incorrect old password")
 	}
 
-	hashedPassword, err := HashPassword(newPassword)
+	if err := s.policy.Check(newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
 	if err != nil {
 		return err
 	}
@@ -232,16 +772,123 @@ func (s *UserStorage) UpdatePassword(ctx context.Context, userID primitive.Objec
 	return err
 }
 
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// SetVerifiedPhone stores a phone number whose ownership the user has proven
+func (s *UserStorage) SetVerifiedPhone(ctx context.Context, userID primitive.ObjectID, phone string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"phone": phone, "phone_verified": true}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return dto.ErrPhoneTaken
+		}
+		return fmt.Errorf("failed to set phone: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
+
+// IsPhoneTaken checks if another user has already verified the phone number
+func (s *UserStorage) IsPhoneTaken(ctx context.Context, phone string, userID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := s.db.CountDocuments(ctx, bson.M{
+		"phone":          phone,
+		"phone_verified": true,
+		"_id":            bson.M{"$ne": userID},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check phone availability: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// GetUserByVerifiedPhone fetches the user that has verified the phone number
+func (s *UserStorage) GetUserByVerifiedPhone(ctx context.Context, phone string) (*models.User, error) {
+	var user models.User
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := s.db.FindOne(ctx, bson.M{"phone": phone, "phone_verified": true}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
 }
 
-// CheckPassword verifies a hashed password
+// CheckPasswordPolicy tells whether password may be set, without setting it
+func (s *UserStorage) CheckPasswordPolicy(password string) error {
+	return s.policy.Check(password)
+}
+
+// ResetPassword sets a new password without knowing the old one and logs the user out everywhere.
+// Callers must have verified the user's identity some other way first.
+func (s *UserStorage) ResetPassword(ctx context.Context, userID primitive.ObjectID, newPassword string) error {
+	if err := s.policy.Check(newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	updateCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(updateCtx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+
+	// Whoever knew the old password may still hold a session
+	_, err = s.session_storage.RevokeAllSessions(ctx, userID)
+	return err
+}
+
+// CheckPassword verifies a password against its stored hash, whichever algorithm made it
 func CheckPassword(hashedPassword, plainPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
-	return err == nil
+	return passwords.Verify(hashedPassword, plainPassword)
+}
+
+// rehashPassword replaces the stored hash of a user who just logged in with password if it was
+// made with an older algorithm or weaker parameters. It is best effort, a failed rehash is
+// retried on the next login.
+func (s *UserStorage) rehashPassword(ctx context.Context, user *models.User, password string) {
+	if !s.hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// matching the old hash keeps a password changed in the meantime from being overwritten
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "password": user.Password},
+		bson.M{"$set": bson.M{"password": hashedPassword}},
+	)
+	if err == nil && result.MatchedCount > 0 {
+		user.Password = hashedPassword
+	}
 }
 
 func (s *UserStorage) SetBackgroundPic(ctx context.Context, userID primitive.ObjectID, pic_id string) error {
@@ -267,15 +914,19 @@ func (s *UserStorage) SetBackgroundPic(ctx context.Context, userID primitive.Obj
 	return nil
 }
 
-// IsUsernameTaken checks if the given username is already taken by another user (excluding the user with the given userID).
+// IsUsernameTaken checks if the given username is already taken by another user (excluding the user with the given userID),
+// or was given up by another user recently enough to still be reserved for them.
 func (s *UserStorage) isUsernameTaken(ctx context.Context, username string, userID primitive.ObjectID) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Query the database to find a user with the given username, excluding the current user
 	filter := bson.M{
-		"username": username,
-		"_id":      bson.M{"$ne": userID}, // Exclude the current user
+		"$or": []bson.M{
+			{"username": username},
+			{"username_history": s.releasedSince(username)},
+		},
+		"_id": bson.M{"$ne": userID}, // Exclude the current user
 	}
 
 	count, err := s.db.CountDocuments(ctx, filter)
@@ -377,3 +1028,143 @@ func (s *UserStorage) GetProfilePictures(ctx context.Context, userID primitive.O
 
 	return user.ProfilePics, nil
 }
+
+// SetRole changes the role of a user
+func (s *UserStorage) SetRole(ctx context.Context, userID primitive.ObjectID, role string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return fmt.Errorf("failed to set role: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return dto.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetVerified grants or takes away the verified badge of the user
+func (s *UserStorage) SetVerified(ctx context.Context, userID primitive.ObjectID, verified bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"verified": verified}})
+	if err != nil {
+		return fmt.Errorf("failed to set verified: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return dto.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// CountByRole returns how many users have exactly the given role
+func (s *UserStorage) CountByRole(ctx context.Context, role string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.db.CountDocuments(ctx, bson.M{"role": role})
+}
+
+// GrantRole gives role to the users with the given IDs and returns how many were changed
+func (s *UserStorage) GrantRole(ctx context.Context, userIDs []primitive.ObjectID, role string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": userIDs}, "role": bson.M{"$ne": role}},
+		bson.M{"$set": bson.M{"role": role}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to grant role: %v", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// GetUsersByRole returns a page of the users with the given role
+func (s *UserStorage) GetUsersByRole(ctx context.Context, role string, page, pageSize int64) ([]*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"role": role}
+	if role == models.RoleUser {
+		// accounts created before roles existed have none
+		filter = bson.M{"role": bson.M{"$in": []any{role, "", nil}}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetSkip((page - 1) * pageSize).
+		SetLimit(pageSize).
+		SetProjection(bson.M{"password": 0, "two_factor": 0})
+
+	cursor, err := s.db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []*models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetLastSeen records when the user was last connected
+func (s *UserStorage) SetLastSeen(ctx context.Context, userID primitive.ObjectID, lastSeen time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"last_seen": lastSeen}})
+	return err
+}
+
+// GetPresenceFields loads what presence needs to know about the users: last seen, its privacy
+// setting and whether the account is active. Users that do not exist are left out.
+func (s *UserStorage) GetPresenceFields(ctx context.Context, userIDs []primitive.ObjectID) ([]*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{
+		"last_seen":      1,
+		"settings":       1,
+		"deactivated_at": 1,
+		"deleting_at":    1,
+	})
+	cursor, err := s.db.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []*models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateSettings replaces the settings of the user
+func (s *UserStorage) UpdateSettings(ctx context.Context, userID primitive.ObjectID, settings *models.UserSettings) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"settings": settings}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return dto.ErrUserNotFound
+	}
+
+	return nil
+}
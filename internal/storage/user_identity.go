@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxGeneratedUsername bounds usernames made up for users created by an external login
+const maxGeneratedUsername = 30
+
+// identityIndex keeps an external account linked to at most one user
+var identityIndex = mongo.IndexModel{
+	Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+	Options: options.Index().
+		SetUnique(true).
+		SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+}
+
+// GetUserByIdentity finds the user who linked the given account at provider
+func (s *UserStorage) GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := s.db.FindOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// LoginWithIdentity logs in the user who linked identity, and creates that user first if nobody has.
+// A new user has no password and a username made from usernameHint; it reports whether one was created.
+func (s *UserStorage) LoginWithIdentity(ctx context.Context, identity models.ExternalIdentity, fullname, usernameHint string, meta *models.SessionMeta) (*models.LoginResult, bool, error) {
+	user, err := s.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	created := false
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		user, err = s.createIdentityUser(ctx, identity, fullname, usernameHint)
+		created = err == nil
+		if mongo.IsDuplicateKeyError(err) {
+			// Another callback for the same account created the user first
+			user, err = s.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if user.DeletingAt != nil {
+		return nil, false, errors.New("this account is being deleted")
+	}
+
+	if user.DeactivatedAt != nil {
+		return nil, false, dto.ErrAccountDeactivated
+	}
+
+	result, err := s.startLogin(ctx, user, meta)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return result, created, nil
+}
+
+func (s *UserStorage) createIdentityUser(ctx context.Context, identity models.ExternalIdentity, fullname, usernameHint string) (*models.User, error) {
+	username, err := s.availableUsername(ctx, usernameHint)
+	if err != nil {
+		return nil, err
+	}
+	if fullname == "" {
+		fullname = username
+	}
+
+	identity.LinkedAt = time.Now()
+	user := &models.User{
+		ID:          primitive.NewObjectIDFromTimestamp(time.Now()),
+		Fullname:    fullname,
+		Username:    &username,
+		Identities:  []models.ExternalIdentity{identity},
+		ProfilePics: []models.ProfilePic{},
+		Role:        models.RoleUser,
+	}
+	user.SearchGrams = searchGrams(user.Username, user.Fullname)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := s.db.InsertOne(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// availableUsername turns hint into a valid username and appends random digits until nobody has it
+func (s *UserStorage) availableUsername(ctx context.Context, hint string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToLower(hint) {
+		if b.Len() >= maxGeneratedUsername-5 {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' {
+			b.WriteRune(r)
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for range 10 {
+		taken, err := s.isUsernameTaken(ctx, candidate, primitive.NilObjectID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+
+		suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s_%04d", base, suffix.Int64())
+	}
+
+	return "", errors.New("failed to find a free username")
+}
+
+// LinkIdentity links an external account to the user
+func (s *UserStorage) LinkIdentity(ctx context.Context, userID primitive.ObjectID, identity models.ExternalIdentity) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	identity.LinkedAt = time.Now()
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": userID, "identities.provider": bson.M{"$ne": identity.Provider}},
+		bson.M{"$push": bson.M{"identities": identity}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return dto.ErrIdentityTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to link account: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		if _, err := s.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+		return dto.ErrIdentityAlreadyLinked
+	}
+
+	return nil
+}
+
+// UnlinkIdentity removes the user's account at provider. The last way to log in, an only
+// identity of a user without a password, cannot be removed.
+func (s *UserStorage) UnlinkIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !user.HasIdentity(provider) {
+		return dto.ErrIdentityNotLinked
+	}
+	if user.Password == "" && len(user.Identities) == 1 {
+		return dto.ErrLastLoginMethod
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Checked again in the filter, so concurrent unlinks cannot remove the last login method together
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{
+			"_id": userID,
+			"$or": []bson.M{
+				{"password": bson.M{"$nin": []any{"", nil}}},
+				{"identities.1": bson.M{"$exists": true}},
+			},
+		},
+		bson.M{"$pull": bson.M{"identities": bson.M{"provider": provider}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unlink account: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return dto.ErrLastLoginMethod
+	}
+
+	return nil
+}
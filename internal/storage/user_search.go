@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// User search works on precomputed grams stored in search_grams:
+//
+//	u:<prefix>   prefixes of the lowercased username
+//	n:<prefix>   prefixes of every word of the full name
+//	t:<trigram>  trigrams of the username and of every word of the full name
+//
+// Prefix grams make "joh" find "john", trigrams tolerate typos ("jhon" still shares "t:hon").
+const (
+	maxSearchPrefix = 20 // longer prefixes are cut, they add little once 20 characters match
+
+	usernamePrefixWeight = 4
+	namePrefixWeight     = 2
+	trigramWeight        = 1
+	exactUsernameBonus   = 10
+
+	backfillBatchSize = 500
+)
+
+// searchGrams returns the grams a user is found by
+func searchGrams(username *string, fullname string) []string {
+	grams := []string{}
+	seen := map[string]bool{}
+	add := func(gram string) {
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+
+	if username != nil {
+		name := normalizeSearch(*username)
+		for _, prefix := range prefixes(name) {
+			add("u:" + prefix)
+		}
+		for _, trigram := range trigrams(name) {
+			add("t:" + trigram)
+		}
+	}
+
+	for _, word := range searchWords(fullname) {
+		for _, prefix := range prefixes(word) {
+			add("n:" + prefix)
+		}
+		for _, trigram := range trigrams(word) {
+			add("t:" + trigram)
+		}
+	}
+
+	return grams
+}
+
+// searchQuery is a query split into the grams it is matched with
+type searchQuery struct {
+	text           string // normalized query, compared against usernames as is
+	usernamePrefix []string
+	namePrefixes   []string
+	trigrams       []string
+}
+
+func newSearchQuery(query string) *searchQuery {
+	text := normalizeSearch(strings.TrimPrefix(strings.TrimSpace(query), "@"))
+	q := &searchQuery{text: text}
+	if text == "" {
+		return q
+	}
+
+	q.usernamePrefix = []string{"u:" + truncateRunes(text, maxSearchPrefix)}
+
+	seen := map[string]bool{}
+	addTrigrams := func(s string) {
+		for _, trigram := range trigrams(s) {
+			if !seen[trigram] {
+				seen[trigram] = true
+				q.trigrams = append(q.trigrams, "t:"+trigram)
+			}
+		}
+	}
+
+	addTrigrams(text)
+	for _, word := range searchWords(text) {
+		q.namePrefixes = append(q.namePrefixes, "n:"+truncateRunes(word, maxSearchPrefix))
+		addTrigrams(word)
+	}
+
+	return q
+}
+
+func (q *searchQuery) grams() []string {
+	grams := append([]string{}, q.usernamePrefix...)
+	grams = append(grams, q.namePrefixes...)
+	return append(grams, q.trigrams...)
+}
+
+// minTrigrams is how many trigrams a user without a prefix match has to share with the query
+func (q *searchQuery) minTrigrams() int {
+	if len(q.trigrams) == 0 {
+		return 1 // nothing to match on, only prefix matches count
+	}
+	return (len(q.trigrams) + 1) / 2
+}
+
+// SearchUsers returns a page of the users matching query, best matches first. Deactivated, deleted
+// and hidden profiles are never returned, nor are the users in excluded.
+func (s *UserStorage) SearchUsers(ctx context.Context, query string, excluded []primitive.ObjectID, page, pageSize int64) ([]*models.UserSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	q := newSearchQuery(query)
+	if q.text == "" {
+		return []*models.UserSummary{}, nil
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	match := bson.M{
+		"search_grams":   bson.M{"$in": q.grams()},
+		"profile_hidden": bson.M{"$ne": true},
+		"deactivated_at": bson.M{"$exists": false},
+		"deleting_at":    bson.M{"$exists": false},
+	}
+	if len(excluded) > 0 {
+		match["_id"] = bson.M{"$nin": excluded}
+	}
+
+	hits := func(grams []string) bson.M {
+		return bson.M{"$size": bson.M{"$setIntersection": bson.A{"$search_grams", grams}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{
+			"username_hits": hits(q.usernamePrefix),
+			"name_hits":     hits(q.namePrefixes),
+			"trigram_hits":  hits(q.trigrams),
+			"exact":         bson.M{"$eq": bson.A{bson.M{"$toLower": "$username"}, q.text}},
+		}}},
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"username_hits": bson.M{"$gt": 0}},
+			bson.M{"name_hits": bson.M{"$gt": 0}},
+			bson.M{"trigram_hits": bson.M{"$gte": q.minTrigrams()}},
+		}}}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$add": bson.A{
+			bson.M{"$multiply": bson.A{"$username_hits", usernamePrefixWeight}},
+			bson.M{"$multiply": bson.A{"$name_hits", namePrefixWeight}},
+			bson.M{"$multiply": bson.A{"$trigram_hits", trigramWeight}},
+			bson.M{"$cond": bson.A{"$exact", exactUsernameBonus, 0}},
+		}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: (page - 1) * pageSize}},
+		{{Key: "$limit", Value: pageSize}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         1,
+			"full_name":   1,
+			"username":    1,
+			"profile_pic": publicProfilePic("$"),
+			"verified":    1,
+		}}},
+	}
+
+	cursor, err := s.db.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	users := []*models.UserSummary{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode search results: %v", err)
+	}
+
+	return users, nil
+}
+
+// refreshSearchGrams recomputes the search grams of the user after their username or name changed
+func (s *UserStorage) refreshSearchGrams(ctx context.Context, userID primitive.ObjectID) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %v", err)
+	}
+
+	grams := searchGrams(user.Username, user.Fullname)
+	if _, err := s.db.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"search_grams": grams}}); err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+
+	return nil
+}
+
+// BackfillSearchGrams computes the search grams of users created before search existed
+// and returns how many users were updated. It runs in batches and is safe to repeat.
+func (s *UserStorage) BackfillSearchGrams(ctx context.Context) (int, error) {
+	opts := options.Find().SetProjection(bson.M{"username": 1, "full_name": 1}).SetBatchSize(backfillBatchSize)
+	cursor, err := s.db.Find(ctx, bson.M{"search_grams": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	batch := make([]mongo.WriteModel, 0, backfillBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := s.db.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		updated += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return updated, err
+		}
+
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": user.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"search_grams": searchGrams(user.Username, user.Fullname)}}))
+
+		if len(batch) == backfillBatchSize {
+			if err := flush(); err != nil {
+				return updated, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+
+	return updated, flush()
+}
+
+func normalizeSearch(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// searchWords splits s into lowercased words of letters and digits
+func searchWords(s string) []string {
+	return strings.FieldsFunc(normalizeSearch(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func prefixes(s string) []string {
+	runes := []rune(s)
+	n := min(len(runes), maxSearchPrefix)
+
+	result := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		result = append(result, string(runes[:i]))
+	}
+	return result
+}
+
+func trigrams(s string) []string {
+	runes := []rune(s)
+	if len(runes) < 3 {
+		return nil
+	}
+
+	result := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		result = append(result, string(runes[i:i+3]))
+	}
+	return result
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	dto "github.com/ruziba3vich/soand/internal/dtos"
+	"github.com/ruziba3vich/soand/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type VerificationStorage struct {
+	db *mongo.Collection
+}
+
+// NewVerificationStorage initializes VerificationStorage
+func NewVerificationStorage(db *mongo.Collection) *VerificationStorage {
+	return &VerificationStorage{db: db}
+}
+
+// EnsureIndexes allows one pending request per user and indexes the moderation queue
+func (s *VerificationStorage) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.VerificationPending}),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	return err
+}
+
+// CreateRequest stores a new pending request, dto.ErrVerificationPending if the user has one already
+func (s *VerificationStorage) CreateRequest(ctx context.Context, request *models.VerificationRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	request.CreatedAt = time.Now()
+	request.ID = primitive.NewObjectIDFromTimestamp(request.CreatedAt)
+	request.Status = models.VerificationPending
+
+	_, err := s.db.InsertOne(ctx, request)
+	if mongo.IsDuplicateKeyError(err) {
+		return dto.ErrVerificationPending
+	}
+	return err
+}
+
+// GetLatestRequest returns the user's most recent request
+func (s *VerificationStorage) GetLatestRequest(ctx context.Context, userID primitive.ObjectID) (*models.VerificationRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var request models.VerificationRequest
+	err := s.db.FindOne(ctx, bson.M{"user_id": userID}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// GetRequestByID fetches a request
+func (s *VerificationStorage) GetRequestByID(ctx context.Context, requestID primitive.ObjectID) (*models.VerificationRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var request models.VerificationRequest
+	if err := s.db.FindOne(ctx, bson.M{"_id": requestID}).Decode(&request); err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// GetPendingRequests returns a page of the pending requests, oldest first
+func (s *VerificationStorage) GetPendingRequests(ctx context.Context, page, pageSize int64) ([]*models.VerificationRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetSkip((page - 1) * pageSize).
+		SetLimit(pageSize)
+
+	cursor, err := s.db.Find(ctx, bson.M{"status": models.VerificationPending}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	requests := []*models.VerificationRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// GetRequestsByUser returns every request of the user, newest first
+func (s *VerificationStorage) GetRequestsByUser(ctx context.Context, userID primitive.ObjectID) ([]*models.VerificationRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.db.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	requests := []*models.VerificationRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// ReviewRequest settles a pending request as approved or rejected. It fails with
+// dto.ErrVerificationReviewed if the request is no longer pending, so two moderators
+// cannot both review it.
+func (s *VerificationStorage) ReviewRequest(ctx context.Context, requestID, reviewerID primitive.ObjectID, status, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"status": status, "reviewer_id": reviewerID, "reviewed_at": time.Now()}
+	if reason != "" {
+		set["reason"] = reason
+	}
+
+	result, err := s.db.UpdateOne(ctx,
+		bson.M{"_id": requestID, "status": models.VerificationPending},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		if _, err := s.GetRequestByID(ctx, requestID); err != nil {
+			return err
+		}
+		return dto.ErrVerificationReviewed
+	}
+
+	return nil
+}
+
+// DeleteByUser removes every request of the user
+func (s *VerificationStorage) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
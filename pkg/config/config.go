@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,10 +14,118 @@ import (
 type (
 	// Config holds all the configuration settings
 	Config struct {
-		MongoDB   MongoDBConfig
-		MinIO     MinIOConfig
-		Redis     RedisConfig
-		JwtSecret string
+		HTTP     HTTPConfig
+		MongoDB  MongoDBConfig
+		MinIO    MinIOConfig
+		Redis    RedisConfig
+		Auth     AuthConfig
+		OTP      OTPConfig
+		SMS      SMSConfig
+		Export   ExportConfig
+		Account  AccountConfig
+		Presence PresenceConfig
+		Username UsernameConfig
+		Password PasswordConfig
+		Login    LoginConfig
+		OIDC     OIDCConfig
+		Post     PostConfig
+	}
+
+	// HTTPConfig holds settings of the HTTP server
+	HTTPConfig struct {
+		TrustedProxies []string // IPs or CIDRs whose X-Forwarded-For is believed for the client IP, none by default
+	}
+
+	// PostConfig limits how long posts live and sets how they expire
+	PostConfig struct {
+		MaxTTL         time.Duration // the longest a post can be set to live, counted from when it is set
+		AllowPermanent bool          // whether creators can make their posts never expire
+		ExpiryWarning  time.Duration // how long before a post expires its creator and viewers are warned
+		SweepInterval  time.Duration // how often expiring posts are looked for
+		Archive        string        // where expired posts are kept before deletion: "collection", "file", or empty to not keep them
+	}
+
+	// OIDCConfig holds the OpenID Connect providers users can log in with
+	OIDCConfig struct {
+		Providers []OIDCProviderConfig
+		StateTTL  time.Duration // how long a user has to finish logging in at the provider
+	}
+
+	// OIDCProviderConfig holds the client registration of soand at one provider
+	OIDCProviderConfig struct {
+		Name         string
+		Issuer       string
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		Scopes       []string
+	}
+
+	// LoginConfig holds the brute-force protection of password logins
+	LoginConfig struct {
+		MaxUserFailures int           // failed logins per username before it is locked out
+		MaxIPFailures   int           // failed logins per IP before it is locked out
+		FailureWindow   time.Duration // how long failed logins are remembered
+		BaseLockout     time.Duration // the first lockout, doubled for every further failure
+		MaxLockout      time.Duration
+		AuditRetention  time.Duration // how long failed logins are kept in the audit log
+	}
+
+	// PasswordConfig holds the Argon2id parameters new password hashes are made with and the password policy.
+	// Hashes made with other parameters are upgraded when their user logs in.
+	PasswordConfig struct {
+		Memory      int // KiB
+		Iterations  int
+		Parallelism int
+		MinLength   int
+		MaxLength   int // bounds the work a single login can cause
+	}
+
+	// UsernameConfig limits username changes
+	UsernameConfig struct {
+		ChangeLimit  int // how many times a user may change their username per ChangeWindow
+		ChangeWindow time.Duration
+		ReserveFor   time.Duration // how long a released username stays reserved and redirects to its last owner
+	}
+
+	// PresenceConfig holds online presence settings
+	PresenceConfig struct {
+		TTL       time.Duration // how long a connection counts as online without a heartbeat
+		Heartbeat time.Duration // how often open connections refresh their presence
+	}
+
+	// AccountConfig holds account lifecycle settings
+	AccountConfig struct {
+		PurgeAfter time.Duration // how long a deactivated account is kept before it is deleted
+	}
+
+	// ExportConfig holds personal data export settings
+	ExportConfig struct {
+		LinkTTL       time.Duration // how long a download link stays valid
+		RetentionDays int           // how long archives are kept in the bucket
+	}
+
+	// OTPConfig holds one-time code settings
+	OTPConfig struct {
+		TTL            time.Duration
+		MaxAttempts    int
+		ResendCooldown time.Duration
+	}
+
+	// SMSConfig selects how text messages are delivered: "log" or "file"
+	SMSConfig struct {
+		Sender     string
+		OutboxFile string
+	}
+
+	// AuthConfig holds token lifetimes and the JWT signing keys location
+	AuthConfig struct {
+		AccessTokenTTL  time.Duration
+		RefreshTokenTTL time.Duration
+		KeysDir         string
+		ActiveKID       string
+		Issuer          string
+		AdminUserIDs    []string // promoted to admin at startup while there is no admin, to bootstrap the first ones
 	}
 
 	// MongoDBConfig holds MongoDB settings
@@ -48,7 +159,68 @@ func LoadConfig() *Config {
 	}
 
 	return &Config{
-		JwtSecret: getEnv("JWT_SECRET", "prodonik"),
+		HTTP: HTTPConfig{
+			TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+			KeysDir:         getEnv("JWT_KEYS_DIR", "keys"),
+			ActiveKID:       getEnv("JWT_ACTIVE_KID", ""),
+			Issuer:          getEnv("JWT_ISSUER", "soand"),
+			AdminUserIDs:    getEnvList("ADMIN_USER_IDS"),
+		},
+		OTP: OTPConfig{
+			TTL:            getEnvDuration("OTP_TTL", 5*time.Minute),
+			MaxAttempts:    getEnvInt("OTP_MAX_ATTEMPTS", 5),
+			ResendCooldown: getEnvDuration("OTP_RESEND_COOLDOWN", time.Minute),
+		},
+		SMS: SMSConfig{
+			Sender:     getEnv("SMS_SENDER", "log"),
+			OutboxFile: getEnv("SMS_OUTBOX_FILE", "sms_outbox.log"),
+		},
+		Account: AccountConfig{
+			PurgeAfter: getEnvDuration("ACCOUNT_PURGE_AFTER", 30*24*time.Hour),
+		},
+		Login: LoginConfig{
+			MaxUserFailures: getEnvInt("LOGIN_MAX_USER_FAILURES", 5),
+			MaxIPFailures:   getEnvInt("LOGIN_MAX_IP_FAILURES", 20),
+			FailureWindow:   getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+			BaseLockout:     getEnvDuration("LOGIN_BASE_LOCKOUT", 30*time.Second),
+			MaxLockout:      getEnvDuration("LOGIN_MAX_LOCKOUT", time.Hour),
+			AuditRetention:  getEnvDuration("LOGIN_AUDIT_RETENTION", 90*24*time.Hour),
+		},
+		Post: PostConfig{
+			MaxTTL:         getEnvDuration("POST_MAX_TTL", 30*24*time.Hour),
+			AllowPermanent: getEnvBool("POST_ALLOW_PERMANENT", true),
+			ExpiryWarning:  getEnvDuration("POST_EXPIRY_WARNING", 30*time.Minute),
+			SweepInterval:  getEnvDuration("POST_EXPIRY_SWEEP_INTERVAL", time.Minute),
+			Archive:        getEnv("POST_ARCHIVE", ""),
+		},
+		OIDC: OIDCConfig{
+			Providers: getOIDCProviders(),
+			StateTTL:  getEnvDuration("OIDC_STATE_TTL", 10*time.Minute),
+		},
+		Password: PasswordConfig{
+			Memory:      getEnvInt("PASSWORD_ARGON2_MEMORY", 64*1024),
+			Iterations:  getEnvInt("PASSWORD_ARGON2_ITERATIONS", 3),
+			Parallelism: getEnvInt("PASSWORD_ARGON2_PARALLELISM", 2),
+			MinLength:   getEnvInt("PASSWORD_MIN_LENGTH", 8),
+			MaxLength:   getEnvInt("PASSWORD_MAX_LENGTH", 128),
+		},
+		Username: UsernameConfig{
+			ChangeLimit:  getEnvInt("USERNAME_CHANGE_LIMIT", 2),
+			ChangeWindow: getEnvDuration("USERNAME_CHANGE_WINDOW", 30*24*time.Hour),
+			ReserveFor:   getEnvDuration("USERNAME_RESERVE_FOR", 30*24*time.Hour),
+		},
+		Presence: PresenceConfig{
+			TTL:       getEnvDuration("PRESENCE_TTL", 90*time.Second),
+			Heartbeat: getEnvDuration("PRESENCE_HEARTBEAT", 30*time.Second),
+		},
+		Export: ExportConfig{
+			LinkTTL:       getEnvDuration("EXPORT_LINK_TTL", time.Hour),
+			RetentionDays: getEnvInt("EXPORT_RETENTION_DAYS", 7),
+		},
 		MongoDB: MongoDBConfig{
 			URI:      getEnv("MONGO_URI", "mongodb://mongo:27017/") + getEnv("MONGO_DB", "mydatabase"),
 			Database: getEnv("MONGO_DB", "mydatabase"),
@@ -70,6 +242,27 @@ func LoadConfig() *Config {
 	}
 }
 
+// getOIDCProviders reads the providers listed in OIDC_PROVIDERS, each configured by
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and _SCOPES
+func getOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS") {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		scopes := strings.Fields(getEnv(prefix+"SCOPES", "openid profile email"))
+		providers = append(providers, OIDCProviderConfig{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:       scopes,
+		})
+	}
+	return providers
+}
+
 // getEnv retrieves environment variables with a fallback default value
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -89,3 +282,36 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+// getEnvBool retrieves a boolean environment variable ("true", "1", "false", "0", ...)
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		boolValue, err := strconv.ParseBool(value)
+		if err == nil {
+			return boolValue
+		}
+	}
+	return fallback
+}
+
+// getEnvList retrieves a comma separated environment variable, skipping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// getEnvDuration retrieves a duration environment variable (e.g. "15m", "720h")
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		duration, err := time.ParseDuration(value)
+		if err == nil {
+			return duration
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,94 @@
+// Package totp implements time-based one-time passwords (RFC 6238) with the
+// defaults authenticator apps expect: SHA-1, 6 digits and a 30 second step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of a generated code
+	Digits = 6
+	// Period is how long a code is valid for
+	Period = 30 * time.Second
+	// Skew is how many steps before and after the current one are still accepted,
+	// covering clock drift between the server and the user's device
+	Skew = 1
+
+	modulo = 1_000_000 // 10^Digits
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160-bit secret, base32 encoded as authenticator apps expect it
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %v", err)
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI builds the otpauth:// URI that authenticator apps import, usually from a QR code
+func URI(issuer, account, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(Digits))
+	values.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// Validate checks code against secret at time t. It returns the time step the code
+// belongs to so callers can refuse a code that was already used.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	current := t.Unix() / int64(Period.Seconds())
+	for step := current - Skew; step <= current+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(generate(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// Code returns the code for secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+	return generate(key, t.Unix()/int64(Period.Seconds())), nil
+}
+
+// generate is the HOTP algorithm from RFC 4226 for the given counter
+func generate(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%modulo)
+}